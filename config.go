@@ -0,0 +1,198 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// The flags that take a comma separated list, a list for one of these in the file gets joined back up with commas
+// -root is a list too but it's given more than once instead, see listFlag
+var configListFlags = map[string]bool{
+	"hash":        true,
+	"include":     true,
+	"exclude":     true,
+	"exclude-dir": true,
+}
+
+// One option from a -config file
+// The keys are the names of the flags, so walkDir = "/data" in the file does the same as -walkDir /data on the command line
+// and there's only the one list of options to keep up to date
+type configValue struct {
+	key    string
+	values []string
+	// Set when the value was a list, which only a list flag like -hash or -root will take
+	list bool
+}
+
+// Reads a -config file, TOML or YAML going by the extension
+// Every option goes on the top level with a string, number, bool or list of those for a value
+// That's everything a flag can take, so a table or a nested map would have nowhere to go and gets turned down
+func loadConfig(name string) ([]configValue, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var values []configValue
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".toml":
+		values, err = parseTOML(data)
+	case ".yaml", ".yml":
+		values, err = parseYAML(data)
+	default:
+		return nil, fmt.Errorf("%s needs to end in .toml, .yaml or .yml so we know how to read it", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return values, nil
+}
+
+// Like
+//
+//	walkDir = "/data"
+//	hash = ["md5", "sha256"]
+//	workers = 4
+func parseTOML(data []byte) ([]configValue, error) {
+	var parsed map[string]interface{}
+	meta, err := toml.Decode(string(data), &parsed)
+	if err != nil {
+		return nil, err
+	}
+	var values []configValue
+	// The metadata has the keys in the order they're in the file, the map doesn't
+	for _, key := range meta.Keys() {
+		if len(key) != 1 {
+			continue
+		}
+		v := configValue{key: key[0]}
+		switch raw := parsed[key[0]].(type) {
+		case []interface{}:
+			v.list = true
+			for _, item := range raw {
+				s, ok := tomlScalar(item)
+				if !ok {
+					return nil, fmt.Errorf("%s: a list can only have strings, numbers or bools in it", v.key)
+				}
+				v.values = append(v.values, s)
+			}
+		default:
+			s, ok := tomlScalar(raw)
+			if !ok {
+				return nil, fmt.Errorf("%s: tables aren't supported, every option goes at the top level", v.key)
+			}
+			v.values = []string{s}
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// Turns a TOML value back into what you'd have typed for the flag
+func tomlScalar(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case time.Time:
+		// A bare TOML date is handy for -modified-since, which takes the same RFC 3339 form
+		return v.Format(time.RFC3339), true
+	}
+	return "", false
+}
+
+// Like
+//
+//	walkDir: /data
+//	exclude:
+//	  - "*.tmp"
+//	  - "*.log"
+func parseYAML(data []byte) ([]configValue, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	// An empty file is no options rather than an error
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	top := doc.Content[0]
+	if top.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected key: value pairs", top.Line)
+	}
+	var values []configValue
+	for i := 0; i+1 < len(top.Content); i += 2 {
+		keyNode, valueNode := top.Content[i], yamlResolve(top.Content[i+1])
+		v := configValue{key: keyNode.Value}
+		switch valueNode.Kind {
+		case yaml.ScalarNode:
+			if valueNode.Tag == "!!null" {
+				return nil, fmt.Errorf("line %d: %s needs a value", keyNode.Line, v.key)
+			}
+			v.values = []string{valueNode.Value}
+		case yaml.SequenceNode:
+			v.list = true
+			for _, item := range valueNode.Content {
+				if item = yamlResolve(item); item.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("line %d: %s: a list can only have strings, numbers or bools in it", item.Line, v.key)
+				}
+				v.values = append(v.values, item.Value)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: nested keys aren't supported, every option goes at the top level", keyNode.Line)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// Follows a *alias to what it points to, so anchors work the way you'd expect
+func yamlResolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+// Sets every flag the config file has a value for, apart from the ones given on the command line since those win
+// A list goes to a flag like -root one item at a time and to a comma separated one like -hash joined back up,
+// any other flag only takes the one value so a list for it is a mistake we'd rather tell you about than guess at
+func applyConfig(flags *flag.FlagSet, values []configValue, onCommandLine map[string]bool) error {
+	for _, v := range values {
+		f := flags.Lookup(v.key)
+		if f == nil || v.key == "config" {
+			return fmt.Errorf("%q isn't an option goindex has", v.key)
+		}
+		if onCommandLine[v.key] {
+			continue
+		}
+		_, repeatable := f.Value.(*listFlag)
+		switch {
+		case v.list && repeatable:
+			for _, item := range v.values {
+				if err := f.Value.Set(item); err != nil {
+					return fmt.Errorf("%s: %s", v.key, err)
+				}
+			}
+			continue
+		case v.list && !configListFlags[v.key]:
+			return fmt.Errorf("%s only takes one value, not a list", v.key)
+		}
+		if err := flags.Set(v.key, strings.Join(v.values, ",")); err != nil {
+			return fmt.Errorf("%s: %s", v.key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A few of the real flags, one of each kind applyConfig has to deal with
+type testFlags struct {
+	set          *flag.FlagSet
+	walkDir      *string
+	workers      *int
+	hash         *string
+	verbose      *bool
+	includeRegex *string
+	roots        listFlag
+}
+
+func newTestFlags(t *testing.T, args ...string) *testFlags {
+	f := &testFlags{set: flag.NewFlagSet("goindex", flag.ContinueOnError)}
+	f.walkDir = f.set.String("walkDir", ".", "")
+	f.workers = f.set.Int("workers", 4, "")
+	f.hash = f.set.String("hash", "sha256", "")
+	f.verbose = f.set.Bool("verbose", false, "")
+	f.includeRegex = f.set.String("include-regex", "", "")
+	f.set.Var(&f.roots, "root", "")
+	f.set.String("config", "", "")
+	if err := f.set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// Loads the file and applies it the way main does, with whatever was on the command line winning
+func (f *testFlags) apply(t *testing.T, name, contents string) error {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	values, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	onCommandLine := make(map[string]bool)
+	f.set.Visit(func(fl *flag.Flag) {
+		onCommandLine[fl.Name] = true
+	})
+	return applyConfig(f.set, values, onCommandLine)
+}
+
+func TestConfigCommandLineWins(t *testing.T) {
+	files := map[string]string{
+		"options.toml": `# the usual scan
+walkDir = "/data"
+workers = 2
+hash = ["md5", "sha256"]
+root = ["/a", "/b,c"]
+verbose = true
+include-regex = '^report\d+\.csv$'
+`,
+		"options.yaml": `# the usual scan
+walkDir: /data
+workers: 2
+hash: [md5, sha256]
+root:
+  - /a
+  - "/b,c"
+verbose: true
+include-regex: '^report\d+\.csv$'
+`,
+	}
+	for name, contents := range files {
+		f := newTestFlags(t, "-workers", "8", "-walkDir", "/elsewhere")
+		if err := f.apply(t, name, contents); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if *f.walkDir != "/elsewhere" || *f.workers != 8 {
+			t.Errorf("%s: got walkDir %q and workers %d, want the command line's /elsewhere and 8", name, *f.walkDir, *f.workers)
+		}
+		if *f.hash != "md5,sha256" || !*f.verbose || *f.includeRegex != `^report\d+\.csv$` {
+			t.Errorf("%s: got hash %q, verbose %v and include-regex %q from the file", name, *f.hash, *f.verbose, *f.includeRegex)
+		}
+		if strings.Join(f.roots, "|") != "/a|/b,c" {
+			t.Errorf("%s: got roots %q, want /a and /b,c", name, f.roots)
+		}
+	}
+}
+
+func TestConfigRejects(t *testing.T) {
+	tests := []struct {
+		name, contents, want string
+	}{
+		{"list.toml", `include-regex = ["a", "b"]`, "only takes one value"},
+		{"list.yaml", "workers: [1, 2]", "only takes one value"},
+		{"unknown.toml", `colour = "red"`, "isn't an option"},
+		{"config.yaml", "config: other.yaml", "isn't an option"},
+		{"table.toml", "[scan]\nwalkDir = \"/data\"", "tables aren't supported"},
+		{"nested.yaml", "scan:\n  walkDir: /data", "nested keys"},
+		{"bad.toml", `workers = `, ""},
+		{"options.ini", "workers = 2", ".toml, .yaml or .yml"},
+	}
+	for _, test := range tests {
+		f := newTestFlags(t)
+		err := f.apply(t, test.name, test.contents)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s got %v, want an error about %q", test.name, err, test.want)
+		}
+	}
+}
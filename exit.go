@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"goindex/index"
+)
+
+// What goindex exits with, so a script can tell what happened without having to read stderr
+// They're printed at the bottom of -h too
+const (
+	// Every file got hashed and written
+	exitOK = 0
+	// We couldn't get going, like a bad flag value or an output we couldn't open
+	exitFailed = 1
+	// The flag package exits with 2 when it can't make sense of the command line, so that one's taken
+	exitUsage = 2
+	// The run got to the end but some files couldn't be read, they're in the error log and not in the output
+	exitFileErrors = 3
+	// Ctrl-C or -timeout stopped the run early, the output is valid but it only has what got done before then
+	exitInterrupted = 4
+	// -verify found files that changed or went missing
+	exitMismatch = 5
+	// The walk itself failed, like walkDir not being readable or -walk-errors-fatal stopping at the first error
+	exitWalkFailed = 6
+)
+
+func printExitCodes(w io.Writer) {
+	fmt.Fprintf(w, "\nExit codes:\n")
+	fmt.Fprintf(w, "  %d  everything was hashed\n", exitOK)
+	fmt.Fprintf(w, "  %d  couldn't start or couldn't write the output\n", exitFailed)
+	fmt.Fprintf(w, "  %d  the flags couldn't be parsed\n", exitUsage)
+	fmt.Fprintf(w, "  %d  finished but some files couldn't be read\n", exitFileErrors)
+	fmt.Fprintf(w, "  %d  interrupted or timed out\n", exitInterrupted)
+	fmt.Fprintf(w, "  %d  -verify found changed or missing files\n", exitMismatch)
+	fmt.Fprintf(w, "  %d  the walk itself failed\n", exitWalkFailed)
+}
+
+// Works out what to exit with once Index is done, the worst thing that happened wins
+// Being stopped early comes first since it means everything after it is missing, not just the odd file
+func exitCode(err error, stats index.Stats, verifying bool) int {
+	var walkErr *index.WalkError
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return exitInterrupted
+	case errors.As(err, &walkErr):
+		return exitWalkFailed
+	case err != nil:
+		return exitFailed
+	case verifying && (stats.Mismatched > 0 || stats.Resized > 0 || stats.Missing > 0):
+		return exitMismatch
+	case stats.Errors > 0:
+		return exitFileErrors
+	}
+	return exitOK
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// Lets the tests run goindex itself, a test binary started with GOINDEX_RUN_MAIN set is main with the arguments after --
+func TestMain(m *testing.M) {
+	if os.Getenv("GOINDEX_RUN_MAIN") != "" {
+		for i, arg := range os.Args {
+			if arg == "--" {
+				os.Args = append(os.Args[:1], os.Args[i+1:]...)
+				break
+			}
+		}
+		main()
+		os.Exit(exitOK)
+	}
+	os.Exit(m.Run())
+}
+
+// Runs goindex with args and returns what it exited with and everything it printed
+func runGoindex(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^$", "--"}, args...)...)
+	cmd.Env = append(os.Environ(), "GOINDEX_RUN_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return exitOK, string(out)
+	case errors.As(err, &exit):
+		return exit.ExitCode(), string(out)
+	}
+	t.Fatalf("running goindex: %s", err)
+	return 0, ""
+}
+
+func TestExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "files.csv")
+	type exitTest struct {
+		name string
+		args []string
+		want int
+	}
+	tests := []exitTest{
+		{"ok", []string{"-walkDir", dir, "-output", output}, exitOK},
+		{"bad flag", []string{"-no-such-flag"}, exitUsage},
+		{"bad value", []string{"-walkDir", dir, "-output", output, "-format", "nope"}, exitFailed},
+		{"missing walkDir", []string{"-walkDir", filepath.Join(dir, "missing"), "-output", output}, exitWalkFailed},
+		// An output that can't be created is a failure to start, not the walk's
+		{"bad output", []string{"-walkDir", dir, "-output", filepath.Join(dir, "missing", "files.csv")}, exitFailed},
+		{"timed out", []string{"-walkDir", dir, "-output", output, "-timeout", "1ns"}, exitInterrupted},
+	}
+	// Verifying against an index where a.txt has a different hash
+	verify := filepath.Join(t.TempDir(), "old.csv")
+	if err := ioutil.WriteFile(verify, []byte("Path,SHA256 Hash,Time,Size\n"+filepath.Join(dir, "a.txt")+","+fmt.Sprintf("%x", sha256.Sum256([]byte("b")))+",2020-01-01T00:00:00Z,1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests = append(tests, exitTest{"verify mismatch", []string{"-walkDir", dir, "-output", output, "-verify", verify}, exitMismatch})
+	// A broken symlink can't be opened, that's a file error and not a failed run
+	if runtime.GOOS != "windows" {
+		broken := t.TempDir()
+		if err := ioutil.WriteFile(filepath.Join(broken, "a.txt"), []byte("a"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(broken, "nowhere"), filepath.Join(broken, "broken")); err != nil {
+			t.Fatal(err)
+		}
+		tests = append(tests, exitTest{"file errors", []string{"-walkDir", broken, "-output", output}, exitFileErrors})
+	}
+	// A directory that can't be read is only a file error, unless -walk-errors-fatal says it's the end of the run
+	if runtime.GOOS != "windows" && os.Geteuid() != 0 {
+		locked := t.TempDir()
+		if err := os.Mkdir(filepath.Join(locked, "locked"), 0); err != nil {
+			t.Fatal(err)
+		}
+		tests = append(tests,
+			exitTest{"walk error", []string{"-walkDir", locked, "-output", output}, exitFileErrors},
+			exitTest{"walk error fatal", []string{"-walkDir", locked, "-output", output, "-walk-errors-fatal"}, exitWalkFailed},
+		)
+	}
+
+	for _, test := range tests {
+		if got, out := runGoindex(t, test.args...); got != test.want {
+			t.Errorf("%s exited with %d, want %d:\n%s", test.name, got, test.want, out)
+		}
+	}
+}
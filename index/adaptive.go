@@ -0,0 +1,172 @@
+package index
+
+import (
+	"sync"
+	"time"
+)
+
+// How often an adaptive pool looks at the latency and decides whether to change size
+const adaptInterval = 250 * time.Millisecond
+
+// What ReadLatencyTarget defaults to, a local disk opens a file well under this and a network mount usually doesn't
+const defaultReadLatencyTarget = 5 * time.Millisecond
+
+// How much of the rolling average is the newest file, the rest is everything before it
+// Low enough that one slow file doesn't shrink the pool, high enough that a mount going slow shows up within a second or so
+const latencyWeight = 0.1
+
+// Sizes the read pool for Options.ReadWorkersMax while the run goes, rather than sticking to one number for the whole tree.
+// All ReadWorkersMax readers are started, but a reader has to hold a permit to read a file and only size of them go round.
+// Every adaptInterval the rolling average of how long a file took to open is checked against the target. Under it and
+// with files waiting another permit goes out, one at a time. Over twice the target and half of them are taken back,
+// like TCP backs off, because piling more readers onto storage that's already struggling only makes every one of them slower.
+// A permit that's being taken back goes away when its reader is done with it, nothing gets interrupted
+type readerPool struct {
+	permits chan struct{}
+	min     int
+	max     int
+	target  time.Duration
+	// How many files are waiting for a reader, there's no point growing the pool when it's the walk that's slow
+	waiting func() int
+	logger  *Logger
+
+	mu sync.Mutex
+	// How many readers the pool is meant to have right now
+	size int
+	// Permits to take back as they come in, after the pool shrank while they were out
+	owed int
+	// The rolling average open latency and how many files it's had since the last check
+	avg     float64
+	samples int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// nil for a max of 0, which means the pool is just ReadWorkers and doesn't change. A nil readerPool doesn't hold anything back
+func newReaderPool(min, max int, target time.Duration, waiting func() int, logger *Logger) *readerPool {
+	if max <= 0 {
+		return nil
+	}
+	if min <= 0 {
+		min = 1
+	}
+	if target <= 0 {
+		target = defaultReadLatencyTarget
+	}
+	p := &readerPool{permits: make(chan struct{}, max), min: min, max: max, target: target, waiting: waiting, logger: logger, size: min, stop: make(chan struct{}), done: make(chan struct{})}
+	// It starts small and works its way up, a network mount never gets hit with max readers all at once
+	for i := 0; i < min; i++ {
+		p.permits <- struct{}{}
+	}
+	go p.run()
+	return p
+}
+
+// How many reader goroutines to start, with a pool that's however many it can ever grow to
+func (p *readerPool) workers(fixed int) int {
+	if p == nil {
+		return fixed
+	}
+	return p.max
+}
+
+// Waits for a permit to read a file
+func (p *readerPool) acquire() {
+	if p == nil {
+		return
+	}
+	<-p.permits
+}
+
+// Hands the permit back, unless the pool has shrunk since and it's one of the ones being taken away
+func (p *readerPool) release() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.owed > 0 {
+		p.owed--
+		return
+	}
+	p.permits <- struct{}{}
+}
+
+// Adds how long a file took to open to the rolling average
+func (p *readerPool) observe(latency time.Duration) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.avg == 0 {
+		p.avg = float64(latency)
+	} else {
+		p.avg += latencyWeight * (float64(latency) - p.avg)
+	}
+	p.samples++
+}
+
+func (p *readerPool) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(adaptInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.adjust()
+		}
+	}
+}
+
+func (p *readerPool) adjust() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Nothing finished opening since the last check, every reader could be stuck on one huge file so there's nothing to go on
+	if p.samples == 0 {
+		return
+	}
+	p.samples = 0
+	latency := time.Duration(p.avg)
+	size := p.size
+	switch {
+	case latency > 2*p.target && p.size > p.min:
+		size = p.size / 2
+		if size < p.min {
+			size = p.min
+		}
+	case latency < p.target && p.size < p.max && p.waiting() > 0:
+		size = p.size + 1
+	}
+	if size == p.size {
+		return
+	}
+	p.logger.Verbosef("Opening a file is taking %s, going from %d readers to %d", latency.Round(time.Microsecond), p.size, size)
+	for ; p.size < size; p.size++ {
+		// A permit that was still owed back never left, so not taking it back is the same as handing out a new one
+		if p.owed > 0 {
+			p.owed--
+		} else {
+			p.permits <- struct{}{}
+		}
+	}
+	for ; p.size > size; p.size-- {
+		select {
+		case <-p.permits:
+		default:
+			p.owed++
+		}
+	}
+}
+
+// Stops the controller, once the readers are done
+func (p *readerPool) close() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+}
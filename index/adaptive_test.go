@@ -0,0 +1,159 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A pool with its controller stopped, so the test is the only thing calling adjust
+func testReaderPool(t *testing.T, min, max int, waiting *int, log *bytes.Buffer) *readerPool {
+	t.Helper()
+	p := newReaderPool(min, max, 10*time.Millisecond, func() int { return *waiting }, NewLogger(log, LogVerbose))
+	p.close()
+	return p
+}
+
+// Every file opening in latency, then a check
+func simulateLatency(p *readerPool, latency time.Duration, files int) {
+	for i := 0; i < files; i++ {
+		p.observe(latency)
+	}
+	p.adjust()
+}
+
+// Grows one at a time while opens are quick and files are waiting, halves when they get slow, never past min or max
+func TestReaderPoolAdapts(t *testing.T) {
+	waiting := 100
+	var log bytes.Buffer
+	p := testReaderPool(t, 2, 8, &waiting, &log)
+	if p.workers(3) != 8 || len(p.permits) != 2 {
+		t.Fatalf("started %d readers with %d permits, want 8 readers and 2 permits", p.workers(3), len(p.permits))
+	}
+
+	var sizes []int
+	for i := 0; i < 8; i++ {
+		simulateLatency(p, time.Millisecond, 10)
+		sizes = append(sizes, p.size)
+	}
+	if got := fmt.Sprint(sizes); got != "[3 4 5 6 7 8 8 8]" {
+		t.Errorf("quick opens grew the pool through %s, want one at a time up to 8", got)
+	}
+	if len(p.permits) != 8 {
+		t.Errorf("got %d permits for a pool of 8", len(p.permits))
+	}
+
+	// Between the target and twice it the pool's about right, it stays where it is
+	simulateLatency(p, 15*time.Millisecond, 100)
+	if p.size != 8 {
+		t.Errorf("a latency just over the target took the pool to %d", p.size)
+	}
+
+	// It's a rolling average, one slow file among the rest in a check isn't enough
+	p.observe(100 * time.Millisecond)
+	simulateLatency(p, 15*time.Millisecond, 9)
+	if p.size != 8 {
+		t.Errorf("one slow file took the pool to %d", p.size)
+	}
+
+	sizes = nil
+	for i := 0; i < 3; i++ {
+		simulateLatency(p, 100*time.Millisecond, 50)
+		sizes = append(sizes, p.size)
+	}
+	if got := fmt.Sprint(sizes); got != "[4 2 2]" {
+		t.Errorf("slow opens shrank the pool through %s, want it halved down to the min of 2", got)
+	}
+	if len(p.permits) != 2 || p.owed != 0 {
+		t.Errorf("got %d permits and %d owed for a pool of 2", len(p.permits), p.owed)
+	}
+	if !strings.Contains(log.String(), "going from 8 readers to 4") {
+		t.Errorf("the log doesn't say it shrank:\n%s", log.String())
+	}
+}
+
+// It doesn't grow when the readers are already keeping up with the walk, or change at all with nothing to go on
+func TestReaderPoolHolds(t *testing.T) {
+	waiting := 0
+	p := testReaderPool(t, 1, 4, &waiting, &bytes.Buffer{})
+	simulateLatency(p, time.Millisecond, 10)
+	if p.size != 1 {
+		t.Errorf("grew to %d with nothing waiting", p.size)
+	}
+	waiting = 5
+	simulateLatency(p, time.Millisecond, 10)
+	simulateLatency(p, time.Millisecond, 10)
+	// No files opened since the last check, every reader might be on one huge file
+	p.adjust()
+	p.adjust()
+	if p.size != 3 {
+		t.Errorf("got to %d, want 3 from the two checks that had files", p.size)
+	}
+}
+
+// Taking permits back while readers hold them, they're kept as they come in and growing again gives them back first
+func TestReaderPoolShrinksWhileBusy(t *testing.T) {
+	waiting := 100
+	p := testReaderPool(t, 1, 8, &waiting, &bytes.Buffer{})
+	for p.size < 8 {
+		simulateLatency(p, time.Millisecond, 1)
+	}
+	for i := 0; i < 8; i++ {
+		p.acquire()
+	}
+	simulateLatency(p, time.Second, 50)
+	if p.size != 4 || p.owed != 4 {
+		t.Fatalf("got a size of %d with %d owed, want 4 of each", p.size, p.owed)
+	}
+	for i := 0; i < 3; i++ {
+		p.release()
+	}
+	if len(p.permits) != 0 || p.owed != 1 {
+		t.Errorf("got %d permits back with %d still owed, want none back and 1 owed", len(p.permits), p.owed)
+	}
+	simulateLatency(p, time.Millisecond, 50)
+	if p.size != 5 || p.owed != 0 || len(p.permits) != 0 {
+		t.Errorf("growing again got a size of %d, %d owed and %d permits, want the owed one kept", p.size, p.owed, len(p.permits))
+	}
+	for i := 0; i < 5; i++ {
+		p.release()
+	}
+	if len(p.permits) != 5 {
+		t.Errorf("got %d permits for a pool of 5 with nothing reading", len(p.permits))
+	}
+}
+
+// No max means no pool, and a nil pool doesn't get in the way of anything
+func TestReaderPoolOff(t *testing.T) {
+	p := newReaderPool(1, 0, 0, func() int { return 0 }, NewLogger(ioutil.Discard, LogVerbose))
+	if p != nil {
+		t.Fatalf("got a pool for a max of 0")
+	}
+	p.acquire()
+	p.observe(time.Second)
+	p.release()
+	p.close()
+	if p.workers(3) != 3 {
+		t.Errorf("a nil pool started %d readers, want the fixed 3", p.workers(3))
+	}
+}
+
+// Through Index with the pool on every file still gets hashed the once
+func TestReadWorkersMax(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		files[fmt.Sprintf("d%d/f%d.txt", i%10, i)] = fmt.Sprint(i)
+	}
+	writeTree(t, dir, files)
+	opts := testOptions(dir)
+	opts.ReadWorkersMin = 1
+	opts.ReadWorkersMax = 6
+	byPath := indexByPath(t, opts)
+	if len(byPath) != len(files) {
+		t.Errorf("got %d records, want %d", len(byPath), len(files))
+	}
+}
@@ -0,0 +1,52 @@
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Only the files that look different from the base index get read, the rest keep the hash it had for them
+func TestBaseReusesUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"same.txt": "same", "touched.txt": "touched", "resized.txt": "resized"})
+	out, _ := runIndex(t, testOptions(dir))
+	// A hash nothing would really give shows which rows came from the base rather than the file
+	stale := sha256Hex("stale")
+	for _, content := range []string{"same", "touched", "resized"} {
+		out = strings.ReplaceAll(out, sha256Hex(content), stale)
+	}
+	name := filepath.Join(t.TempDir(), "base.csv")
+	if err := ioutil.WriteFile(name, []byte(out), 0644); err != nil {
+		t.Fatal(err)
+	}
+	base, err := LoadIndex(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "touched.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(filepath.Join(dir, "resized.txt"))
+	writeTree(t, dir, map[string]string{"resized.txt": "a different size"})
+	os.Chtimes(filepath.Join(dir, "resized.txt"), info.ModTime(), info.ModTime())
+	writeTree(t, dir, map[string]string{"new.txt": "new"})
+
+	opts := testOptions(dir)
+	opts.Base = base
+	byPath := indexByPath(t, opts)
+	want := map[string]string{"same.txt": stale, "touched.txt": sha256Hex("touched"), "resized.txt": sha256Hex("a different size"), "new.txt": sha256Hex("new")}
+	if len(byPath) != len(want) {
+		t.Errorf("got %d records, want %d", len(byPath), len(want))
+	}
+	for name, hash := range want {
+		if got := byPath[filepath.Join(dir, name)]["SHA256 Hash"]; got != hash {
+			t.Errorf("%s got %s, want %s", name, got, hash)
+		}
+	}
+}
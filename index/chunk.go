@@ -0,0 +1,130 @@
+package index
+
+import "math/bits"
+
+// The smallest average chunk size Options.ChunkSize can be, anything below this and the rows outweigh the data
+const minChunkSize = 64
+
+// One piece of a file for Options.Chunks, Hashes lines up with Options.Hashes same as a record's
+type fileChunk struct {
+	Offset int64
+	Length int64
+	Hashes []string
+}
+
+// The random numbers the rolling hash mixes in for every byte, one per possible byte value
+// They're made from a fixed seed rather than crypto/rand because every run on every machine has to cut a file in the same places,
+// otherwise the chunk hashes from two indexes would never line up
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	// splitmix64, it's tiny and spreads the bits out well enough for this
+	seed := uint64(0x676f696e646578)
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Splits whatever gets written to it into content defined chunks and hashes each one, this is FastCDC more or less
+// A chunk ends wherever the rolling hash of the last 64 bytes happens to hit a pattern, so where the cuts go only depends
+// on the bytes around them. Change something in the middle of a file and only the chunks around the change get new hashes,
+// everything after it gets cut in the same places as before, just shifted along. That's what makes them good for dedup
+// The chunks are somewhere between a quarter and 8 times the average, with most of them close to it
+type chunker struct {
+	hashNames []string
+	encoding  string
+	min, avg  int64
+	max       int64
+	// More bits have to be zero to cut before we get to the average size than after it, that's what keeps most chunks close to it
+	maskSmall, maskLarge uint64
+
+	chunks []fileChunk
+	h      *multiHasher
+	// Where the chunk we're in the middle of starts and how much of it we've seen
+	offset, length int64
+	fp             uint64
+}
+
+func newChunker(avg int, hashNames []string, encoding string) *chunker {
+	// The top bits of the hash are the ones that depend on all of the last 64 bytes, the bottom bit only depends on the last one
+	// Averaging avg bytes means a 1 in avg chance of a cut at every byte, so that's log2(avg) bits that have to be zero
+	n := uint(bits.Len(uint(avg)) - 1)
+	return &chunker{
+		hashNames: hashNames,
+		encoding:  encoding,
+		min:       int64(avg) / 4,
+		avg:       int64(avg),
+		max:       int64(avg) * 8,
+		maskSmall: ^uint64(0) << (64 - (n + 1)),
+		maskLarge: ^uint64(0) << (64 - (n - 1)),
+		h:         newMultiHasher(hashNames, encoding),
+	}
+}
+
+func (c *chunker) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n, cut := c.scan(p)
+		c.h.Write(p[:n])
+		c.length += int64(n)
+		p = p[n:]
+		if cut {
+			c.cut()
+		}
+	}
+	return written, nil
+}
+
+// Looks for where the current chunk ends in p, n is how much of p belongs to it
+func (c *chunker) scan(p []byte) (n int, cut bool) {
+	for i, b := range p {
+		size := c.length + int64(i) + 1
+		// Nothing below the minimum can be a cut, so there's no point rolling the hash over it
+		if size <= c.min {
+			continue
+		}
+		c.fp = c.fp<<1 + gearTable[b]
+		mask := c.maskSmall
+		if size >= c.avg {
+			mask = c.maskLarge
+		}
+		if c.fp&mask == 0 || size >= c.max {
+			return i + 1, true
+		}
+	}
+	return len(p), false
+}
+
+// Finishes off the chunk we're in the middle of and starts a new one
+func (c *chunker) cut() {
+	c.chunks = append(c.chunks, fileChunk{Offset: c.offset, Length: c.length, Hashes: c.h.sums()})
+	c.offset += c.length
+	c.length, c.fp = 0, 0
+	c.h = newMultiHasher(c.hashNames, c.encoding)
+}
+
+// Every chunk of the file, call it once everything has been written
+// An empty file still gets one empty chunk so it shows up in the index like it would without chunking
+func (c *chunker) finish() []fileChunk {
+	if c.length > 0 || len(c.chunks) == 0 {
+		c.cut()
+	}
+	return c.chunks
+}
+
+// The rows a chunked file gets written as, one per chunk with the chunk's hashes and length in place of the file's
+// Everything else is the same as the record for the whole file would have had
+func (r record) chunkRecords() []record {
+	records := make([]record, len(r.Chunks))
+	for i, chunk := range r.Chunks {
+		rec := r
+		rec.Chunks = nil
+		rec.Hashes, rec.Size, rec.Offset = chunk.Hashes, chunk.Length, chunk.Offset
+		records[i] = rec
+	}
+	return records
+}
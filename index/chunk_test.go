@@ -0,0 +1,102 @@
+package index
+
+import (
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// The chunks of a file as they came out of a run, in offset order
+func indexChunks(t *testing.T, dir string, avg int) []map[string]string {
+	t.Helper()
+	opts := testOptions(dir)
+	opts.Chunks = true
+	opts.ChunkSize = avg
+	out, _ := runIndex(t, opts)
+	chunks := parseCSV(t, out)
+	sort.Slice(chunks, func(i, j int) bool {
+		a, _ := strconv.ParseInt(chunks[i]["Offset"], 10, 64)
+		b, _ := strconv.ParseInt(chunks[j]["Offset"], 10, 64)
+		return a < b
+	})
+	return chunks
+}
+
+// The chunks cover the file end to end, none of them outside a quarter to 8 times the average
+func TestChunksCoverFile(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+	writeTree(t, dir, map[string]string{"data": string(data)})
+	const avg = 4096
+
+	chunks := indexChunks(t, dir, avg)
+	if len(chunks) < 16 {
+		t.Fatalf("only got %d chunks out of %d bytes", len(chunks), len(data))
+	}
+	var offset int64
+	for i, c := range chunks {
+		start, _ := strconv.ParseInt(c["Offset"], 10, 64)
+		size, _ := strconv.ParseInt(c["Size"], 10, 64)
+		if start != offset {
+			t.Fatalf("chunk %d starts at %d, the one before ended at %d", i, start, offset)
+		}
+		// The last one is whatever's left over
+		if size > 8*avg || (size < avg/4 && i != len(chunks)-1) {
+			t.Errorf("chunk %d is %d bytes", i, size)
+		}
+		if c["SHA256 Hash"] != sha256Hex(string(data[start:start+size])) {
+			t.Errorf("chunk %d at %d has the wrong hash", i, start)
+		}
+		if c["Path"] != filepath.Join(dir, "data") {
+			t.Errorf("chunk %d is for %s", i, c["Path"])
+		}
+		offset += size
+	}
+	if offset != int64(len(data)) {
+		t.Errorf("the chunks add up to %d bytes, the file is %d", offset, len(data))
+	}
+}
+
+// Putting a few bytes in the middle only changes the chunks around them, everything after is cut in the same places shifted along
+func TestChunksEditInMiddle(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 256<<10)
+	rand.New(rand.NewSource(2)).Read(data)
+	writeTree(t, dir, map[string]string{"data": string(data)})
+	before := indexChunks(t, dir, 4096)
+
+	mid := len(data) / 2
+	edited := string(data[:mid]) + "a few more bytes" + string(data[mid:])
+	writeTree(t, dir, map[string]string{"data": edited})
+	after := indexChunks(t, dir, 4096)
+
+	old := make(map[string]bool)
+	for _, c := range before {
+		old[c["SHA256 Hash"]] = true
+	}
+	changed := 0
+	for _, c := range after {
+		if !old[c["SHA256 Hash"]] {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 2 {
+		t.Errorf("%d of %d chunks changed, want 1 or 2 around the edit", changed, len(after))
+	}
+	if len(after) < len(before)-1 || len(after) > len(before)+1 {
+		t.Errorf("went from %d chunks to %d", len(before), len(after))
+	}
+}
+
+// An empty file still gets a row, one empty chunk
+func TestChunksEmptyFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"empty": ""})
+	chunks := indexChunks(t, dir, 4096)
+	if len(chunks) != 1 || chunks[0]["Offset"] != "0" || chunks[0]["Size"] != "0" || chunks[0]["SHA256 Hash"] != sha256Hex("") {
+		t.Errorf("got %v", chunks)
+	}
+}
@@ -0,0 +1,73 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A column of your own for Options.ExtraColumns, worked out for every file from what we already know about it
+// Something like whether the file is executable or a tag picked from the path, without having to change goindex itself
+type Column struct {
+	// What goes in the header, and the key in the extra object for ndjson and json
+	Name string
+	// Called once for every file with the path it was found at and the info from when it was opened,
+	// it gets called from every worker at once so it has to be safe for that
+	Value func(path string, info os.FileInfo) string
+}
+
+// Bad columns would otherwise only show up as a panic half way through the walk
+func checkColumns(columns []Column) error {
+	seen := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		if c.Name == "" {
+			return fmt.Errorf("every extra column needs a name")
+		}
+		if c.Value == nil {
+			return fmt.Errorf("extra column %q has nothing to work out its value", c.Name)
+		}
+		// LoadIndex goes by the header, so one of ours or anything that looks like a hash column would confuse it
+		if isBuiltInColumn(c.Name) {
+			return fmt.Errorf("extra column %q has the same name as one of goindex's own columns", c.Name)
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("there's more than one extra column called %q", c.Name)
+		}
+		seen[c.Name] = true
+	}
+	return nil
+}
+
+// The CSV names and the sqlite, sql and parquet ones too, an extra column keeps its name in a table so it can't be one of those either
+// Tables don't care about case, so neither does this
+func isBuiltInColumn(name string) bool {
+	for _, builtIn := range []string{"Path", "Hash", "Time", "Size", "Root", "Host", "Offset", "Mime", "Type", "Target", "Owner", "Group", "Mode", "Change", "HashMs", "Error",
+		"mod_time", "chunk_offset", "group_name", "hash_ms"} {
+		if strings.EqualFold(name, builtIn) {
+			return true
+		}
+	}
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, " hash") || strings.HasSuffix(lower, "_hash")
+}
+
+// Works out every extra column for a file, in the same order as Options.ExtraColumns
+func (opts Options) extraValues(osPathname string, info os.FileInfo) []string {
+	if len(opts.ExtraColumns) == 0 {
+		return nil
+	}
+	values := make([]string, len(opts.ExtraColumns))
+	for i, c := range opts.ExtraColumns {
+		values[i] = c.Value(osPathname, info)
+	}
+	return values
+}
+
+// The header names for the extra columns, in order
+func columnNames(columns []Column) []string {
+	var names []string
+	for _, c := range columns {
+		names = append(names, c.Name)
+	}
+	return names
+}
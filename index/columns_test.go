@@ -0,0 +1,67 @@
+package index
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func lengthColumn() Column {
+	return Column{Name: "NameLength", Value: func(path string, info os.FileInfo) string {
+		return strconv.Itoa(len(filepath.Base(path)))
+	}}
+}
+
+func TestExtraColumnCSV(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "longer.txt": "b"})
+	opts := testOptions(dir)
+	opts.ExtraColumns = []Column{lengthColumn()}
+
+	byPath := indexByPath(t, opts)
+	if got := byPath[filepath.Join(dir, "a.txt")]["NameLength"]; got != "5" {
+		t.Errorf("a.txt got NameLength %q, want 5", got)
+	}
+	if got := byPath[filepath.Join(dir, "longer.txt")]["NameLength"]; got != "10" {
+		t.Errorf("longer.txt got NameLength %q, want 10", got)
+	}
+}
+
+func TestExtraColumnJSON(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a"})
+	opts := testOptions(dir)
+	opts.Format = "json"
+	opts.ExtraColumns = []Column{lengthColumn()}
+
+	out, _ := runIndex(t, opts)
+	var records []struct {
+		Path  string            `json:"path"`
+		Extra map[string]string `json:"extra"`
+	}
+	if err := json.Unmarshal([]byte(out), &records); err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+	if len(records) != 1 || records[0].Extra["NameLength"] != "5" {
+		t.Errorf("got %+v, want one record with NameLength 5", records)
+	}
+}
+
+// Every one of these is already a column in the CSV or in a table, whatever the case
+func TestExtraColumnNameClashes(t *testing.T) {
+	for _, name := range []string{"Path", "path", "SIZE", "Time", "HashMs", "hash_ms", "mod_time", "chunk_offset", "group_name", "sha256_hash", "MD5 Hash", "hash", "error"} {
+		opts := testOptions(t.TempDir())
+		opts.ExtraColumns = []Column{{Name: name, Value: lengthColumn().Value}}
+		if err := opts.Validate(); err == nil || !strings.Contains(err.Error(), "own columns") {
+			t.Errorf("extra column %q got %v, want it turned down", name, err)
+		}
+	}
+	opts := testOptions(t.TempDir())
+	opts.ExtraColumns = []Column{lengthColumn(), lengthColumn()}
+	if err := opts.Validate(); err == nil {
+		t.Errorf("two extra columns with the same name were allowed")
+	}
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Counting has to come out with what a dry run and a real run would have done with the same filters
+func TestCountOnlyMatchesFilters(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a.go": "a", "b.go": "b", "sub/c.go": "c", "sub/d_test.go": "d",
+		"notes.md": "not included", ".hidden/e.go": "hidden", "sub/.f.go": "hidden too",
+	})
+	if err := os.Symlink(filepath.Join(dir, "nowhere.go"), filepath.Join(dir, "broken.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "a.go"), filepath.Join(dir, "link.go")); err != nil {
+		t.Fatal(err)
+	}
+	filtered := func() Options {
+		opts := testOptions(dir)
+		opts.LogOutput = ioutil.Discard
+		opts.Include = []string{"*.go"}
+		opts.Exclude = []string{"*_test.go"}
+		opts.SkipHidden = true
+		return opts
+	}
+	opts := filtered()
+	want := indexedPaths(t, opts)
+	if !equalStrings(want, []string{"a.go", "b.go", "link.go", "sub/c.go"}) {
+		t.Fatalf("the real run got %v", want)
+	}
+
+	opts = filtered()
+	opts.DryRun = true
+	_, dry := runIndex(t, opts)
+	opts.CountOnly = true
+	_, count := runIndex(t, opts)
+	if count.Files != int64(len(want)) || dry.Files != count.Files {
+		t.Errorf("counted %d files and the dry run %d, want %d", count.Files, dry.Files, len(want))
+	}
+	if count.Errors != 1 {
+		t.Errorf("got %d errors, want the broken link", count.Errors)
+	}
+}
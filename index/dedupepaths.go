@@ -0,0 +1,55 @@
+package index
+
+// A recordWriter for Options.DedupePaths, it gets every record in path order from a sortingWriter and only passes on
+// the last of each run of records with the same path. The sort is stable so the last one in a run is the last one
+// that was written, and like Options.Sort it spills to temporary files so a huge index doesn't have to fit in memory
+type dedupePathsWriter struct {
+	rw recordWriter
+	// The record we're holding on to until we know nothing after it has the same path
+	last    record
+	holding bool
+}
+
+func newDedupePathsWriter(rw recordWriter, tempDir string) recordWriter {
+	return newSortingWriter(&dedupePathsWriter{rw: rw}, dedupeLess, tempDir)
+}
+
+// Path order like recordLess, with the Root column in there too so the same relative path from two roots isn't a duplicate
+func dedupeLess(a, b record) bool {
+	if a.Path != b.Path {
+		return a.Path < b.Path
+	}
+	if a.Offset != b.Offset {
+		return a.Offset < b.Offset
+	}
+	return a.Root < b.Root
+}
+
+// The chunks of a file with Options.Chunks all have its path, they're only the same record if they start at the same place
+func samePath(a, b record) bool {
+	return a.Path == b.Path && a.Offset == b.Offset && a.Root == b.Root
+}
+
+func (d *dedupePathsWriter) WriteHeader() error {
+	return d.rw.WriteHeader()
+}
+
+func (d *dedupePathsWriter) WriteRecord(r record) error {
+	if d.holding && !samePath(d.last, r) {
+		if err := d.rw.WriteRecord(d.last); err != nil {
+			return err
+		}
+	}
+	d.last, d.holding = r, true
+	return nil
+}
+
+func (d *dedupePathsWriter) Flush() error {
+	if d.holding {
+		d.holding = false
+		if err := d.rw.WriteRecord(d.last); err != nil {
+			return err
+		}
+	}
+	return d.rw.Flush()
+}
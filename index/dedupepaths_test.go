@@ -0,0 +1,91 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// Keeps whatever it's given so the test can look at exactly what came out the other end
+type collectWriter struct {
+	records []record
+}
+
+func (c *collectWriter) WriteHeader() error { return nil }
+
+func (c *collectWriter) WriteRecord(r record) error {
+	c.records = append(c.records, r)
+	return nil
+}
+
+func (c *collectWriter) Flush() error { return nil }
+
+// More than two runs' worth of records with plenty of paths written more than once, every path comes out once
+// in order with the last record written for it. Size is how far along it was written so we can tell which one we got
+func TestDedupePathsKeepsLast(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	n := 2*sortRunSize + sortRunSize/2
+	last := make(map[string]int64)
+	collected := &collectWriter{}
+	tempDir := t.TempDir()
+	rw := newDedupePathsWriter(collected, tempDir)
+	for i := 0; i < n; i++ {
+		path := fmt.Sprintf("/data/f%07d", rng.Intn(n/2))
+		last[path] = int64(i)
+		if err := rw.WriteRecord(record{Path: path, Hashes: []string{"x"}, Size: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Two chunks of the same file aren't duplicates of each other, the same path under another root isn't either
+	for _, r := range []record{{Path: "/chunked", Offset: 0}, {Path: "/chunked", Offset: 100}, {Path: "/chunked", Offset: 100, Size: 1}, {Path: "/chunked", Root: "/other"}} {
+		r.Hashes = []string{"x"}
+		rw.WriteRecord(r)
+	}
+	if runs := len(rw.(*sortingWriter).runs); runs < 2 {
+		t.Fatalf("only %d runs went to disk, the merge isn't being tested", runs)
+	}
+	if err := rw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := collected.records
+	if len(got) != len(last)+3 {
+		t.Fatalf("got %d records, want %d", len(got), len(last)+3)
+	}
+	if c := got[:3]; c[0].Offset != 0 || c[0].Root != "" || c[1].Root != "/other" || c[2].Offset != 100 || c[2].Size != 1 {
+		t.Errorf("the chunked file came out as %+v", c)
+	}
+	for i, r := range got[3:] {
+		if r.Size != last[r.Path] {
+			t.Fatalf("%s got the record written %d, want the last one, %d", r.Path, r.Size, last[r.Path])
+		}
+		if i > 0 && got[3+i-1].Path >= r.Path {
+			t.Fatalf("%s came after %s", r.Path, got[3+i-1].Path)
+		}
+	}
+}
+
+// A root inside walkDir gives its files twice, DedupePaths brings that back to once each
+func TestDedupePathsOverlappingRoots(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "sub/b.txt": "b", "sub/deeper/c.txt": "c"})
+	opts := testOptions(dir)
+	opts.Roots = []string{filepath.Join(dir, "sub")}
+	out, _ := runIndex(t, opts)
+	if rows := parseCSV(t, out); len(rows) != 5 {
+		t.Fatalf("got %d rows without DedupePaths, want the two files under sub twice", len(rows))
+	}
+
+	opts.DedupePaths = true
+	opts.TempDir = t.TempDir()
+	out, _ = runIndex(t, opts)
+	var got []string
+	for _, row := range parseCSV(t, out) {
+		rel, _ := filepath.Rel(dir, row["Path"])
+		got = append(got, filepath.ToSlash(rel))
+	}
+	if want := []string{"a.txt", "sub/b.txt", "sub/deeper/c.txt"}; !equalStrings(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
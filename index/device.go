@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import (
+	"os"
+	"syscall"
+)
+
+// The device the filesystem osPathname is on lives on, for Options.OneFilesystem
+// Every mount gets its own, so a directory with a different one from the root is where something else is mounted
+// It goes through symlinks since a followed link to another filesystem is just as much somewhere we don't want to go
+func deviceID(osPathname string) (uint64, bool) {
+	info, err := os.Stat(osPathname)
+	if err != nil {
+		return 0, false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
@@ -0,0 +1,39 @@
+//go:build linux
+// +build linux
+
+package index
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// A tmpfs mounted part way down the tree is left out, unless it's a root of its own
+// Mounting needs root or a user namespace that allows it, the test is skipped without either
+func TestOneFilesystem(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"outside.txt": "outside", "mnt/hidden.txt": "under the mount"})
+	mnt := filepath.Join(dir, "mnt")
+	if err := syscall.Mount("tmpfs", mnt, "tmpfs", 0, ""); err != nil {
+		t.Skipf("can't mount a tmpfs: %s", err)
+	}
+	defer syscall.Unmount(mnt, 0)
+	writeTree(t, dir, map[string]string{"mnt/inside.txt": "inside", "mnt/deeper/more.txt": "more"})
+
+	opts := testOptions(dir)
+	if got, want := indexedPaths(t, opts), []string{"mnt/deeper/more.txt", "mnt/inside.txt", "outside.txt"}; !equalStrings(got, want) {
+		t.Errorf("without OneFilesystem got %v, want %v", got, want)
+	}
+	opts.OneFilesystem = true
+	if got, want := indexedPaths(t, opts), []string{"outside.txt"}; !equalStrings(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Every root is compared to its own device
+	opts.Roots = []string{mnt}
+	byPath := indexByPath(t, opts)
+	if _, ok := byPath[filepath.Join(mnt, "inside.txt")]; !ok || len(byPath) != 3 {
+		t.Errorf("with the mount as a root too got %v", byPath)
+	}
+}
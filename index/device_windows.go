@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package index
+
+// Drives on Windows have their own letters so walking C:\ never ends up on D:\ anyway.
+// A drive mounted in a folder would need the volume of every directory looked up, which we don't do, so this never knows
+func deviceID(osPathname string) (uint64, bool) {
+	return 0, false
+}
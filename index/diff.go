@@ -0,0 +1,57 @@
+package index
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Compares two indexes and writes a line for everything that changed between them, sorted by path.
+// New files start with a +, removed files with a - and files whose hash changed with a ~
+// Nothing on disk gets touched, it's all worked out from the two files
+func Diff(oldIndex, newIndex *IndexFile, w io.Writer) error {
+	// We can only compare hashes made with the same algorithm, so pick the first one both indexes have
+	oldCol, newCol := -1, -1
+	for i, name := range newIndex.hashNames {
+		for j, have := range oldIndex.hashNames {
+			if have == name {
+				oldCol, newCol = j, i
+				break
+			}
+		}
+		if newCol >= 0 {
+			break
+		}
+	}
+	if newCol < 0 {
+		return fmt.Errorf("the indexes don't have any hash algorithm in common, one has %v and the other has %v", oldIndex.hashNames, newIndex.hashNames)
+	}
+
+	// Every path from both sides, so we can walk through them in order
+	var paths []string
+	for path := range oldIndex.records {
+		paths = append(paths, path)
+	}
+	for path := range newIndex.records {
+		if _, ok := oldIndex.records[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	bw := bufio.NewWriter(w)
+	for _, path := range paths {
+		oldRec, inOld := oldIndex.records[path]
+		newRec, inNew := newIndex.records[path]
+		switch {
+		case !inOld:
+			fmt.Fprintf(bw, "+ %s\n", path)
+		case !inNew:
+			fmt.Fprintf(bw, "- %s\n", path)
+		case !sameSum(newIndex.hashNames[newCol], oldRec.Hashes[oldCol], newRec.Hashes[newCol]):
+			fmt.Fprintf(bw, "~ %s\n", path)
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,35 @@
+package index
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	// The old one is how the first versions wrote it, with a space after every comma
+	oldIndex := loadIndexText(t, "Path, Hash, Time\n"+
+		"/d/same.txt, "+sha256Hex("same")+", 2021-01-01 00:00:00 +0000 UTC\n"+
+		"/d/changed.txt, "+sha256Hex("before")+", 2021-01-01 00:00:00 +0000 UTC\n"+
+		"/d/removed.txt, "+sha256Hex("removed")+", 2021-01-01 00:00:00 +0000 UTC\n")
+	newIndex := loadIndexText(t, "Path,SHA256 Hash,Time,Size\n"+
+		"/d/same.txt,"+sha256Hex("same")+",2021-02-01 00:00:00 +0000 UTC,4\n"+
+		"/d/changed.txt,"+sha256Hex("after")+",2021-02-01 00:00:00 +0000 UTC,5\n"+
+		"/d/added.txt,"+sha256Hex("added")+",2021-02-01 00:00:00 +0000 UTC,5\n")
+
+	var out bytes.Buffer
+	if err := Diff(oldIndex, newIndex, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "+ /d/added.txt\n~ /d/changed.txt\n- /d/removed.txt\n"; out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestDiffNoCommonHash(t *testing.T) {
+	md5Index := loadIndexText(t, "Path,MD5 Hash,Time\n/d/a.txt,5d41402abc4b2a76b9719d911017c592,2021-01-01 00:00:00 +0000 UTC\n")
+	shaIndex := loadIndexText(t, "Path,SHA256 Hash,Time\n/d/a.txt,"+sha256Hex("hello")+",2021-01-01 00:00:00 +0000 UTC\n")
+	if err := Diff(md5Index, shaIndex, ioutil.Discard); err == nil {
+		t.Errorf("compared an md5 index with a sha256 one")
+	}
+}
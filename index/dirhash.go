@@ -0,0 +1,121 @@
+package index
+
+import (
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// What goes in the Type column with Options.DirHashes, file is what every file gets with Options.RecordSymlinks as well
+const (
+	typeFile = "file"
+	typeDir  = "dir"
+)
+
+// A recordWriter for Options.DirHashes, it passes every file through and adds a record for every directory above them
+// A directory's hash is made out of the names and hashes of everything directly inside it, so it changes if anything
+// anywhere below it does. That's the same trick git uses for its trees, comparing two roots tells you if anything in the whole tree differs.
+// A directory can't be worked out until everything in it is, so this holds on to every record until Flush,
+// which writes them all sorted by path so every directory comes before what's in it
+type dirHashWriter struct {
+	rw        recordWriter
+	hashNames []string
+	encoding  string
+	// The record path of WalkDir, nothing above this gets a directory record
+	root    string
+	records []record
+}
+
+func newDirHashWriter(rw recordWriter, hashNames []string, encoding string, root string) *dirHashWriter {
+	return &dirHashWriter{rw: rw, hashNames: hashNames, encoding: encoding, root: root}
+}
+
+func (d *dirHashWriter) WriteHeader() error {
+	return d.rw.WriteHeader()
+}
+
+func (d *dirHashWriter) WriteRecord(r record) error {
+	r.Type = typeFile
+	d.records = append(d.records, r)
+	return nil
+}
+
+// One thing directly inside a directory, either a file or another directory
+type dirEntry struct {
+	name   string
+	record *record
+}
+
+// Works out every directory's record and writes everything out
+func (d *dirHashWriter) Flush() error {
+	// Every directory we've seen, with what's directly inside it
+	children := make(map[string][]dirEntry)
+	dirs := make(map[string]*record)
+
+	var addDir func(dir string)
+	addDir = func(dir string) {
+		if _, ok := dirs[dir]; ok {
+			return
+		}
+		dirs[dir] = &record{Path: dir, Type: typeDir}
+		// Stop at the root, checking for the top of the filesystem too just so a bad path can't loop forever
+		if parent := filepath.Dir(dir); dir != d.root && parent != dir {
+			addDir(parent)
+			children[parent] = append(children[parent], dirEntry{name: filepath.Base(dir), record: dirs[dir]})
+		}
+	}
+	for i := range d.records {
+		r := &d.records[i]
+		// WalkDir was a single file, there aren't any directories to hash
+		if r.Path == d.root {
+			continue
+		}
+		parent := filepath.Dir(r.Path)
+		addDir(parent)
+		children[parent] = append(children[parent], dirEntry{name: filepath.Base(r.Path), record: r})
+	}
+
+	// Works from the bottom up so every directory's children already have their hashes when it gets to them
+	var fill func(dir *record)
+	fill = func(dir *record) {
+		entries := children[dir.Path]
+		sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+		for _, e := range entries {
+			if e.record.Type == typeDir {
+				fill(e.record)
+			}
+			dir.Size += e.record.Size
+			if e.record.ModTime.After(dir.ModTime) {
+				dir.ModTime = e.record.ModTime
+			}
+		}
+
+		// Every algorithm gets its own directory hash made from that algorithm's hashes of the children
+		dir.Hashes = make([]string, len(d.hashNames))
+		for i, name := range d.hashNames {
+			h, _ := newHasher(name)
+			for _, e := range entries {
+				// The type is in there so renaming a file to where a directory was can't give the same hash,
+				// and a NUL can't be in a name so no two different directories can give the same input
+				io.WriteString(h, e.record.Type+" "+e.name+"\x00"+e.record.Hashes[i]+"\n")
+			}
+			dir.Hashes[i] = encodeSum(h.Sum(nil), d.encoding)
+		}
+	}
+	// Validate turns down ResolveLinks, so every path is under the root and filling it fills everything
+	if top, ok := dirs[d.root]; ok {
+		fill(top)
+	}
+
+	all := d.records
+	for _, dir := range dirs {
+		all = append(all, *dir)
+	}
+	sortRecords(all, recordLess)
+	for _, r := range all {
+		if err := d.rw.WriteRecord(r); err != nil {
+			return err
+		}
+	}
+	return d.rw.Flush()
+}
@@ -0,0 +1,81 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The directory hashes from a run over dir, keyed by path relative to it with the root as .
+func dirHashes(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	opts := testOptions(dir)
+	opts.DirHashes = true
+	hashes := make(map[string]string)
+	for path, rec := range indexByPath(t, opts) {
+		if rec["Type"] != typeDir {
+			continue
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[filepath.ToSlash(rel)] = rec["SHA256 Hash"]
+	}
+	return hashes
+}
+
+// Whatever changes below a directory changes its hash and every one above it, and nothing else
+func TestDirHashes(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"top.txt": "top", "a/b/deep.txt": "deep", "a/side.txt": "side", "c/other.txt": "other", "empty/x": ""})
+	before := dirHashes(t, dir)
+	for _, d := range []string{".", "a", "a/b", "c", "empty"} {
+		if before[d] == "" {
+			t.Fatalf("no hash for %s in %v", d, before)
+		}
+	}
+
+	// Nothing's different, and a new modified time on its own isn't a change to what's in there
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(dir, "c", "other.txt"), later, later)
+	if again := dirHashes(t, dir); !sameHashes(again, before) {
+		t.Errorf("nothing changed but the hashes went from %v to %v", before, again)
+	}
+
+	checkChanged := func(what string, changed ...string) {
+		t.Helper()
+		after := dirHashes(t, dir)
+		want := make(map[string]bool)
+		for _, d := range changed {
+			want[d] = true
+		}
+		for d, hash := range before {
+			if (after[d] != hash) != want[d] {
+				t.Errorf("after %s, %s changed is %v, want %v", what, d, after[d] != hash, want[d])
+			}
+		}
+		before = after
+	}
+	writeTree(t, dir, map[string]string{"a/b/deep.txt": "deeper"})
+	checkChanged("changing a/b/deep.txt", ".", "a", "a/b")
+	writeTree(t, dir, map[string]string{"c/new.txt": "new"})
+	checkChanged("adding c/new.txt", ".", "c")
+	if err := os.Rename(filepath.Join(dir, "a", "side.txt"), filepath.Join(dir, "a", "moved.txt")); err != nil {
+		t.Fatal(err)
+	}
+	checkChanged("renaming a/side.txt", ".", "a")
+}
+
+func sameHashes(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
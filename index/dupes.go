@@ -0,0 +1,217 @@
+package index
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A recordWriter for Options.Dupes, instead of writing every file it only writes the files that share a hash with another file
+// We can't know a file is a duplicate until everything has been hashed, so this holds every record in memory
+// and does all of the writing in Flush once the workers are done
+type dupesWriter struct {
+	out *dupeGroupWriter
+	// The first hash you asked for is the one we group on
+	groups map[string][]string
+}
+
+func newDupesWriter(format string, w io.Writer, hashNames []string) *dupesWriter {
+	return &dupesWriter{
+		out:    newDupeGroupWriter(format, w, hashNames[0]),
+		groups: make(map[string][]string),
+	}
+}
+
+// The header is written in Flush along with everything else
+func (d *dupesWriter) WriteHeader() error {
+	return nil
+}
+
+func (d *dupesWriter) WriteRecord(r record) error {
+	d.groups[r.Hashes[0]] = append(d.groups[r.Hashes[0]], r.Path)
+	return nil
+}
+
+// Writes out every group with more than one file in it
+// Groups are sorted by hash and the paths in each group are sorted too, so running it twice gives you the same file
+func (d *dupesWriter) Flush() error {
+	var hashes []string
+	for h, paths := range d.groups {
+		if len(paths) > 1 {
+			hashes = append(hashes, h)
+		}
+	}
+	sort.Strings(hashes)
+
+	if err := d.out.start(); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		paths := d.groups[h]
+		sort.Strings(paths)
+		if err := d.out.group(h, paths); err != nil {
+			return err
+		}
+	}
+	return d.out.finish()
+}
+
+// A recordWriter for Options.DupesExternal, it gets every record in hash order from a sortingWriter
+// so all it has to do is write out each run of the same hash that's longer than one. Only one group is ever in memory,
+// the sortingWriter in front of it is what keeps memory flat, however many files there are
+type externalDupesWriter struct {
+	out     *dupeGroupWriter
+	started bool
+	// The group we're in the middle of
+	hash  string
+	paths []string
+}
+
+func newExternalDupesWriter(format string, w io.Writer, hashNames []string) *externalDupesWriter {
+	return &externalDupesWriter{out: newDupeGroupWriter(format, w, hashNames[0])}
+}
+
+// Same as the in memory one, the header goes out with the first group or in Flush if there aren't any
+func (d *externalDupesWriter) WriteHeader() error {
+	return nil
+}
+
+func (d *externalDupesWriter) WriteRecord(r record) error {
+	if !d.started {
+		d.started = true
+		if err := d.out.start(); err != nil {
+			return err
+		}
+	}
+	if r.Hashes[0] != d.hash {
+		if err := d.endGroup(); err != nil {
+			return err
+		}
+		d.hash = r.Hashes[0]
+	}
+	d.paths = append(d.paths, r.Path)
+	return nil
+}
+
+func (d *externalDupesWriter) endGroup() error {
+	defer func() { d.paths = d.paths[:0] }()
+	if len(d.paths) < 2 {
+		return nil
+	}
+	return d.out.group(d.hash, d.paths)
+}
+
+func (d *externalDupesWriter) Flush() error {
+	if !d.started {
+		d.started = true
+		if err := d.out.start(); err != nil {
+			return err
+		}
+	}
+	if err := d.endGroup(); err != nil {
+		return err
+	}
+	return d.out.finish()
+}
+
+// Everything a sortingWriter keeps for externalDupesWriter, the rest of the record would only make the temporary files bigger
+type dupePairWriter struct {
+	rw recordWriter
+}
+
+func (d dupePairWriter) WriteHeader() error {
+	return d.rw.WriteHeader()
+}
+
+func (d dupePairWriter) WriteRecord(r record) error {
+	return d.rw.WriteRecord(record{Path: r.Path, Hashes: r.Hashes[:1]})
+}
+
+func (d dupePairWriter) Flush() error {
+	return d.rw.Flush()
+}
+
+// What each line of ndjson looks like when we're writing duplicates, json writes an array of them
+type jsonDupeGroup struct {
+	Hash  string   `json:"hash"`
+	Paths []string `json:"paths"`
+}
+
+// Writes groups of duplicates one at a time in Options.Format, both dupes writers go through this so they write exactly the same file
+type dupeGroupWriter struct {
+	format   string
+	w        io.Writer
+	hashName string
+	cw       *csv.Writer
+	enc      *json.Encoder
+	groups   int
+}
+
+func newDupeGroupWriter(format string, w io.Writer, hashName string) *dupeGroupWriter {
+	return &dupeGroupWriter{format: strings.ToLower(format), w: w, hashName: hashName, cw: csv.NewWriter(w), enc: json.NewEncoder(w)}
+}
+
+// For CSV every file in a group gets its own row, the rows for a group are next to each other since they share the hash
+// TSV is the same rows with tabs in them
+func (g *dupeGroupWriter) start() error {
+	header := []string{strings.ToUpper(g.hashName) + " Hash", "Path"}
+	switch g.format {
+	case "ndjson", "json":
+		return nil
+	case "tsv":
+		return writeTSVRow(g.w, header)
+	}
+	return g.cw.Write(header)
+}
+
+// paths have to be sorted already
+func (g *dupeGroupWriter) group(hash string, paths []string) error {
+	g.groups++
+	switch g.format {
+	case "ndjson":
+		return g.enc.Encode(jsonDupeGroup{Hash: hash, Paths: paths})
+	case "json":
+		// The array goes out a group at a time, it's all on one line same as encoding the whole thing at once would give you
+		separator := ","
+		if g.groups == 1 {
+			separator = "["
+		}
+		b, err := json.Marshal(jsonDupeGroup{Hash: hash, Paths: paths})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(g.w, separator+string(b))
+		return err
+	}
+	for _, p := range paths {
+		var err error
+		if g.format == "tsv" {
+			err = writeTSVRow(g.w, []string{hash, p})
+		} else {
+			err = g.cw.Write([]string{hash, p})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// No duplicates at all comes out as [] for json rather than nothing
+func (g *dupeGroupWriter) finish() error {
+	switch g.format {
+	case "ndjson", "tsv":
+		return nil
+	case "json":
+		end := "]\n"
+		if g.groups == 0 {
+			end = "[]\n"
+		}
+		_, err := io.WriteString(g.w, end)
+		return err
+	}
+	g.cw.Flush()
+	return g.cw.Error()
+}
@@ -0,0 +1,80 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// Both dupes writers fed the same records, more than two runs' worth so the external one has to merge temporary files
+// They have to write exactly the same groups, byte for byte, in csv and ndjson
+func TestDupesExternalMatchesInMemory(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	n := 2*sortRunSize + sortRunSize/2
+	records := make([]record, n)
+	for i := range records {
+		// A hash from a range smaller than the number of records, so there are plenty of groups and plenty of singles
+		records[i] = record{Path: fmt.Sprintf("/data/f%07d", rng.Intn(10*n)), Hashes: []string{fmt.Sprintf("%08x", rng.Intn(n))}}
+	}
+
+	for _, format := range []string{"csv", "ndjson"} {
+		var inMemory, external bytes.Buffer
+		memory := newDupesWriter(format, &inMemory, []string{"sha256"})
+		tempDir := t.TempDir()
+		sorter := newSortingWriter(newExternalDupesWriter(format, &external, []string{"sha256"}), hashLess, tempDir)
+		ext := dupePairWriter{sorter}
+		for _, rw := range []recordWriter{memory, ext} {
+			if err := rw.WriteHeader(); err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range records {
+				if err := rw.WriteRecord(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if len(sorter.runs) < 2 {
+			t.Fatalf("only %d runs went to disk, the merge isn't being tested", len(sorter.runs))
+		}
+		for _, rw := range []recordWriter{memory, ext} {
+			if err := rw.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if inMemory.Len() == 0 || !bytes.Equal(inMemory.Bytes(), external.Bytes()) {
+			t.Errorf("%s: the in memory dupes wrote %d bytes and the external ones %d, they aren't the same", format, inMemory.Len(), external.Len())
+		}
+		// The runs are cleaned up and they went where they were told to
+		if left, _ := ioutil.ReadDir(tempDir); len(left) != 0 {
+			t.Errorf("%d temporary files were left in the temp dir", len(left))
+		}
+	}
+}
+
+// The same through Index, only files that share a hash with another one come out
+func TestDupes(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "same", "b/a.txt": "same", "c.txt": "same", "d.txt": "different", "e.txt": "pair", "f.txt": "pair"})
+	var outputs []string
+	for _, external := range []bool{false, true} {
+		opts := testOptions(dir)
+		opts.Dupes = true
+		opts.DupesExternal = external
+		opts.TempDir = t.TempDir()
+		out, _ := runIndex(t, opts)
+		outputs = append(outputs, out)
+	}
+	rows := parseCSV(t, outputs[0])
+	groups := make(map[string]int)
+	for _, row := range rows {
+		groups[row["SHA256 Hash"]]++
+	}
+	if len(rows) != 5 || groups[sha256Hex("same")] != 3 || groups[sha256Hex("pair")] != 2 {
+		t.Errorf("got %v", rows)
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("in memory got\n%s\nexternal got\n%s", outputs[0], outputs[1])
+	}
+}
@@ -0,0 +1,74 @@
+package index
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Keeps track of every file or directory we couldn't read
+// Errors get logged, and if Options.ErrorLog is set they also get written there as a CSV with the path and the error
+// onError is Options.OnError, nil if nobody asked
+type errorLog struct {
+	mu      sync.Mutex
+	w       *csv.Writer
+	stats   *runStats
+	logger  *Logger
+	onError func(path string, err error)
+	// With Options.ErrorsInline this writes the error row into the output, Index sets it once the writer is going
+	inline func(osPathname string, err error)
+	// With Options.FileLimit this gives the file's place back, a file that failed doesn't count towards the limit
+	failed func(osPathname string)
+}
+
+func newErrorLog(w io.Writer, stats *runStats, logger *Logger, onError func(path string, err error)) *errorLog {
+	el := &errorLog{stats: stats, logger: logger, onError: onError}
+	if w != nil {
+		el.w = csv.NewWriter(w)
+		el.w.Write([]string{"Path", "Error"})
+	}
+	return el
+}
+
+// Records an error for one path, this gets called from all of the workers at once
+func (el *errorLog) add(osPathname string, err error) {
+	el.log(osPathname, err)
+	if el.inline != nil {
+		el.inline(osPathname, err)
+	}
+	if el.failed != nil {
+		el.failed(osPathname)
+	}
+}
+
+// Everything add does apart from the error row, for a file that's getting a record anyway with the error in it
+func (el *errorLog) log(osPathname string, err error) {
+	el.stats.addError()
+	// Before taking the lock, a slow callback shouldn't hold up every other worker that's got an error to log
+	if el.onError != nil {
+		el.onError(osPathname, err)
+	}
+
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	// Errors from os already have the path in them, ours don't
+	if strings.Contains(err.Error(), osPathname) {
+		el.logger.Errorf("%s", err)
+	} else {
+		el.logger.Errorf("%s: %s", osPathname, err)
+	}
+	if el.w != nil {
+		el.w.Write([]string{osPathname, err.Error()})
+	}
+}
+
+func (el *errorLog) flush() error {
+	if el.w == nil {
+		return nil
+	}
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	el.w.Flush()
+	return el.w.Error()
+}
@@ -0,0 +1,226 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// One file we can't open doesn't stop the run, it's counted and goes in the error log with what went wrong
+func TestUnreadableFileLogged(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read a file whatever its permissions")
+	}
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "locked.txt": "locked", "b.txt": "b"})
+	locked := filepath.Join(dir, "locked.txt")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0644)
+
+	opts := testOptions(dir)
+	opts.LogOutput = ioutil.Discard
+	var errorLog bytes.Buffer
+	opts.ErrorLog = &errorLog
+	var reported []string
+	opts.OnError = func(path string, err error) {
+		reported = append(reported, path)
+	}
+	out, stats := runIndex(t, opts)
+	if records := parseCSV(t, out); len(records) != 2 {
+		t.Errorf("got %d records, want the two readable files", len(records))
+	}
+	if stats.Errors != 1 || len(reported) != 1 || reported[0] != locked {
+		t.Errorf("got %d errors for %v, want the one for %s", stats.Errors, reported, locked)
+	}
+	rows, err := csv.NewReader(&errorLog).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0][0] != "Path" || rows[1][0] != locked || !strings.Contains(rows[1][1], "permission denied") {
+		t.Errorf("got error log %q", rows)
+	}
+}
+
+// Between them the callbacks hear about every file exactly once, the ones that worked with their size and the ones that didn't with why
+// Broken symlinks fail to open whoever we're running as, so this one works as root too
+func TestCallbacks(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "sub/b.txt": "bb", "sub/c.txt": "ccc"})
+	broken := []string{filepath.Join(dir, "broken1"), filepath.Join(dir, "sub", "broken2")}
+	for _, link := range broken {
+		if err := os.Symlink(filepath.Join(dir, "nowhere"), link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := testOptions(dir)
+	opts.ErrorLog = &bytes.Buffer{}
+	opts.LogOutput = ioutil.Discard
+	var mu sync.Mutex
+	hashed := make(map[string][]int64)
+	failed := make(map[string]int)
+	opts.OnFileHashed = func(path string, size int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		hashed[path] = append(hashed[path], size)
+	}
+	opts.OnError = func(path string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed[path]++
+		if !os.IsNotExist(err) {
+			t.Errorf("%s failed with %v, want it not to exist", path, err)
+		}
+	}
+	_, stats := runIndex(t, opts)
+
+	for name, size := range map[string]int64{"a.txt": 1, "sub/b.txt": 2, "sub/c.txt": 3} {
+		if got := hashed[filepath.Join(dir, filepath.FromSlash(name))]; len(got) != 1 || got[0] != size {
+			t.Errorf("OnFileHashed for %s got %v, want one call with %d", name, got, size)
+		}
+	}
+	for _, link := range broken {
+		if failed[link] != 1 {
+			t.Errorf("OnError was called %d times for %s, want once", failed[link], link)
+		}
+	}
+	if len(hashed) != 3 || len(failed) != 2 || stats.Errors != 2 {
+		t.Errorf("got %v hashed and %v failed with %d errors", hashed, failed, stats.Errors)
+	}
+}
+
+// With ErrorsInline a file we couldn't read gets a row of its own with empty hashes and why, and every other row has an empty Error
+func TestErrorsInline(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "sub/b.txt": "bb"})
+	broken := filepath.Join(dir, "sub", "broken")
+	if err := os.Symlink(filepath.Join(dir, "nowhere"), broken); err != nil {
+		t.Fatal(err)
+	}
+	locked := filepath.Join(dir, "locked.txt")
+	if os.Geteuid() != 0 {
+		writeTree(t, dir, map[string]string{"locked.txt": "locked"})
+		if err := os.Chmod(locked, 0); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(locked, 0644)
+	}
+	opts := testOptions(dir)
+	opts.ErrorsInline = true
+	opts.ErrorLog = &bytes.Buffer{}
+	opts.LogOutput = ioutil.Discard
+
+	out, stats := runIndex(t, opts)
+	header, err := csv.NewReader(strings.NewReader(out)).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header[len(header)-1] != "Error" {
+		t.Errorf("got header %v, want Error on the end", header)
+	}
+	byPath := make(map[string]map[string]string)
+	for _, r := range parseCSV(t, out) {
+		byPath[r["Path"]] = r
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		r := byPath[filepath.Join(dir, filepath.FromSlash(name))]
+		if r == nil || r["Error"] != "" || r["SHA256 Hash"] == "" {
+			t.Errorf("%s got %v, want a hash and no error", name, r)
+		}
+	}
+	failed := []string{broken}
+	if os.Geteuid() != 0 {
+		failed = append(failed, locked)
+	}
+	for _, path := range failed {
+		r := byPath[path]
+		if r == nil || r["SHA256 Hash"] != "" || r["Error"] == "" {
+			t.Errorf("%s got %v, want an empty hash and an error", path, r)
+		}
+	}
+	if r := byPath[broken]; r != nil && !strings.Contains(r["Error"], "no such file") {
+		t.Errorf("the broken link's error is %q, want it saying there's nothing there", r["Error"])
+	}
+	if r := byPath[locked]; r != nil && !strings.Contains(r["Error"], "permission denied") {
+		t.Errorf("the locked file's error is %q, want permission denied", r["Error"])
+	}
+	if want := 2 + len(failed); len(byPath) != want || stats.Errors != int64(len(failed)) {
+		t.Errorf("got %d rows and %d errors, want %d rows and %d errors", len(byPath), stats.Errors, want, len(failed))
+	}
+}
+
+// A directory the walk can't get into is skipped and counted by default, with WalkErrorsFatal the run stops with its error.
+// A symlink to nowhere with FollowSymlinks is a file that can't be read rather than a walk error, it's only counted the once
+// and doesn't stop the run, whichever walk finds it
+func TestWalkErrorsFatal(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+	broken := filepath.Join(dir, "sub", "broken")
+	if err := os.Symlink(filepath.Join(dir, "nowhere"), broken); err != nil {
+		t.Fatal(err)
+	}
+	for _, walkWorkers := range []int{1, 4} {
+		for _, fatal := range []bool{false, true} {
+			opts := testOptions(dir)
+			opts.FollowSymlinks = true
+			opts.WalkWorkers = walkWorkers
+			opts.WalkErrorsFatal = fatal
+			opts.ErrorLog = &bytes.Buffer{}
+			opts.LogOutput = ioutil.Discard
+			out, stats := runIndex(t, opts)
+			if got := parseCSV(t, out); len(got) != 2 || stats.Errors != 1 {
+				t.Errorf("%d walk workers, fatal %v: got %d records and %d errors, want the two files and the broken link", walkWorkers, fatal, len(got), stats.Errors)
+			}
+		}
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("root can get into a directory whatever its permissions")
+	}
+	locked := filepath.Join(dir, "locked")
+	writeTree(t, dir, map[string]string{"locked/c.txt": "c"})
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0755)
+	os.Remove(broken)
+	for _, walkWorkers := range []int{1, 4} {
+		opts := testOptions(dir)
+		opts.WalkWorkers = walkWorkers
+		opts.ErrorLog = &bytes.Buffer{}
+		opts.LogOutput = ioutil.Discard
+		var reported []string
+		var mu sync.Mutex
+		opts.OnError = func(path string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, path)
+		}
+		out, stats := runIndex(t, opts)
+		if got := parseCSV(t, out); len(got) != 2 || stats.Errors != 1 || len(reported) != 1 || reported[0] != locked {
+			t.Errorf("%d walk workers: got %d records and %d errors for %v, want the two files and %s skipped", walkWorkers, len(got), stats.Errors, reported, locked)
+		}
+
+		opts.WalkErrorsFatal = true
+		reported = nil
+		_, err := Index(context.Background(), opts, &bytes.Buffer{})
+		var walkErr *WalkError
+		if !errors.As(err, &walkErr) || !os.IsPermission(walkErr.Err) {
+			t.Errorf("%d walk workers: got %v, want the walk stopped at %s with a WalkError", walkWorkers, err, locked)
+		}
+		if len(reported) != 1 || reported[0] != locked {
+			t.Errorf("%d walk workers: got errors for %v, want only %s", walkWorkers, reported, locked)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package index
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Decides which files get hashed based on the Include and Exclude globs and regexes
+// The globs are matched against the base name of the file so *.go matches /code/goindex/main.go,
+// the regexes are matched against the whole path so you can pick out files by the directories they're in
+// It also knows which directories ExcludeDirs wants us to stay out of
+type fileFilter struct {
+	include      []string
+	exclude      []string
+	includeRegex []*regexp.Regexp
+	excludeRegex []*regexp.Regexp
+	excludeDirs  []string
+	// Options.IgnoreCase, the globs are already lower case and the name gets lowered to match
+	ignoreCase bool
+}
+
+// Builds a fileFilter from the options and checks every pattern up front
+// filepath.Match only complains about a bad pattern when you use it, so we try each one against an empty string
+func newFileFilter(opts Options) (*fileFilter, error) {
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %s", pattern, err)
+		}
+	}
+	includeRegex, err := compileRegexes(opts.IncludeRegex)
+	if err != nil {
+		return nil, err
+	}
+	excludeRegex, err := compileRegexes(opts.ExcludeRegex)
+	if err != nil {
+		return nil, err
+	}
+	// Cleaning the paths means /proc/ and /proc both work
+	excludeDirs := opts.ExcludeDirs
+	for i, dir := range excludeDirs {
+		excludeDirs[i] = filepath.Clean(dir)
+	}
+	include, exclude := opts.Include, opts.Exclude
+	if opts.IgnoreCase {
+		include, exclude = lowerAll(include), lowerAll(exclude)
+	}
+	return &fileFilter{
+		include:      include,
+		exclude:      exclude,
+		includeRegex: includeRegex,
+		excludeRegex: excludeRegex,
+		excludeDirs:  excludeDirs,
+		ignoreCase:   opts.IgnoreCase,
+	}, nil
+}
+
+// A lower case copy, the options are the caller's so they're left alone
+func lowerAll(patterns []string) []string {
+	lowered := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		lowered[i] = strings.ToLower(pattern)
+	}
+	return lowered
+}
+
+func compileRegexes(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad regex %q: %s", pattern, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// Returns true if the file should be hashed
+// Exclude always wins, so a file that matches any exclude glob or regex gets skipped even if it matches an include too.
+// If there are includes of both kinds a file only has to match one of them
+func (ff *fileFilter) match(osPathname string) bool {
+	name := filepath.Base(osPathname)
+	if ff.ignoreCase {
+		name = strings.ToLower(name)
+	}
+	if matchAny(ff.exclude, name) || matchAnyRegex(ff.excludeRegex, osPathname) {
+		return false
+	}
+	// No include patterns means include everything
+	if len(ff.include) == 0 && len(ff.includeRegex) == 0 {
+		return true
+	}
+	return matchAny(ff.include, name) || matchAnyRegex(ff.includeRegex, osPathname)
+}
+
+// On Windows the path is tried with forward slashes as well, so a pattern like /vendor/ works there without escaping backslashes
+func matchAnyRegex(res []*regexp.Regexp, osPathname string) bool {
+	slashed := filepath.ToSlash(osPathname)
+	for _, re := range res {
+		if re.MatchString(osPathname) || (slashed != osPathname && re.MatchString(slashed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the whole directory should be skipped
+// A bare name like node_modules matches a directory with that name anywhere in the tree,
+// anything with a slash in it like /proc matches that directory and everything under it
+func (ff *fileFilter) skipDir(osPathname string) bool {
+	name := filepath.Base(osPathname)
+	for _, dir := range ff.excludeDirs {
+		if !strings.ContainsRune(dir, filepath.Separator) {
+			if name == dir {
+				return true
+			}
+			continue
+		}
+		if osPathname == dir || strings.HasPrefix(osPathname, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		// We already checked the patterns in newFileFilter so the error can't happen here
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// How deep a path is below the walk root, files directly inside the root are at depth 0
+// The root itself comes out as -1 since it's above everything we find
+func pathDepth(root, osPathname string) int {
+	rel, err := filepath.Rel(root, osPathname)
+	if err != nil || rel == "." {
+		return -1
+	}
+	return strings.Count(rel, string(filepath.Separator))
+}
@@ -0,0 +1,202 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIncludeExcludeGlobs(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"main.go": "", "main_test.go": "", "a1.txt": "", "a2.txt": "", "ab.txt": "", "b1.txt": "", "sub/notes.md": "", "sub/c3.txt": "",
+	})
+	tests := []struct {
+		include, exclude string
+		want             []string
+	}{
+		{"*.go", "", []string{"main.go", "main_test.go"}},
+		{"", "*.go", []string{"a1.txt", "a2.txt", "ab.txt", "b1.txt", "sub/c3.txt", "sub/notes.md"}},
+		{"a?.txt", "", []string{"a1.txt", "a2.txt", "ab.txt"}},
+		{"[ab][0-9].txt", "", []string{"a1.txt", "a2.txt", "b1.txt"}},
+		{"[^a]*.txt", "", []string{"b1.txt", "sub/c3.txt"}},
+		// Matched against the name, so the directory doesn't get in the way
+		{"*.md", "", []string{"sub/notes.md"}},
+		// Exclude wins when a file matches both
+		{"*.go", "*_test.go", []string{"main.go"}},
+		{"*.go,*.md", "main*", []string{"sub/notes.md"}},
+	}
+	for _, test := range tests {
+		opts := testOptions(dir)
+		opts.Include = splitPatterns(test.include)
+		opts.Exclude = splitPatterns(test.exclude)
+		if got := indexedPaths(t, opts); !equalStrings(got, test.want) {
+			t.Errorf("include %q exclude %q got %v, want %v", test.include, test.exclude, got, test.want)
+		}
+	}
+}
+
+func TestBadGlob(t *testing.T) {
+	opts := testOptions(t.TempDir())
+	opts.Include = []string{"[a-"}
+	if _, err := newFileFilter(opts); err == nil || !strings.Contains(err.Error(), "bad pattern") {
+		t.Errorf("got %v, want the pattern turned down", err)
+	}
+}
+
+// *.JPG only matches photo.jpg with IgnoreCase, and it's the same for excludes. The caller's patterns aren't touched
+func TestIgnoreCase(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"photo.jpg": "", "PHOTO2.JPG": "", "Mixed.Jpg": "", "notes.txt": "", "scratch.TMP": ""})
+	tests := []struct {
+		include, exclude string
+		ignoreCase       bool
+		want             []string
+	}{
+		{"*.JPG", "", false, []string{"PHOTO2.JPG"}},
+		{"*.JPG", "", true, []string{"Mixed.Jpg", "PHOTO2.JPG", "photo.jpg"}},
+		{"*.jpg", "", true, []string{"Mixed.Jpg", "PHOTO2.JPG", "photo.jpg"}},
+		{"", "*.tmp,*.jpg", false, []string{"Mixed.Jpg", "PHOTO2.JPG", "notes.txt", "scratch.TMP"}},
+		{"", "*.tmp,*.jpg", true, []string{"notes.txt"}},
+		{"PHOTO*", "", true, []string{"PHOTO2.JPG", "photo.jpg"}},
+	}
+	for _, test := range tests {
+		opts := testOptions(dir)
+		opts.Include = splitPatterns(test.include)
+		opts.Exclude = splitPatterns(test.exclude)
+		opts.IgnoreCase = test.ignoreCase
+		if got := indexedPaths(t, opts); !equalStrings(got, test.want) {
+			t.Errorf("include %q exclude %q ignore case %v got %v, want %v", test.include, test.exclude, test.ignoreCase, got, test.want)
+		}
+		if strings.Join(opts.Include, ",") != test.include {
+			t.Errorf("the include patterns were changed to %v", opts.Include)
+		}
+	}
+}
+
+func splitPatterns(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// A bare name is skipped wherever it turns up, a path only where it is, and nothing under either gets in
+func TestExcludeDirs(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"main.go": "", "node_modules/x/index.js": "", "web/node_modules/y.js": "", "web/app.js": "",
+		"build/out.bin": "", "src/build/keep.go": "", "node_modules_notes.txt": "",
+	})
+	opts := testOptions(dir)
+	opts.ExcludeDirs = []string{"node_modules", filepath.Join(dir, "build") + string(filepath.Separator)}
+	want := []string{"main.go", "node_modules_notes.txt", "src/build/keep.go", "web/app.js"}
+	if got := indexedPaths(t, opts); !equalStrings(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// A file exactly at either limit is in, one byte past it is out
+func TestSizeLimits(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"99.bin": strings.Repeat("x", 99), "100.bin": strings.Repeat("x", 100), "200.bin": strings.Repeat("x", 200), "201.bin": strings.Repeat("x", 201)})
+	tests := []struct {
+		min, max int64
+		want     []string
+	}{
+		{100, -1, []string{"100.bin", "200.bin", "201.bin"}},
+		{0, 200, []string{"100.bin", "200.bin", "99.bin"}},
+		{100, 200, []string{"100.bin", "200.bin"}},
+		{200, 200, []string{"200.bin"}},
+		{101, 199, nil},
+	}
+	for _, test := range tests {
+		opts := testOptions(dir)
+		opts.MinSize, opts.MaxSize = test.min, test.max
+		if got := indexedPaths(t, opts); !equalStrings(got, test.want) {
+			t.Errorf("min %d max %d got %v, want %v", test.min, test.max, got, test.want)
+		}
+	}
+}
+
+// A second either side of the cutoff, and a directory that's older than it doesn't keep the new file inside it out
+func TestModifiedSince(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"inside.txt": "", "outside.txt": "", "old/inside.txt": ""})
+	cutoff := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for name, mtime := range map[string]time.Time{
+		"inside.txt": cutoff.Add(time.Second), "outside.txt": cutoff.Add(-time.Second), "old/inside.txt": cutoff.Add(time.Second), "old": cutoff.Add(-24 * time.Hour),
+	} {
+		if err := os.Chtimes(filepath.Join(dir, name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := testOptions(dir)
+	opts.ModifiedSince = cutoff
+	if got, want := indexedPaths(t, opts), []string{"inside.txt", "old/inside.txt"}; !equalStrings(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// The regexes get the whole path, so they can pick files out by directory as well as by name
+func TestIncludeExcludeRegex(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"src/main.go": "", "src/vendor/lib.go": "", "docs/main.md": "", "main.go.orig": ""})
+	tests := []struct {
+		include, exclude []string
+		want             []string
+	}{
+		// Unanchored matches anywhere in the path, anchored has to match the end
+		{[]string{`main`}, nil, []string{"docs/main.md", "main.go.orig", "src/main.go"}},
+		{[]string{`\.go$`}, nil, []string{"src/main.go", "src/vendor/lib.go"}},
+		{[]string{"^" + regexp.QuoteMeta(filepath.Join(dir, "src"))}, nil, []string{"src/main.go", "src/vendor/lib.go"}},
+		{nil, []string{`/vendor/`}, []string{"docs/main.md", "main.go.orig", "src/main.go"}},
+		// Exclude still wins
+		{[]string{`\.go$`}, []string{`/vendor/`}, []string{"src/main.go"}},
+	}
+	for _, test := range tests {
+		opts := testOptions(dir)
+		opts.IncludeRegex, opts.ExcludeRegex = test.include, test.exclude
+		if got := indexedPaths(t, opts); !equalStrings(got, test.want) {
+			t.Errorf("include %q exclude %q got %v, want %v", test.include, test.exclude, got, test.want)
+		}
+	}
+
+	// A glob include and a regex include each let a file in on their own
+	opts := testOptions(dir)
+	opts.Include, opts.IncludeRegex = []string{"*.md"}, []string{`/vendor/`}
+	if got, want := indexedPaths(t, opts), []string{"docs/main.md", "src/vendor/lib.go"}; !equalStrings(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// A Windows path has backslashes, a pattern written for them works and on Windows one written with slashes does too
+func TestRegexWindowsPaths(t *testing.T) {
+	opts := testOptions("")
+	opts.ExcludeRegex = []string{`(?i)^C:\\Code\\vendor\\`}
+	ff, err := newFileFilter(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ff.match(`C:\code\vendor\lib.go`) || !ff.match(`C:\code\src\main.go`) {
+		t.Errorf("the backslash pattern didn't pick out the vendor directory")
+	}
+	if runtime.GOOS == "windows" {
+		opts.ExcludeRegex = []string{`/vendor/`}
+		if ff, _ = newFileFilter(opts); ff.match(`C:\code\vendor\lib.go`) {
+			t.Errorf("a slash pattern didn't match a backslash path")
+		}
+	}
+}
+
+func TestBadRegex(t *testing.T) {
+	opts := testOptions(t.TempDir())
+	opts.IncludeRegex = []string{`good`, `(unclosed`}
+	if _, err := newFileFilter(opts); err == nil || !strings.Contains(err.Error(), "(unclosed") {
+		t.Errorf("got %v, want it to point at the bad regex", err)
+	}
+}
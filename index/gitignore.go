@@ -0,0 +1,188 @@
+package index
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// One line out of a .gitignore
+type ignorePattern struct {
+	re *regexp.Regexp
+	// The line started with a !, so a match un-ignores the path
+	negate bool
+	// The line ended with a /, so it only ever matches directories
+	dirOnly bool
+}
+
+// Every .gitignore we've found so far, keyed by the directory it was in
+// The parallel walk loads and checks these from more than one goroutine, so the map has a lock
+// Only the .gitignore files inside WalkDir are used, if you walk part of a repository the ones above it aren't looked at
+type gitIgnores struct {
+	mu    sync.RWMutex
+	files map[string][]ignorePattern
+}
+
+func newGitIgnores() *gitIgnores {
+	return &gitIgnores{files: make(map[string][]ignorePattern)}
+}
+
+// Reads dir/.gitignore if there is one, call this for every directory before walking into it
+// Both walks always give us a directory before anything inside it, so the patterns are there by the time they're needed
+func (g *gitIgnores) load(dir string) error {
+	f, err := os.Open(longPath(filepath.Join(dir, ".gitignore")))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if p, ok := parseIgnoreLine(scanner.Text()); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(patterns) > 0 {
+		g.mu.Lock()
+		g.files[dir] = patterns
+		g.mu.Unlock()
+	}
+	return nil
+}
+
+// Works out whether git would ignore osPathname
+// Same as git, the last pattern that matches wins, and a .gitignore further down the tree comes after the ones above it
+// so it gets the final say. We never get asked about anything inside an ignored directory since the walk skips those,
+// which is also how git does it, you can't un-ignore a file if its directory is ignored
+func (g *gitIgnores) ignored(osPathname string, isDir bool) bool {
+	// git never tracks its own directory
+	if isDir && filepath.Base(osPathname) == ".git" {
+		return true
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if len(g.files) == 0 {
+		return false
+	}
+
+	// Every directory above the path, from the top down
+	var dirs []string
+	for dir := filepath.Dir(osPathname); ; dir = filepath.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+
+	ignored := false
+	for _, dir := range dirs {
+		patterns, ok := g.files[dir]
+		if !ok {
+			continue
+		}
+		// Patterns are always written with forward slashes and are relative to the directory the .gitignore is in
+		rel, err := filepath.Rel(dir, osPathname)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, p := range patterns {
+			if p.dirOnly && !isDir {
+				continue
+			}
+			if p.re.MatchString(rel) {
+				ignored = !p.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// Turns a line from a .gitignore into a pattern, ok is false for blank lines and comments
+func parseIgnoreLine(line string) (ignorePattern, bool) {
+	var p ignorePattern
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return p, false
+	}
+	// A backslash lets you have a file that actually starts with # or !
+	switch {
+	case strings.HasPrefix(line, "!"):
+		p.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\#`), strings.HasPrefix(line, `\!`):
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return p, false
+	}
+
+	// A slash anywhere but the end ties the pattern to the directory the .gitignore is in,
+	// without one it matches a name at any depth below it
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := globToRegexp(line)
+	if !anchored {
+		expr = "(.*/)?" + expr
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return p, false
+	}
+	p.re = re
+	return p, true
+}
+
+// Translates a gitignore glob into a regular expression
+// * and ? never match a slash, ** matches any number of directories, [a-z] works like it does in a shell
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
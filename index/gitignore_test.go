@@ -0,0 +1,35 @@
+package index
+
+import "testing"
+
+func TestGitIgnore(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".gitignore":       "# build output\n*.log\n!keep.log\nbuild/\n/rootonly.txt\ndocs/*.tmp\n",
+		"main.go":          "",
+		"app.log":          "",
+		"keep.log":         "",
+		"rootonly.txt":     "",
+		"build/out.bin":    "",
+		"docs/a.tmp":       "",
+		"docs/a.md":        "",
+		"sub/.gitignore":   "!debug.log\nlocal.txt\n",
+		"sub/debug.log":    "",
+		"sub/other.log":    "",
+		"sub/local.txt":    "",
+		"sub/rootonly.txt": "",
+		"sub/build":        "a file called build, not a directory",
+		"sub/deep/x.log":   "",
+		"sub/deep/y.go":    "",
+	})
+	opts := testOptions(dir)
+	opts.GitIgnore = true
+	want := []string{
+		".gitignore", "docs/a.md", "keep.log", "main.go",
+		// The nested file un-ignores debug.log for everything under sub, but only debug.log
+		"sub/.gitignore", "sub/build", "sub/debug.log", "sub/deep/y.go", "sub/rootonly.txt",
+	}
+	if got := indexedPaths(t, opts); !equalStrings(got, want) {
+		t.Errorf("got  %v\nwant %v", got, want)
+	}
+}
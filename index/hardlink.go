@@ -0,0 +1,67 @@
+package index
+
+import "sync"
+
+// Which file on disk a path is, two hard links to the same file have the same one
+type inodeKey struct {
+	dev, ino uint64
+}
+
+// The first path we come across for a hard linked file with Options.HardlinkAware, and its hashes once it's done
+type linkEntry struct {
+	// Closed once the hashes are in, or once we know there won't be any
+	done chan struct{}
+	ok   bool
+	rec  record
+}
+
+// Every hard linked file we've started on with Options.HardlinkAware
+// The first reader to get to a file hashes it like normal, any other path to it waits for that and copies the hashes
+type linkCache struct {
+	mu      sync.Mutex
+	entries map[inodeKey]*linkEntry
+	// The path that's hashing each entry, the hash stage only tells us the path when it's done
+	owners map[string]inodeKey
+}
+
+func newLinkCache() *linkCache {
+	return &linkCache{entries: make(map[inodeKey]*linkEntry), owners: make(map[string]inodeKey)}
+}
+
+// Either makes osPathname the one that hashes the file, in which case first is true and it has to call finish or abandon,
+// or hands back the entry for whoever got there first to wait on
+func (c *linkCache) claim(key inodeKey, osPathname string) (entry *linkEntry, first bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		return entry, false
+	}
+	entry = &linkEntry{done: make(chan struct{})}
+	c.entries[key] = entry
+	c.owners[osPathname] = key
+	return entry, true
+}
+
+// Called for every file the hash stage is done with, it only does anything if osPathname claimed an entry
+// If it failed the other paths hash the file themselves, there's a good chance it was something about this path rather than the file
+func (c *linkCache) finish(osPathname string, rec record, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, owner := c.owners[osPathname]
+	if !owner {
+		return
+	}
+	delete(c.owners, osPathname)
+	entry := c.entries[key]
+	entry.rec, entry.ok = rec, ok
+	if !ok {
+		// Leaves it free for the next path to claim instead of everyone piling on one that failed
+		delete(c.entries, key)
+	}
+	close(entry.done)
+}
+
+// For when osPathname is going to have another go at the file, anybody waiting can't wait on it any more
+func (c *linkCache) abandon(osPathname string) {
+	c.finish(osPathname, record{}, false)
+}
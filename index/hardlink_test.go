@@ -0,0 +1,58 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Three paths to the one file are read once between them, and every one of them still gets a row with the hash
+func TestHardlinkAware(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"original.txt": "shared", "other.txt": "other"})
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"link1.txt", "sub/link2.txt"} {
+		if err := os.Link(filepath.Join(dir, "original.txt"), filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Skipf("can't make a hard link: %s", err)
+		}
+	}
+
+	for _, aware := range []bool{false, true} {
+		opts := testOptions(dir)
+		opts.HardlinkAware = aware
+		// The hash stage logs every file it reads, reusing a hash doesn't go through it
+		opts.LogLevel = LogVerbose
+		var log bytes.Buffer
+		opts.LogOutput = &log
+		byPath := indexByPath(t, opts)
+
+		for _, name := range []string{"original.txt", "link1.txt", "sub/link2.txt"} {
+			if rec := byPath[filepath.Join(dir, filepath.FromSlash(name))]; rec["SHA256 Hash"] != sha256Hex("shared") || rec["Size"] != "6" {
+				t.Errorf("aware %v: %s got %v", aware, name, rec)
+			}
+		}
+		if len(byPath) != 4 {
+			t.Errorf("aware %v: got %d rows, want 4", aware, len(byPath))
+		}
+		hashed, reused := 0, 0
+		for _, line := range strings.Split(log.String(), "\n") {
+			switch {
+			case strings.HasPrefix(line, "Hashed "+filepath.Join(dir, "other.txt")):
+			case strings.HasPrefix(line, "Hashed "):
+				hashed++
+			case strings.HasPrefix(line, "Reused the hash") && strings.Contains(line, "hard link"):
+				reused++
+			}
+		}
+		if want := map[bool][2]int{false: {3, 0}, true: {1, 2}}[aware]; hashed != want[0] || reused != want[1] {
+			t.Errorf("aware %v: the linked file was hashed %d times and reused %d, want %d and %d:\n%s", aware, hashed, reused, want[0], want[1], log.String())
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package index
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+)
+
+// Every hash algorithm Options.Hashes can have in it
+// xxhash and crc32 aren't cryptographic, they're only good for telling whether a file changed but they're a lot faster
+// blake3 is cryptographic too and the quickest of those with its SIMD code, the sums match what b3sum and other BLAKE3 tools give you
+var KnownHashes = []string{"md5", "sha1", "sha256", "sha512", "blake3", "xxhash", "crc32"}
+
+// Returns a fresh hasher for the given algorithm name
+// Hashers hold state so every file needs its own, you can't share one between workers
+func newHasher(name string) (hash.Hash, error) {
+	switch strings.ToLower(name) {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	// The same hex as b3sum prints. It's single threaded per file, the SIMD code hashes several 1 KiB chunks at once
+	// but all on the worker reading the file. BLAKE3 could spread one file over several cores, but the worker pool already
+	// has every core busy with a file each so that would only have the workers fighting over the same cores
+	case "blake3":
+		return blake3.New(), nil
+	// Both of these are a hash.Hash too, Sum gives you the checksum as big endian bytes so the hex comes out
+	// the same as what xxhsum or crc32 print, just with the leading zeros kept
+	case "xxhash":
+		return xxhash.New(), nil
+	case "crc32":
+		return crc32.NewIEEE(), nil
+	}
+	return nil, fmt.Errorf("unknown hash algorithm %q, expected one of %s", name, strings.Join(KnownHashes, ", "))
+}
+
+// Splits a comma separated list of algorithms like "md5,sha256" and checks every one of them
+func ParseHashNames(list string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		names = append(names, strings.ToLower(strings.TrimSpace(name)))
+	}
+	return names, checkHashNames(names)
+}
+
+// Makes sure every algorithm is one we know about, so we fail before the walk instead of inside a worker
+func checkHashNames(names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("no hash algorithm given, expected one or more of %s", strings.Join(KnownHashes, ", "))
+	}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if _, err := newHasher(name); err != nil {
+			return err
+		}
+		// Asking for the same hash twice would just give you two identical columns
+		if seen[name] {
+			return fmt.Errorf("hash algorithm %q was listed more than once", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// Every way Options.HashEncoding can write a hash
+// hex is what every other tool prints, upper is the same in upper case and base64 takes up a third less room
+var KnownHashEncodings = []string{"hex", "upper", "base64"}
+
+// Empty is fine, it means hex
+func checkHashEncoding(encoding string) error {
+	if encoding == "" {
+		return nil
+	}
+	for _, known := range KnownHashEncodings {
+		if strings.EqualFold(encoding, known) {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown hash encoding %q, expected one of %s", encoding, strings.Join(KnownHashEncodings, ", "))
+}
+
+// Turns the bytes out of a hasher into the string that goes in the record
+func encodeSum(sum []byte, encoding string) string {
+	switch strings.ToLower(encoding) {
+	case "upper":
+		return strings.ToUpper(hex.EncodeToString(sum))
+	case "base64":
+		return base64.StdEncoding.EncodeToString(sum)
+	}
+	return hex.EncodeToString(sum)
+}
+
+// Gets the bytes back out of a hash written with any of the encodings, so indexes written with different ones can still be compared
+// We know how long the hash for each algorithm is, the hex for that is never the same length as the base64 apart from crc32
+// and base64 always ends that one with padding, so there's no guessing involved
+func decodeSum(name, s string) ([]byte, bool) {
+	h, err := newHasher(name)
+	if err != nil {
+		return nil, false
+	}
+	if len(s) == hex.EncodedLen(h.Size()) {
+		if sum, err := hex.DecodeString(s); err == nil {
+			return sum, true
+		}
+	}
+	if sum, err := base64.StdEncoding.DecodeString(s); err == nil && len(sum) == h.Size() {
+		return sum, true
+	}
+	return nil, false
+}
+
+// Writes a hash from some index out again in the encoding we want, anything we can't make sense of comes back as it was
+func reencodeSum(name, s, encoding string) string {
+	if sum, ok := decodeSum(name, s); ok {
+		return encodeSum(sum, encoding)
+	}
+	return s
+}
+
+// Returns true if two hashes from the same algorithm are the same digest, whichever encodings they were written in
+func sameSum(name, a, b string) bool {
+	if a == b {
+		return true
+	}
+	sumA, okA := decodeSum(name, a)
+	sumB, okB := decodeSum(name, b)
+	return okA && okB && string(sumA) == string(sumB)
+}
+
+// Feeds everything written to it into one hasher per algorithm
+// The read and hash stages hand files over in chunks, so the hashing needs to be something you can keep writing to
+type multiHasher struct {
+	hashers  []hash.Hash
+	w        io.Writer
+	encoding string
+}
+
+// The names were checked by checkHashNames before we get here so newHasher can't fail
+// I literally googled `go sha256 hash file` and clicked the first stackoverflow link
+func newMultiHasher(hashNames []string, encoding string) *multiHasher {
+	hashers := make([]hash.Hash, len(hashNames))
+	writers := make([]io.Writer, len(hashNames))
+	for i, name := range hashNames {
+		hashers[i], _ = newHasher(name)
+		writers[i] = hashers[i]
+	}
+
+	// A MultiWriter copies everything written to it into all of the hashers
+	// That way we only have to read the file once no matter how many hashes we want
+	return &multiHasher{hashers: hashers, w: io.MultiWriter(writers...), encoding: encoding}
+}
+
+func (m *multiHasher) Write(p []byte) (int, error) {
+	return m.w.Write(p)
+}
+
+// Returns the encoded hash for each algorithm, in the same order as the names it was made with
+func (m *multiHasher) sums() []string {
+	hashes := make([]string, len(m.hashers))
+	for i, hasher := range m.hashers {
+		hashes[i] = encodeSum(hasher.Sum(nil), m.encoding)
+	}
+	return hashes
+}
@@ -0,0 +1,163 @@
+package index
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The first 32 bytes of the hash for each length in the official BLAKE3 test vectors, the input is i % 251 for every byte i
+// The lengths sit either side of the 1 KiB chunks and the points where the tree of chaining values gets another level
+var blake3Vectors = []struct {
+	length int
+	hash   string
+}{
+	{0, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+	{1, "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213"},
+	{1023, "10108970eeda3eb932baac1428c7a2163b0e924c9a9e25b35bba72b28f70bd11"},
+	{1024, "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+	{1025, "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+	{2048, "e776b6028c7cd22a4d0ba182a8bf62205d2ef576467e838ed6f2529b85fba24a"},
+	{2049, "5f4d72f40d7a5f82b15ca2b2e44b1de3c2ef86c426c95c1af0b6879522563030"},
+	{3072, "b98cb0ff3623be03326b373de6b9095218513e64f1ee2edd2525c7ad1e5cffd2"},
+	{3073, "7124b49501012f81cc7f11ca069ec9226cecb8a2c850cfe644e327d22d3e1cd3"},
+	{4096, "015094013f57a5277b59d8475c0501042c0b642e531b0a1c8f58d2163229e969"},
+	{4097, "9b4052b38f1c5fc8b1f9ff7ac7b27cd242487b3d890d15c96a1c25b8aa0fb995"},
+	{5120, "9cadc15fed8b5d854562b26a9536d9707cadeda9b143978f319ab34230535833"},
+	{5121, "628bd2cb2004694adaab7bbd778a25df25c47b9d4155a55f8fbd79f2fe154cff"},
+	{6144, "3e2e5b74e048f3add6d21faab3f83aa44d3b2278afb83b80b3c35164ebeca205"},
+	{6145, "f1323a8631446cc50536a9f705ee5cb619424d46887f3c376c695b70e0f0507f"},
+	{7168, "61da957ec2499a95d6b8023e2b0e604ec7f6b50e80a9678b89d2628e99ada77a"},
+	{7169, "a003fc7a51754a9b3c7fae0367ab3d782dccf28855a03d435f8cfe74605e7817"},
+	{8192, "aae792484c8efe4f19e2ca7d371d8c467ffb10748d8a5a1ae579948f718a2a63"},
+	{8193, "bab6c09cb8ce8cf459261398d2e7aef35700bf488116ceb94a36d0f5f1b7bc3b"},
+	{16384, "f875d6646de28985646f34ee13be9a576fd515f76b5b0a26bb324735041ddde4"},
+	{31744, "62b6960e1a44bcc1eb1a611a8d6235b6b4b78f32e7abc4fb4c6cdcce94895c47"},
+}
+
+func TestBlake3Vectors(t *testing.T) {
+	for _, vector := range blake3Vectors {
+		input := make([]byte, vector.length)
+		for i := range input {
+			input[i] = byte(i % 251)
+		}
+		// Written in odd sized pieces the way a file read comes in, so a chunk boundary lands in the middle of a write
+		h, err := newHasher("blake3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rest := input; len(rest) > 0; {
+			n := 1 + len(rest)%700
+			if n > len(rest) {
+				n = len(rest)
+			}
+			h.Write(rest[:n])
+			rest = rest[n:]
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != vector.hash {
+			t.Errorf("%d bytes got %s, want %s", vector.length, got, vector.hash)
+		}
+	}
+}
+
+// The whole run on the same set of files with each algorithm, run with -bench Hash -benchtime 5x or so
+// It's the hashing that differs, so the gap between them is what choosing one over the other saves you
+func BenchmarkHash(b *testing.B) {
+	dir := b.TempDir()
+	files := make(map[string]string)
+	data := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	var total int64
+	for i := 0; i < 8; i++ {
+		files[fmt.Sprintf("file%d.bin", i)] = string(data)
+		total += int64(len(data))
+	}
+	writeTree(b, dir, files)
+	for _, name := range []string{"blake3", "sha256", "xxhash"} {
+		b.Run(name, func(b *testing.B) {
+			opts := testOptions(dir)
+			opts.Hashes = []string{name}
+			b.SetBytes(total)
+			for i := 0; i < b.N; i++ {
+				runIndex(b, opts)
+			}
+		})
+	}
+}
+
+// Whichever way the hash is written it's the same digest underneath, and decodeSum gets it back from any of them
+func TestHashEncodings(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "hello"})
+	want := sha256.Sum256([]byte("hello"))
+	decoders := map[string]func(string) ([]byte, error){
+		"hex":    hex.DecodeString,
+		"upper":  hex.DecodeString,
+		"base64": base64.StdEncoding.DecodeString,
+	}
+	for _, encoding := range KnownHashEncodings {
+		opts := testOptions(dir)
+		opts.HashEncoding = encoding
+		got := indexByPath(t, opts)[filepath.Join(dir, "a.txt")]["SHA256 Hash"]
+		if encoding == "upper" && got != strings.ToUpper(got) {
+			t.Errorf("upper gave %q", got)
+		}
+		sum, err := decoders[encoding](got)
+		if err != nil || !bytes.Equal(sum, want[:]) {
+			t.Errorf("%s gave %q which decodes to %x, %v", encoding, got, sum, err)
+		}
+		if sum, ok := decodeSum("sha256", got); !ok || !bytes.Equal(sum, want[:]) {
+			t.Errorf("decodeSum of the %s %q gave %x", encoding, got, sum)
+		}
+	}
+
+	// crc32 is the one where hex and base64 are the same length
+	h, _ := newHasher("crc32")
+	h.Write([]byte("hello"))
+	crc := h.Sum(nil)
+	for _, encoding := range KnownHashEncodings {
+		if sum, ok := decodeSum("crc32", encodeSum(crc, encoding)); !ok || !bytes.Equal(sum, crc) {
+			t.Errorf("crc32 in %s decoded to %x, want %x", encoding, sum, crc)
+		}
+	}
+}
+
+// Files that start the same only come out the same with HeadBytes if they're the same size too, and the size is always the whole file's
+// Mapped files get cut off at the head the same as read ones
+func TestHeadHash(t *testing.T) {
+	dir := t.TempDir()
+	head := strings.Repeat("h", 1000)
+	writeTree(t, dir, map[string]string{
+		"a":     head + "tail one",
+		"b":     head + "tail two",
+		"long":  head + "a much longer tail",
+		"short": "under the head",
+	})
+	for _, mmap := range []int64{0, 1} {
+		opts := testOptions(dir)
+		opts.HeadBytes = 1000
+		opts.MmapThreshold = mmap
+		byPath := indexByPath(t, opts)
+		row := func(name string) map[string]string { return byPath[filepath.Join(dir, name)] }
+		if a, b := row("a"), row("b"); a["SHA256 Hash"] != sha256Hex(head) || b["SHA256 Hash"] != a["SHA256 Hash"] || a["Size"] != "1008" || b["Size"] != "1008" {
+			t.Errorf("mmap %d: a got %v and b got %v, want the hash of the head and the whole size", mmap, a, b)
+		}
+		if long := row("long"); long["SHA256 Hash"] != sha256Hex(head) || long["Size"] != "1018" {
+			t.Errorf("mmap %d: long got %v, want the same hash as a with its own size", mmap, long)
+		}
+		if short := row("short"); short["SHA256 Hash"] != sha256Hex("under the head") {
+			t.Errorf("mmap %d: short got %v, want the hash of the whole file", mmap, short)
+		}
+	}
+
+	byPath := indexByPath(t, testOptions(dir))
+	if byPath[filepath.Join(dir, "a")]["SHA256 Hash"] == byPath[filepath.Join(dir, "b")]["SHA256 Hash"] {
+		t.Errorf("without HeadBytes a and b got the same hash")
+	}
+}
@@ -0,0 +1,140 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// Options for a run over dir with two workers and nothing drawn, every test starts from these
+func testOptions(dir string) Options {
+	opts := DefaultOptions()
+	opts.WalkDir = dir
+	opts.Workers = 2
+	return opts
+}
+
+// Writes every file in files under dir, the keys are slash separated paths relative to dir and the directories get made as needed
+func writeTree(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Runs Index with opts and hands back everything it wrote, failing the test if it returns an error
+func runIndex(t testing.TB, opts Options) (string, Stats) {
+	t.Helper()
+	var out bytes.Buffer
+	stats, err := Index(context.Background(), opts, &out)
+	if err != nil {
+		t.Fatalf("Index: %s", err)
+	}
+	return out.String(), stats
+}
+
+// Parses a CSV index into one map per row keyed by the header, in the order they were written
+func parseCSV(t *testing.T, data string) []map[string]string {
+	t.Helper()
+	rows, err := csv.NewReader(bytes.NewBufferString(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("parsing the output: %s\n%s", err, data)
+	}
+	if len(rows) == 0 {
+		t.Fatalf("no header in the output")
+	}
+	var records []map[string]string
+	for _, row := range rows[1:] {
+		rec := make(map[string]string)
+		for i, column := range rows[0] {
+			rec[column] = row[i]
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// Runs Index into a CSV and returns its rows keyed by path
+func indexByPath(t *testing.T, opts Options) map[string]map[string]string {
+	t.Helper()
+	out, _ := runIndex(t, opts)
+	byPath := make(map[string]map[string]string)
+	for _, rec := range parseCSV(t, out) {
+		byPath[rec["Path"]] = rec
+	}
+	return byPath
+}
+
+// The paths in a CSV index relative to dir, sorted, so a test can compare them against a list
+func indexedPaths(t *testing.T, opts Options) []string {
+	t.Helper()
+	var paths []string
+	for path := range indexByPath(t, opts) {
+		rel, err := filepath.Rel(opts.WalkDir, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Indexes dir into a file and loads it back, ready to verify against
+func saveIndex(t *testing.T, dir string) *IndexFile {
+	t.Helper()
+	out, _ := runIndex(t, testOptions(dir))
+	name := filepath.Join(t.TempDir(), "index.csv")
+	if err := ioutil.WriteFile(name, []byte(out), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := LoadIndex(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+// Writes contents out as an index and loads it back
+func loadIndexText(t *testing.T, contents string) *IndexFile {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "index.csv")
+	if err := ioutil.WriteFile(name, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := LoadIndex(name)
+	if err != nil {
+		t.Fatalf("loading %q: %s", contents, err)
+	}
+	return idx
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import (
+	"strings"
+
+	"github.com/karrick/godirwalk"
+)
+
+// On Unix a file is hidden if its name starts with a dot, that's all there is to it
+func isHidden(osPathname string, de *godirwalk.Dirent) bool {
+	return strings.HasPrefix(de.Name(), ".")
+}
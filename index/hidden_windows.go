@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package index
+
+import (
+	"strings"
+	"syscall"
+
+	"github.com/karrick/godirwalk"
+)
+
+// Windows has an actual hidden attribute, but plenty of tools (git for one) create dotted folders
+// without setting it, so we treat both as hidden
+func isHidden(osPathname string, de *godirwalk.Dirent) bool {
+	if strings.HasPrefix(de.Name(), ".") {
+		return true
+	}
+	p, err := syscall.UTF16PtrFromString(longPath(osPathname))
+	if err != nil {
+		return false
+	}
+	attrs, err := syscall.GetFileAttributes(p)
+	if err != nil {
+		return false
+	}
+	return attrs&syscall.FILE_ATTRIBUTE_HIDDEN != 0
+}
@@ -0,0 +1,1398 @@
+// Package index walks a directory tree and hashes every file it finds, writing one record per file.
+// This is everything goindex does without the command line part, so you can use it from your own programs
+package index
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/karrick/godirwalk"
+)
+
+// Everything you can change about how Index works
+// Start from DefaultOptions, the zero value isn't usable since WalkDir and Hashes have to be set
+type Options struct {
+	// The directory to walk, can also be a single file
+	WalkDir string
+	// More directories to walk after WalkDir, one after the other into the same output
+	// A root that can't be walked goes in the error log and the others still get done.
+	// With Relative set paths from two roots can be the same, so every record gets a Root column with the root it's relative to
+	Roots []string
+	// Hash exactly the files listed in here instead of walking anything, like the output of find -print0
+	// None of the filters apply, but relative paths are still relative to WalkDir. Paths that don't exist go in the error log
+	PathList io.Reader
+	// The paths in PathList end in a NUL byte instead of a newline, for find -print0 or anything else that can have newlines in a path
+	PathListNul bool
+	// The hash algorithms to use, see KnownHashes. Every record gets one hash per algorithm in this order
+	Hashes []string
+	// How the hashes get written, one of KnownHashEncodings. Empty means lower case hex
+	// Base and Verify indexes can be in any of them, their hashes get converted before they're compared or copied
+	HashEncoding string
+	// How many files to hash at the same time, this is what ReadWorkers and HashWorkers default to
+	Workers int
+	// How many files to have open and be reading at the same time, 0 means use Workers
+	// Keep this low on spinning disks where reading two files at once is slower than one after the other
+	ReadWorkers int
+	// How many files to be hashing at the same time, 0 means use Workers
+	// This is the one to raise when the disk is fast and the CPU is what's holding things up
+	HashWorkers int
+	// Let the number of readers change as the run goes instead of staying at ReadWorkers, it starts at ReadWorkersMin and goes
+	// anywhere up to this depending on how long files are taking to open, see readerPool. 0 keeps it fixed
+	// The readers hand every file to a hasher, so unless HashWorkers is set it's raised to this as well
+	ReadWorkersMax int
+	// The fewest readers the pool shrinks to with ReadWorkersMax, 0 means 1
+	ReadWorkersMin int
+	// How long opening a file should take with ReadWorkersMax, readers are added while it's quicker than this
+	// and half of them are taken away when it's more than twice as slow. 0 means 5ms
+	ReadLatencyTarget time.Duration
+	// The output format, see KnownFormats
+	Format string
+	// The table sqlite creates and the sql format inserts into, empty means files
+	// Letters, digits and underscores only, the sql format can have a schema in front like public.files
+	Table string
+	// How the modified time gets written, one of KnownTimeFormats or a Go layout like 2006-01-02 15:04
+	// Empty means whatever the output format has always used, go for csv, a JSON time for ndjson and json and rfc3339 for sqlite
+	// Only the named formats can be read back in by LoadIndex
+	TimeFormat string
+	// How many directories to read at the same time while walking, 0 or 1 walks one directory after another
+	// Worth raising on trees with millions of directories or on network drives, where the walk can't keep the hashers busy on its own
+	WalkWorkers int
+	// How many bytes of a file get read at a time, the buffers are reused between files so this is all we allocate for reading
+	BufferSize int
+	// How many finished records can be waiting to be written before the workers have to wait for the writer
+	WriteQueue int
+
+	// Glob patterns matched against the file name, only matching files get hashed. Empty means everything
+	Include []string
+	// Glob patterns matched against the file name, matching files are skipped. Beats Include
+	Exclude []string
+	// Match Include and Exclude without caring about case, so *.JPG gets photo.jpg too like it would on Windows or a Mac
+	// The regexes have (?i) for that
+	IgnoreCase bool
+	// Regular expressions matched against the whole path, only matching files get hashed
+	// A file that matches either Include or IncludeRegex gets in
+	IncludeRegex []string
+	// Regular expressions matched against the whole path, matching files are skipped
+	// Same as Exclude this beats both kinds of include
+	ExcludeRegex []string
+	// Directories to skip entirely, either a bare name like node_modules or a path like /proc
+	ExcludeDirs []string
+	// Skip hidden files and directories
+	SkipHidden bool
+	// Skip everything git would ignore, going by the .gitignore files we find along the way
+	GitIgnore bool
+	// Only hash files at least this many bytes big
+	MinSize int64
+	// Only hash files at most this many bytes big, -1 means no limit
+	MaxSize int64
+	// Only hash files modified at or after this, the zero time means everything
+	// Directories are always walked no matter when they were modified, changing a file doesn't touch its directory's time
+	ModifiedSince time.Time
+	// How many directories deep to go below WalkDir, 0 means only the files directly inside it and -1 means no limit
+	MaxDepth int
+
+	// Read FIFOs, sockets and devices too instead of skipping them. Every one gets 30 seconds before we give up on it,
+	// so a FIFO nobody writes to or something endless like /dev/zero ends up in the error log instead of hanging the run
+	IncludeSpecial bool
+
+	// Don't walk into directories on a different filesystem from the root, like find -xdev
+	// Walking / then stays out of /proc, /sys, network mounts and external drives. This does nothing on Windows
+	OneFilesystem bool
+
+	// Only read a file once no matter how many hard links to it there are, every other path to it gets the same hashes
+	// Every path is still written. It works on Unix, on Windows every path gets hashed like normal
+	HardlinkAware bool
+
+	// Follow symlinks to directories and walk into them, each real directory is only walked once so loops are fine
+	FollowSymlinks bool
+	// Record where a symlink actually points instead of the path we found it at
+	ResolveLinks bool
+	// Write every symlink as a record of its own instead of hashing whatever it points at, with a Type of symlink
+	// and a Target column saying where it goes. A link to something that isn't there gets a Type of broken-symlink.
+	// Every other record gets a Type of file. The filters on the name still apply, size and time ones don't
+	RecordSymlinks bool
+	// Hash what a symlink points at but write it down under the link's own path, with a Type of symlink and a Target
+	// column with the path it resolves to. Unlike FollowSymlinks this never walks into a directory, a link to one is skipped.
+	// A broken link or a loop of links is an error. Every other record gets a Type of file
+	HashLinkTargets bool
+
+	// Record paths relative to WalkDir instead of how they were found
+	Relative bool
+	// Cut this off the front of every path and put AddPrefix there instead, so /mnt/backup on one machine and D:\backup
+	// on another can both come out as backup/... and the two indexes compare cleanly. Either kind of slash matches either
+	// in the prefix, and once a path has been rewritten it always uses /. Paths that don't start with it are left alone
+	StripPrefix string
+	// Put in front of every path after StripPrefix has been cut off, or in front of every path if StripPrefix is empty
+	AddPrefix string
+	// Add a Host column with this in it on every record, so indexes from a lot of machines can go in one dataset and
+	// you can still tell which machine each file is on. Empty leaves the column off. goindex doesn't look it up itself,
+	// the command line fills it in from os.Hostname
+	Host string
+	// An earlier index, unchanged files get their hash copied from it instead of being hashed again
+	Base *IndexFile
+	// An earlier index to write the changes since, only files that are new or whose size or modified time is different
+	// from in there get hashed and written, each with a Change column of added or changed. Appending every run's
+	// output to the same file gives you a journal of what changed when. Files that were deleted aren't written
+	SinceIndex *IndexFile
+	// Don't write the header, for when out is being added on to the end of a file that already has one or going to something that doesn't want it
+	NoHeader bool
+	// The output from a run that didn't finish, every file already in it is skipped and the header isn't written again
+	// so the new records can be appended to it. It has to have the same hashes in the same order as this run
+	Resume *IndexFile
+	// An index to bring up to date, only the files in PathList get hashed and the output is the whole of this index
+	// with their rows swapped in for the old ones, sorted by path. Rows for files that aren't listed are copied over as they are,
+	// and so is the row for a listed file that can't be hashed. A listed file that doesn't exist any more has been deleted,
+	// so its row is dropped instead. It has to have every hash this run writes
+	Update *IndexFile
+	// Only write files that share a hash with another file, grouped by hash
+	Dupes bool
+	// With Dupes, sort the hashes through temporary files instead of holding every record in memory
+	// so finding duplicates works on trees with far more files than would fit. It's slower, but the output is the same
+	DupesExternal bool
+	// Check every file against this index instead of writing records, out gets a report of every file that
+	// doesn't match, is missing or is new. The counts end up in Stats. A file that's there but couldn't be read
+	// is reported as unreadable rather than missing, it's counted in Stats.Errors like any other error
+	Verify *IndexFile
+	// Every line of the Verify report goes here too as soon as it's found, with the totals at the end, so a long
+	// verify tells you what's wrong while it's still going. out only gets the report once it's flushed
+	VerifyLive io.Writer
+	// Write the records sorted by path instead of in whatever order the workers finish them,
+	// so indexing the same tree twice gives you the same file. Big trees get sorted through temporary files
+	Sort bool
+	// Make sure every path is only in the output once, keeping the last record written for it. Overlapping roots
+	// like / and /home would otherwise give you every file under /home twice. It sorts by path like Sort does to find them,
+	// through temporary files on a big tree, so the output comes out sorted too. With Chunks every chunk is its own record
+	DedupePaths bool
+	// Where Sort, DedupePaths and DupesExternal put their temporary files, empty means the system's temporary directory
+	// Point it at a disk with room to spare on a big tree, the files add up to about the size of the index
+	TempDir string
+	// How often everything written so far gets flushed out of our buffers and synced to disk, 0 means only at the end
+	// A crash loses at most this much work instead of whatever was sitting in the buffers. Syncing isn't free, so every
+	// few seconds is plenty. Only csv, tsv and ndjson written as they go can do this, not json, sqlite, parquet, sort or dupes
+	Checkpoint time.Duration
+	// How long any one file gets to be read before we give up on it and put it in the error log, 0 means no limit
+	// Stops one huge file or a flaky mount from holding up a worker for the whole run. A read that's stuck
+	// can't actually be interrupted, it's just left behind, so the run carries on but it can't close the file
+	FileTimeout time.Duration
+	// Hash files at least this big straight out of a memory mapping instead of reading them a buffer at a time, 0 means never.
+	// It saves a read and a copy for every buffer, which adds up on big files that are already in the page cache.
+	// Anything that can't be mapped is read like normal. FileTimeout doesn't apply since nothing gets read,
+	// so don't use it on a network mount that might hang, and a file that's cut short while mapped goes in the error log
+	MmapThreshold int64
+	// The most bytes a second all of the readers together get to read, 0 means no limit. For running on a server
+	// that's busy with something else, the disk gets this much and no more however many workers there are.
+	// Waiting on the limit counts towards FileTimeout, and it can't be used with MmapThreshold since a mapping isn't read
+	RateLimit int64
+	// Only hash the first this many bytes of every file, 0 means the whole file. The size still goes in the record,
+	// so two files only come out the same if they're the same size and start the same. It's a lot quicker on big files
+	// and good enough for a first look at what might be duplicates or might have changed, a full hash can confirm it after.
+	// The hash columns are only of the heads, so this can't be used with anything that compares them against full hashes
+	HeadBytes int64
+	// Stop starting on new files once this many bytes have been indexed, 0 means no limit. Handy for taking a sample
+	// of a huge tree or a benchmark that takes the same amount of work every time. A file's bytes count as soon as a reader
+	// starts on it, and the ones already started still get finished, so you usually end up a little over.
+	// With one worker and Sort the same files get cut off every time
+	BytesLimit int64
+	// Stop the walk once this many files have a record, 0 means no limit. It counts after every filter, so with
+	// Exclude or MinSize you still get this many files out of what's left. A file that fails or is unchanged since SinceIndex
+	// doesn't count and the walk goes on to find another, so the output has exactly this many records if the tree has them
+	FileLimit int
+	// How many more times to try a file that failed with an error that might go away, like EIO or a timeout on a network drive
+	// The first retry waits 100ms and every one after that twice as long. Missing files and permission errors are never retried
+	Retries int
+	// Read a file a second time if it changed while it was being hashed, instead of writing it down as changed straight away
+	RetryChanged bool
+	// Work out what kind of file each one is from its first few bytes and add it to the record as a MIME type like image/png
+	DetectType bool
+	// Add the owner, group and permissions of every file, on Windows the owner and group are left empty
+	Permissions bool
+	// Add a HashMs column with how many milliseconds every file took to read and hash, for finding the files on a bad sector
+	// or a slow network share. It's the time from a hasher taking the file to it being done, so waiting for a free hasher
+	// doesn't count but waiting on the disk does. With Chunks every chunk gets the time for the whole file.
+	// The column follows each format's own naming, HashMs in csv and tsv, hashMs in json and xml and hash_ms in sqlite, sql and parquet
+	Timing bool
+	// Add a record for every directory with a hash made from everything inside it, so a directory's hash changes
+	// whenever anything below it does and comparing the root's hash is enough to tell if anything in the tree changed.
+	// Every record gets a Type of file or dir. It's all worked out once the walk is done, so the records are held in memory until then.
+	// Only directories with a file we indexed somewhere below them get a record, and a file we couldn't read is left out of the hash
+	DirHashes bool
+	// Work out one hash for the whole scan from the sorted paths and first hashes of every file and put it in Stats.Rollup,
+	// so comparing two scans of the same tree is comparing two strings. A file that's renamed, added, removed or changed
+	// changes it, and nothing else does. Paths go in as they're written, so the same tree under two roots only matches with relative paths
+	Rollup bool
+	// Cut every file into content defined chunks and write a record for each chunk instead of one for the whole file,
+	// with an Offset column saying where in the file it starts. A small change in the middle of a file only changes
+	// the hashes of the chunks around it, so the same chunk in two files or two versions of a file can be spotted for dedup
+	Chunks bool
+	// Roughly how big the chunks are with Chunks on, in bytes. They can be anywhere from a quarter to 8 times this
+	ChunkSize int
+	// Columns of your own that go after all of the built in ones, see Column
+	// Files only, a directory record from DirHashes leaves them empty
+	ExtraColumns []Column
+	// Don't read or hash anything, just write the path, size and modified time of every file with empty hashes
+	StatOnly bool
+	// Walk and filter like a real run and count what would have been hashed in Stats, but don't read or write anything
+	// out isn't touched at all, so you can pass nil
+	DryRun bool
+	// With DryRun, only count the files. Nothing but a symlink gets a stat unless a size or time filter needs one, so Stats.Bytes
+	// and the extensions stay empty, but on a big tree it's a lot quicker when all you want is how many files match.
+	// The count is the same as a dry run's, a broken symlink is an error in both
+	CountOnly bool
+	// If this is set every file or directory we couldn't read gets written here as a CSV row with the path and the error
+	ErrorLog io.Writer
+	// How much gets logged, LogVerbose adds a line for every file with how long it took to read and hash
+	LogLevel LogLevel
+	// Where the log goes, nil means stderr
+	LogOutput io.Writer
+	// Draw progress bars on stderr, or ProgressOutput if that's set. Turn this off if wherever they're going isn't a terminal
+	ShowProgress bool
+	// Labels that go in front of the walking and hashing bars, like indexing and hashing. Empty leaves them off
+	IndexDescription string
+	HashDescription  string
+	// Show the name of the file that was last started on after the hashing bar's label
+	// With a lot of workers it's just one of the files on the go, but it does show you which huge file the bar is stuck on
+	ProgressCurrentFile bool
+	// Where the progress bars get drawn, nil means stderr
+	// Handy when stderr is being captured into a log, a bar redrawing itself a dozen times a second makes a mess of one
+	ProgressOutput io.Writer
+	// The least time between redraws of the bars, 0 is about 15 times a second. Something like a second is kinder to a slow terminal or SSH
+	ProgressRefresh time.Duration
+	// Have the hashing bar count bytes instead of files, so its rate and ETA mean something when file sizes are all over the place
+	// Every file has to be stat'd during the walk for this, so the walk is a little slower
+	ProgressBytes bool
+	// Called for every file that goes in the index with the path it was found at and its size, for drawing progress of your own
+	// instead of the bars. A file that fails goes to OnError instead, so between them you hear about every file once,
+	// apart from one that changed while it was being hashed which is written down anyway and gets both.
+	// They get called from every worker at once so they have to be safe for that, and the run waits on them so keep them quick
+	OnFileHashed func(path string, size int64)
+	OnError      func(path string, err error)
+	// Put every file or directory we couldn't read in the output as well, with an Error column saying why and empty hashes,
+	// so the index on its own tells you everything that was and wasn't hashed. The Error column is there on every record,
+	// empty for the ones that worked. A file that changed while it was hashed keeps its hashes and gets the error next to them.
+	// LoadIndex leaves out anything with an error, so a base, resume or since index never takes one of them as done
+	ErrorsInline bool
+	// Stop the whole run at the first file or directory the walk can't get into, instead of skipping it and carrying on.
+	// Index gives back that error, so you know for sure nothing was left out of an index that finished.
+	// Only the walk is strict about it, a file that's found but can't be read is still just an error like always
+	WalkErrorsFatal bool
+}
+
+// The options goindex uses if you don't give it any flags, minus WalkDir which you always have to set
+func DefaultOptions() Options {
+	return Options{
+		Hashes:     []string{"sha256"},
+		Workers:    runtime.NumCPU(),
+		Format:     "csv",
+		WriteQueue: 1024,
+		BufferSize: 256 * 1024,
+		MaxDepth:   -1,
+		MaxSize:    -1,
+		ChunkSize:  1 << 20,
+	}
+}
+
+// Checks the options without touching the disk
+// Index calls this itself, it's exported so you can find out about bad options before you go and create an output file
+func (opts Options) Validate() error {
+	for _, walkDir := range opts.walkDirs() {
+		if walkDir == "" {
+			return fmt.Errorf("no directory to walk")
+		}
+	}
+	if err := checkHashNames(opts.Hashes); err != nil {
+		return err
+	}
+	if err := checkHashEncoding(opts.HashEncoding); err != nil {
+		return err
+	}
+	if !isKnownFormat(opts.Format) {
+		return fmt.Errorf("unknown output format %q, expected one of %s", opts.Format, strings.Join(KnownFormats, ", "))
+	}
+	if err := checkTimeFormat(opts.TimeFormat); err != nil {
+		return err
+	}
+	if err := checkColumns(opts.ExtraColumns); err != nil {
+		return err
+	}
+	// Bad glob patterns and regexes would otherwise just silently match nothing
+	if _, err := newFileFilter(opts); err != nil {
+		return err
+	}
+	// A pool with no workers would just sit there forever
+	if opts.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", opts.Workers)
+	}
+	if opts.Checkpoint < 0 {
+		return fmt.Errorf("checkpoint can't be negative, got %s", opts.Checkpoint)
+	}
+	if opts.Checkpoint > 0 {
+		if !(strings.EqualFold(opts.Format, "csv") || strings.EqualFold(opts.Format, "tsv") || strings.EqualFold(opts.Format, "ndjson")) || opts.Sort || opts.DedupePaths || opts.Update != nil || opts.Dupes || opts.DirHashes || opts.Verify != nil {
+			return fmt.Errorf("checkpoint only works with csv, tsv or ndjson written as they go, not with sort, dedupe paths, update, dupes, dir hashes or verify")
+		}
+	}
+	if opts.FileTimeout < 0 {
+		return fmt.Errorf("file timeout can't be negative, got %s", opts.FileTimeout)
+	}
+	if opts.ProgressRefresh < 0 {
+		return fmt.Errorf("progress refresh can't be negative, got %s", opts.ProgressRefresh)
+	}
+	if opts.BytesLimit < 0 {
+		return fmt.Errorf("bytes limit can't be negative, got %d", opts.BytesLimit)
+	}
+	if opts.MmapThreshold < 0 {
+		return fmt.Errorf("mmap threshold can't be negative, got %d", opts.MmapThreshold)
+	}
+	if opts.RateLimit < 0 {
+		return fmt.Errorf("rate limit can't be negative, got %d", opts.RateLimit)
+	}
+	if opts.HeadBytes < 0 {
+		return fmt.Errorf("head bytes can't be negative, got %d", opts.HeadBytes)
+	}
+	if opts.HeadBytes > 0 && (opts.Verify != nil || opts.Base != nil || opts.SinceIndex != nil || opts.Chunks) {
+		return fmt.Errorf("head bytes can't be used with verify, base, since index or chunks, they all need hashes of the whole file")
+	}
+	if opts.RateLimit > 0 && opts.MmapThreshold > 0 {
+		return fmt.Errorf("rate limit can't be used with mmap, there are no reads to hold back")
+	}
+	if opts.FileLimit < 0 {
+		return fmt.Errorf("file limit can't be negative, got %d", opts.FileLimit)
+	}
+	if opts.Retries < 0 {
+		return fmt.Errorf("retries can't be negative, got %d", opts.Retries)
+	}
+	if opts.ReadWorkers < 0 || opts.HashWorkers < 0 || opts.WalkWorkers < 0 {
+		return fmt.Errorf("walk, read and hash workers can't be negative, got %d, %d and %d", opts.WalkWorkers, opts.ReadWorkers, opts.HashWorkers)
+	}
+	if opts.ReadWorkersMin < 0 || opts.ReadWorkersMax < 0 || opts.ReadLatencyTarget < 0 {
+		return fmt.Errorf("read workers min and max and the read latency target can't be negative")
+	}
+	if opts.ReadWorkersMax == 0 && (opts.ReadWorkersMin > 0 || opts.ReadLatencyTarget > 0) {
+		return fmt.Errorf("read workers min and the read latency target only do anything with read workers max")
+	}
+	if opts.ReadWorkersMax > 0 {
+		if opts.ReadWorkersMin > opts.ReadWorkersMax {
+			return fmt.Errorf("read workers min can't be more than max, got %d and %d", opts.ReadWorkersMin, opts.ReadWorkersMax)
+		}
+		if opts.ReadWorkers > 0 {
+			return fmt.Errorf("read workers is a fixed number of readers, it can't be used with read workers max")
+		}
+	}
+	if opts.LogLevel < LogQuiet || opts.LogLevel > LogVerbose {
+		return fmt.Errorf("log level has to be between %d and %d, got %d", LogQuiet, LogVerbose, opts.LogLevel)
+	}
+	if opts.BufferSize < 1 {
+		return fmt.Errorf("buffer size must be at least 1 byte, got %d", opts.BufferSize)
+	}
+	if opts.WriteQueue < 0 {
+		return fmt.Errorf("write queue can't be negative, got %d", opts.WriteQueue)
+	}
+	if opts.MaxDepth < -1 {
+		return fmt.Errorf("max depth must be -1 or more, got %d", opts.MaxDepth)
+	}
+	if opts.MinSize < 0 {
+		return fmt.Errorf("min size can't be negative, got %d", opts.MinSize)
+	}
+	if opts.MaxSize >= 0 && opts.MaxSize < opts.MinSize {
+		return fmt.Errorf("max size %d is smaller than min size %d, nothing would ever match", opts.MaxSize, opts.MinSize)
+	}
+	if opts.Base != nil && !opts.Base.hasHashes(opts.Hashes) {
+		return fmt.Errorf("the base index only has %s hashes, it can't be used as a base for %s", strings.Join(opts.Base.hashNames, ", "), strings.Join(opts.Hashes, ", "))
+	}
+	if opts.Verify != nil && !opts.Verify.hasHashes(opts.Hashes) {
+		return fmt.Errorf("the index to verify against only has %s hashes, it can't be checked with %s", strings.Join(opts.Verify.hashNames, ", "), strings.Join(opts.Hashes, ", "))
+	}
+	if opts.StripPrefix != "" && opts.Relative {
+		return fmt.Errorf("strip prefix and relative both cut the front off the path, use add prefix with relative instead")
+	}
+	if opts.DupesExternal && !opts.Dupes {
+		return fmt.Errorf("dupes external only changes how dupes are found, it needs dupes as well")
+	}
+	if opts.Table != "" {
+		if !strings.EqualFold(opts.Format, "sqlite") && !strings.EqualFold(opts.Format, "sql") {
+			return fmt.Errorf("a table name only applies to the sqlite and sql formats")
+		}
+		if !isSQLName(opts.Table) || (strings.EqualFold(opts.Format, "sqlite") && strings.Contains(opts.Table, ".")) {
+			return fmt.Errorf("%q can't be a table name, it has to be letters, digits and underscores, with a schema in front like public.files only for sql", opts.Table)
+		}
+	}
+	if opts.Dupes && strings.EqualFold(opts.Format, "sql") {
+		return fmt.Errorf("dupes can't be written as sql, query the hash column once it's imported instead")
+	}
+	if opts.Dupes && strings.EqualFold(opts.Format, "sqlite") {
+		return fmt.Errorf("dupes can't be written as sqlite, query the hash column of the database instead")
+	}
+	if opts.Dupes && strings.EqualFold(opts.Format, "xml") {
+		return fmt.Errorf("dupes can't be written as xml, write the whole index and group by the hash elements instead")
+	}
+	// mod_time is a real timestamp in parquet, there's nothing to format
+	if strings.EqualFold(opts.Format, "parquet") {
+		if opts.Dupes {
+			return fmt.Errorf("dupes can't be written as parquet, group by the hash column instead")
+		}
+		if opts.TimeFormat != "" {
+			return fmt.Errorf("parquet always writes mod_time as a timestamp, a time format can't be used with it")
+		}
+	}
+	if opts.StatOnly && (opts.Dupes || opts.Verify != nil || opts.Base != nil) {
+		return fmt.Errorf("stat only doesn't hash anything, so it can't be used with dupes, verify or base")
+	}
+	if opts.Resume != nil {
+		if strings.Join(opts.Resume.hashNames, ",") != strings.Join(opts.Hashes, ",") {
+			return fmt.Errorf("the index being resumed has %s hashes, this run would add %s", strings.Join(opts.Resume.hashNames, ", "), strings.Join(opts.Hashes, ", "))
+		}
+		if !strings.EqualFold(opts.Format, "csv") || opts.Dupes || opts.Verify != nil || opts.Sort {
+			return fmt.Errorf("resume only works when writing a plain csv index, not with dupes, verify or sort")
+		}
+	}
+	if opts.Update != nil {
+		if !opts.Update.hasHashes(opts.Hashes) {
+			return fmt.Errorf("the index being updated has %s hashes, it needs a %s hash for every file", strings.Join(opts.Update.hashNames, ", "), strings.Join(opts.Hashes, ", "))
+		}
+		if opts.PathList == nil {
+			return fmt.Errorf("update needs a path list of the files to hash again")
+		}
+		if !strings.EqualFold(opts.Format, "csv") || opts.NoHeader {
+			return fmt.Errorf("update writes a whole new csv index, it can't write %s or leave the header off", opts.Format)
+		}
+		// Every one of these writes something other than one full record per file
+		if opts.Dupes || opts.DupesExternal || opts.Verify != nil || opts.Resume != nil || opts.SinceIndex != nil || opts.Chunks || opts.StatOnly || opts.DryRun || opts.Rollup || opts.HeadBytes > 0 {
+			return fmt.Errorf("update can't be used with dupes, verify, resume, since index, chunks, stat only, dry run, rollup or head hash")
+		}
+		// The rows that are copied over only have a path, hashes, a size and a time, they'd have nothing in any of these columns
+		if opts.DetectType || opts.RecordSymlinks || opts.HashLinkTargets || opts.Permissions || opts.Timing || opts.ErrorsInline || len(opts.ExtraColumns) > 0 {
+			return fmt.Errorf("update can't add mime, type, target, permission, timing, error or extra columns, the rows it copies from the index don't have them")
+		}
+	}
+	// With relative paths the only thing telling two roots apart is the Root column, and none of these look at it
+	if opts.rootColumn() && (opts.Base != nil || opts.Resume != nil || opts.Update != nil || opts.Verify != nil || opts.SinceIndex != nil || opts.Dupes) {
+		return fmt.Errorf("relative paths from more than one root can't be used with base, resume, update, verify, since index or dupes, the same path could be in two roots")
+	}
+	// None of these write one record per file that changed
+	if opts.SinceIndex != nil && (opts.Dupes || opts.Verify != nil || opts.DirHashes || opts.StatOnly) {
+		return fmt.Errorf("since index can't be used with dupes, verify, dir hashes or stat only")
+	}
+	if opts.Chunks {
+		if opts.ChunkSize < minChunkSize {
+			return fmt.Errorf("chunk size must be at least %d bytes, got %d", minChunkSize, opts.ChunkSize)
+		}
+		// Every one of these expects one record per file
+		if opts.Dupes || opts.Verify != nil || opts.Base != nil || opts.Resume != nil || opts.DirHashes || opts.StatOnly {
+			return fmt.Errorf("chunks can't be used with dupes, verify, base, resume, dir hashes or stat only")
+		}
+	}
+	// A list of paths doesn't have a tree to go with it
+	if opts.PathList != nil && (len(opts.Roots) > 0 || opts.DirHashes || opts.Verify != nil) {
+		return fmt.Errorf("a path list can't be used with more than one root, dir hashes or verify")
+	}
+	if opts.DirHashes && len(opts.Roots) > 0 {
+		return fmt.Errorf("dir hashes only work with one root, there'd be nothing to hash the roots together into")
+	}
+	// Following or resolving a link means treating it as what it points at, which is the opposite of this
+	if opts.RecordSymlinks && (opts.FollowSymlinks || opts.ResolveLinks || opts.Dupes || opts.Verify != nil || opts.DirHashes || opts.SinceIndex != nil) {
+		return fmt.Errorf("record symlinks can't be used with follow symlinks, resolve links, dupes, verify, dir hashes or since index")
+	}
+	if opts.HashLinkTargets && (opts.RecordSymlinks || opts.ResolveLinks || opts.DirHashes) {
+		return fmt.Errorf("hash link targets can't be used with record symlinks, resolve links or dir hashes")
+	}
+	// These all write something other than one record per path, or already have just the one
+	if opts.DedupePaths && (opts.Dupes || opts.DupesExternal || opts.Verify != nil || opts.DirHashes) {
+		return fmt.Errorf("dedupe paths can't be used with dupes, verify or dir hashes")
+	}
+	// None of these have anywhere for a file without a hash to go
+	if opts.ErrorsInline && (opts.Dupes || opts.DupesExternal || opts.Verify != nil || opts.DirHashes || opts.DryRun) {
+		return fmt.Errorf("errors inline can't be used with dupes, verify, dir hashes or dry run")
+	}
+	// None of these write a record with a hash for every file in the tree
+	if opts.Rollup && (opts.StatOnly || opts.DryRun || opts.SinceIndex != nil || opts.Resume != nil) {
+		return fmt.Errorf("rollup can't be used with stat only, dry run, since index or resume")
+	}
+	if opts.DirHashes && (opts.Dupes || opts.Verify != nil || opts.StatOnly || opts.Resume != nil || opts.ResolveLinks) {
+		return fmt.Errorf("dir hashes can't be used with dupes, verify, stat only, resume or resolve links")
+	}
+	// Two arrays one after the other isn't JSON any more
+	if opts.NoHeader && strings.EqualFold(opts.Format, "json") {
+		return fmt.Errorf("json can't be added on to an existing file, it would end up with two arrays in it")
+	}
+	// Or two <index> elements
+	if opts.NoHeader && strings.EqualFold(opts.Format, "xml") {
+		return fmt.Errorf("xml can't be added on to an existing file, it would end up with two root elements")
+	}
+	// Same goes for two parquet files, a reader only ever sees the footer at the end
+	if opts.NoHeader && strings.EqualFold(opts.Format, "parquet") {
+		return fmt.Errorf("parquet can't be added on to an existing file, only the last footer would ever be read")
+	}
+	// sql is the other way round, a whole script can go on the end of another but rows without a BEGIN can't
+	if opts.NoHeader && strings.EqualFold(opts.Format, "sql") {
+		return fmt.Errorf("sql can't leave the BEGIN off, the COMMIT at the end wouldn't have a transaction to end")
+	}
+	if opts.CountOnly && !opts.DryRun {
+		return fmt.Errorf("count only is a kind of dry run, it needs dry run on too")
+	}
+	if opts.Verify != nil && opts.Dupes {
+		return fmt.Errorf("verify and dupes can't be used together")
+	}
+	return nil
+}
+
+// Every directory we walk, WalkDir first
+func (opts Options) walkDirs() []string {
+	return append([]string{opts.WalkDir}, opts.Roots...)
+}
+
+// Whether records need a Root column to say which root their relative path is from
+func (opts Options) rootColumn() bool {
+	return opts.Relative && len(opts.Roots) > 0
+}
+
+// What the hash stage gets for its chunk size, 0 means hash whole files
+func (opts Options) chunkSize() int {
+	if opts.Chunks {
+		return opts.ChunkSize
+	}
+	return 0
+}
+
+// The table name for sqlite and sql, after filling in the default
+func (opts Options) table() string {
+	if opts.Table != "" {
+		return opts.Table
+	}
+	return "files"
+}
+
+// How many readers we actually run, after filling in the default
+func (opts Options) readWorkers() int {
+	if opts.ReadWorkers > 0 {
+		return opts.ReadWorkers
+	}
+	return opts.Workers
+}
+
+// How many hashers we actually run, after filling in the default
+func (opts Options) hashWorkers() int {
+	if opts.HashWorkers > 0 {
+		return opts.HashWorkers
+	}
+	// Any reader past the number of hashers would only be waiting for one to be free
+	if opts.ReadWorkersMax > opts.Workers {
+		return opts.ReadWorkersMax
+	}
+	return opts.Workers
+}
+
+// The record for something we couldn't read with Options.ErrorsInline, the size and time are whatever Lstat says
+// if it can say anything. errs.add doesn't know which root it came from, so that's worked out from the path
+func (opts Options) errorRecord(osPathname string, err error, hashes int) record {
+	// The longest root it's under, in case one root is inside another
+	root := ""
+	if opts.PathList == nil {
+		for _, walkDir := range opts.walkDirs() {
+			walkDir = filepath.Clean(walkDir)
+			under := osPathname == walkDir || strings.HasPrefix(osPathname, strings.TrimSuffix(walkDir, string(filepath.Separator))+string(filepath.Separator))
+			if under && len(walkDir) > len(root) {
+				root = walkDir
+			}
+		}
+	}
+	if root == "" {
+		root = filepath.Clean(opts.WalkDir)
+	}
+	rec := record{Path: opts.recordPath(root, osPathname), Hashes: make([]string, hashes), Error: err.Error()}
+	if opts.rootColumn() {
+		rec.Root = root
+	}
+	if info, err := os.Lstat(longPath(osPathname)); err == nil {
+		rec.Size, rec.ModTime = info.Size(), info.ModTime()
+	}
+	return rec
+}
+
+// Works out the path that goes in the record for a file we found at osPathname
+// Paths relative to the root make the index portable, main.go instead of C:\code\goindex\main.go
+// If WalkDir is a file this comes out as "."
+func (opts Options) recordPath(root, osPathname string) string {
+	// The \\?\ Windows needs for a long path is only for opening it, it doesn't go in the index
+	recordPath := trimLongPath(osPathname)
+	if opts.ResolveLinks {
+		if real, err := filepath.EvalSymlinks(osPathname); err == nil {
+			recordPath = trimLongPath(real)
+		}
+	}
+	if opts.Relative {
+		if rel, err := filepath.Rel(trimLongPath(root), recordPath); err == nil {
+			recordPath = rel
+		}
+	}
+	return opts.rewritePrefix(recordPath)
+}
+
+// Walks opts.WalkDir and then every one of opts.Roots, hashes every file that passes the filters and writes the results to out
+// Files we can't read don't stop the run, they're counted in Stats.Errors and written to opts.ErrorLog
+// Cancelling ctx stops the walk, the files already being hashed get finished and written so out is still valid,
+// in that case you get back the Stats for what did get done along with ctx.Err()
+// Files start getting hashed as soon as the walk finds them, so the first records come out straight away on a huge tree
+// and memory stays flat, the walk is only ever a few thousand files ahead of the hashing instead of holding on to the whole tree
+func Index(ctx context.Context, opts Options, out io.Writer) (Stats, error) {
+	if err := opts.Validate(); err != nil {
+		return Stats{}, err
+	}
+	hashNames := opts.Hashes
+
+	// Keeps track of everything we need for the summary, the clock starts now
+	stats := newRunStats()
+	logger := opts.logger()
+	errs := newErrorLog(opts.ErrorLog, stats, logger, opts.OnError)
+
+	// Figure out how we're going to write our results
+	// Everything goes through a bufio.Writer so we're not doing a syscall for every little write
+	buffered := bufio.NewWriter(out)
+	var rw recordWriter
+	var err error
+	switch {
+	case opts.DryRun:
+		rw = discardWriter{}
+	case opts.DupesExternal:
+		rw = dupePairWriter{newSortingWriter(newExternalDupesWriter(opts.Format, buffered, hashNames), hashLess, opts.TempDir)}
+	case opts.Dupes:
+		rw = newDupesWriter(opts.Format, buffered, hashNames)
+	case opts.Verify != nil:
+		rw = newVerifyWriter(buffered, opts.Verify, hashNames, opts.HashEncoding, stats, opts.VerifyLive)
+	case strings.EqualFold(opts.Format, "sqlite"):
+		// sqlite does its own writing, it just needs to know which file out is
+		rw, err = newSQLiteWriter(out, opts)
+	default:
+		rw, err = newRecordWriter(opts, buffered)
+	}
+	if err != nil {
+		return stats.snapshot(), err
+	}
+	// The header is already at the top of the file we're adding to
+	if opts.Resume != nil || opts.NoHeader {
+		rw = noHeaderWriter{rw}
+	}
+	// dupes and dir hashes already sort everything themselves
+	// The update writer gets told about deleted files on the list as well as the records, so we hang on to it
+	var updater *updateWriter
+	switch {
+	case opts.DirHashes:
+		rw = newDirHashWriter(rw, hashNames, opts.HashEncoding, opts.recordPath(filepath.Clean(opts.WalkDir), filepath.Clean(opts.WalkDir)))
+	// The old rows go in with the new ones, sorted so they mix in together
+	case opts.Update != nil:
+		updater = newUpdateWriter(rw, opts.Update, hashNames, opts.HashEncoding, opts.TempDir)
+		rw = updater
+	// Deduping sorts them anyway, so Sort has nothing left to do
+	case opts.DedupePaths:
+		rw = newDedupePathsWriter(rw, opts.TempDir)
+	case opts.Sort && !opts.Dupes:
+		rw = newSortingWriter(rw, recordLess, opts.TempDir)
+	}
+	// Outside everything else so it only sees the files, not the directories DirHashes adds
+	if opts.Rollup {
+		rw = newRollupWriter(rw, hashNames, opts.HashEncoding, opts.TempDir, stats)
+	}
+	// Validate made sure rw is one that writes as it goes, so everything up to the last checkpoint is in out
+	// gzip has to be told to push out what it's compressed so far, and a file has to be told to put it on the disk
+	if opts.Checkpoint > 0 && !opts.DryRun {
+		rw = syncWriter{rw, func() error {
+			if err := buffered.Flush(); err != nil {
+				return err
+			}
+			if f, ok := out.(interface{ Flush() error }); ok {
+				if err := f.Flush(); err != nil {
+					return err
+				}
+			}
+			// Syncing a pipe or a terminal is an error, and there's no disk behind one anyway
+			if f, ok := out.(*os.File); ok {
+				if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+					return f.Sync()
+				}
+			}
+			return nil
+		}}
+	}
+
+	var deleted func(recordPath string)
+	if updater != nil {
+		deleted = updater.remove
+	}
+	err = walk(ctx, opts, rw, deleted, stats, errs, logger)
+	if flushErr := buffered.Flush(); err == nil {
+		err = flushErr
+	}
+	if flushErr := errs.flush(); err == nil {
+		err = flushErr
+	}
+	return stats.snapshot(), err
+}
+
+// What goes in the Change column with Options.SinceIndex
+const (
+	changeAdded   = "added"
+	changeChanged = "changed"
+)
+
+// How many files the walk can get ahead of the readers
+const fileQueue = 4096
+
+// Does the actual walking and hashing for Index, every record ends up in rw, header first
+// The walk, the readers, the hashers and the writer all run at the same time, each one handing over to the next
+// through a channel with a fixed size, so memory depends on how many workers there are rather than how big the tree is
+// rw gets flushed before this returns, anything rw writes to is up to the caller
+// deleted is told about every file on the PathList that doesn't exist any more, nil unless there's an index being updated
+func walk(ctx context.Context, opts Options, rw recordWriter, deleted func(recordPath string), stats *runStats, errs *errorLog, logger *Logger) error {
+	hashNames := opts.Hashes
+	filter, _ := newFileFilter(opts)
+
+	// Files go from the walk to the readers through here as soon as they're found, so hashing starts straight away
+	// instead of waiting for the whole tree to be walked first. Once it's full the walk waits for the readers to catch up,
+	// which is what keeps memory down on a big tree, it only ever holds fileQueue files no matter how many there are
+	files := make(chan func(), fileQueue)
+
+	// Cancelled once we're past Options.BytesLimit, the walk stops there same as if ctx had been cancelled
+	// and so do the readers. It's not an error though, the run just ends early without anything missing that it was meant to have
+	startCtx, stopStarting := context.WithCancel(ctx)
+	defer stopStarting()
+
+	// Cancelled once Options.FileLimit files have been found, that stops the walk but the files it already queued still get read
+	walkCtx, stopWalking := context.WithCancel(startCtx)
+	defer stopWalking()
+
+	// If the walk fails we don't want to hash half a tree, cancelling this has the readers skip whatever is still queued
+	readCtx, stopReading := context.WithCancel(startCtx)
+	defer stopReading()
+
+	indexBar := newIndexBar(opts.ShowProgress, opts.progressOutput(), opts.IndexDescription, opts.ProgressRefresh)
+
+	// We won't know how big the hashing bar is until the walk is done, whatever gets hashed before then is counted and shows up once it's drawn
+	// With StatOnly or DryRun nothing gets hashed, so there's no bar to show
+	hashBar := newHashBar(opts.ShowProgress && !opts.StatOnly && !opts.DryRun, opts.ProgressBytes, opts.progressOutput(), opts.HashDescription, opts.ProgressCurrentFile, opts.ProgressRefresh)
+
+	// Write the header into our file, if the format has one
+	// The hash columns are named after the algorithms so you can tell what produced the file
+	if err := rw.WriteHeader(); err != nil {
+		return err
+	}
+
+	// Nothing else is writing yet so the header can go straight in, after this everything goes through the writer goroutine
+	records := newAsyncWriter(rw, opts.WriteQueue, opts.Checkpoint)
+
+	// Every error from here on is inside the walk, so they all get in before the writer is closed
+	// Verify gets them too so it knows which files are there but couldn't be read, errorRecord says where they'd be in the index
+	if opts.ErrorsInline || opts.Verify != nil {
+		errs.inline = func(osPathname string, err error) {
+			records.WriteRecord(opts.errorRecord(osPathname, err, len(hashNames)))
+		}
+		defer func() { errs.inline = nil }()
+	}
+
+	// Options.FileLimit is how many files get a record, so a file takes one of the places when it gets past every filter
+	// but only keeps it once it's written. One that fails to open or read gives its place back for the next file the walk finds.
+	// With every place taken the walk waits to see how the files in flight turn out, and the one that makes it N stops it
+	places := make(chan struct{}, opts.FileLimit)
+	// The files holding a place that haven't got a record yet, by path
+	var inFlight sync.Map
+	var recorded int64
+	admit := func(osPathname string) bool {
+		if opts.FileLimit <= 0 {
+			return true
+		}
+		select {
+		case places <- struct{}{}:
+		case <-walkCtx.Done():
+			return false
+		}
+		inFlight.Store(osPathname, true)
+		return true
+	}
+	// Called once a file that was admitted has its record, or with ok false when it isn't getting one
+	settle := func(osPathname string, ok bool) {
+		if opts.FileLimit <= 0 {
+			return
+		}
+		if _, held := inFlight.LoadAndDelete(osPathname); !held {
+			return
+		}
+		if !ok {
+			<-places
+			return
+		}
+		if n := atomic.AddInt64(&recorded, 1); n == int64(opts.FileLimit) {
+			logger.Infof("Got %d files, that's the limit so the walk is stopping here", n)
+			stopWalking()
+		}
+	}
+	// Every failure goes through the error log, a path that never got a place there is left alone
+	if opts.FileLimit > 0 {
+		errs.failed = func(osPathname string) { settle(osPathname, false) }
+		defer func() { errs.failed = nil }()
+	}
+
+	// How many bytes the readers have started on so far for Options.BytesLimit, it gets added to from every reader
+	var indexedBytes int64
+
+	// Everything that comes out of the hash stage goes to the writer, same as a file we found in the base index
+	// A chunked file is still only one file as far as the stats and the bar go, it's just more than one record
+	written := func(osPathname string, rec record) {
+		if rec.Chunks != nil {
+			for _, chunk := range rec.chunkRecords() {
+				records.WriteRecord(chunk)
+			}
+		} else {
+			records.WriteRecord(rec)
+		}
+		stats.addFile(osPathname, rec.Size)
+		hashBar.done(rec.Size)
+		settle(osPathname, true)
+		if opts.OnFileHashed != nil {
+			opts.OnFileHashed(osPathname, rec.Size)
+		}
+	}
+	// Only used with HardlinkAware
+	links := newLinkCache()
+
+	hashers := newHashStage(opts.hashWorkers(), opts.BufferSize, hashNames, opts.HashEncoding, opts.DetectType, opts.chunkSize(), opts.FileTimeout, opts.MmapThreshold, opts.RateLimit, opts.HeadBytes, func(osPathname string, rec record, elapsed time.Duration, err error) {
+		// Anything waiting on this file under another name can carry on now, a file that changed while it was hashed isn't worth sharing
+		if opts.HardlinkAware {
+			links.finish(osPathname, rec, err == nil && rec.hashed())
+		}
+		switch {
+		case err != nil && rec.hashed() && opts.ErrorsInline:
+			// It's getting a record anyway, so the error goes in that instead of a second row for the same file
+			errs.log(osPathname, err)
+			rec.Error = err.Error()
+		case err != nil:
+			errs.add(osPathname, err)
+		}
+		if rec.hashed() {
+			logger.Verbosef("Hashed %s, %d bytes in %s (%.2f MB/s)", osPathname, rec.Size, elapsed.Round(time.Microsecond), float64(rec.Size)/1e6/elapsed.Seconds())
+		}
+		// A file that changed while we were hashing it still gets written, it's in the error log too so you know not to trust it
+		if !rec.hashed() {
+			hashBar.done(rec.Size)
+			return
+		}
+		if opts.Timing {
+			rec.HashTime = elapsed
+		}
+		written(osPathname, rec)
+	})
+
+	// Start the readers, there's the amount of threads we have or however many we were asked for
+	// They only open and read files, the hashing happens in the hash stage so the two can be sized separately
+	// With ReadWorkersMax the pool decides how many of them get to read at once
+	pool := newReaderPool(opts.ReadWorkersMin, opts.ReadWorkersMax, opts.ReadLatencyTarget, func() int { return len(files) }, logger)
+	var readers sync.WaitGroup
+	readers.Add(pool.workers(opts.readWorkers()))
+	for i := 0; i < pool.workers(opts.readWorkers()); i++ {
+		go func() {
+			defer readers.Done()
+			for read := range files {
+				pool.acquire()
+				read()
+				pool.release()
+			}
+		}()
+	}
+
+	// Only used when we're following symlinks
+	visited := newVisitedSet()
+
+	// Only used with GitIgnore, fills up as we walk into directories that have a .gitignore
+	gitignores := newGitIgnores()
+
+	// How many files and bytes we've handed to the readers, atomic since the parallel walk calls the callback from more than one goroutine
+	var queued, queuedBytes int64
+
+	// Everything that happens to a file once we know we want it, whether the walk found it or it came from Options.PathList
+	// root and rootName are what its record path is worked out from. It gets called from more than one goroutine with WalkWorkers
+	queue := func(root, rootName, osPathname string, de *godirwalk.Dirent) error {
+		// Reading a FIFO blocks until somebody writes to it, and a device like /dev/zero never ends, so they're left alone unless you ask
+		// An empty regular file is nothing special though, it gets the hash of no input at all like any other tool gives it
+		special := isSpecial(osPathname, de)
+		if special && !opts.IncludeSpecial {
+			stats.addSpecial()
+			return nil
+		}
+
+		// Anything the run we're resuming got to is already in the file
+		if opts.Resume != nil {
+			if _, done := opts.Resume.records[opts.recordPath(root, osPathname)]; done {
+				return nil
+			}
+		}
+
+		// A symlink is written down as where it goes rather than hashed, that includes one that's broken
+		// It doesn't go through the readers, so it's not on the hashing bar either
+		if opts.RecordSymlinks && de.IsSymlink() {
+			rec, info, err := symlinkRecord(osPathname)
+			if err != nil {
+				errs.add(osPathname, err)
+				return nil
+			}
+			if !admit(osPathname) {
+				return nil
+			}
+			indexBar.Add(1)
+			if opts.DryRun {
+				stats.addSymlink()
+				settle(osPathname, true)
+				return nil
+			}
+			rec.Path, rec.Root, rec.Hashes = opts.recordPath(root, osPathname), rootName, make([]string, len(hashNames))
+			if opts.Permissions {
+				fillPermissions(&rec, info)
+			}
+			rec.Extra = opts.extraValues(osPathname, info)
+			logger.Verbosef("Recorded %s, it's a symlink to %s", osPathname, rec.Target)
+			records.WriteRecord(rec)
+			stats.addSymlink()
+			settle(osPathname, true)
+			if opts.OnFileHashed != nil {
+				opts.OnFileHashed(osPathname, rec.Size)
+			}
+			return nil
+		}
+
+		// Everything from here on treats the link as the file it points at, so it has to point at one
+		// EvalSymlinks gives up on a loop of links the same as on one that goes nowhere
+		var linkTarget string
+		if opts.HashLinkTargets && de.IsSymlink() {
+			target, err := filepath.EvalSymlinks(osPathname)
+			if err != nil {
+				errs.add(osPathname, err)
+				return nil
+			}
+			if info, err := os.Stat(longPath(target)); err == nil && info.IsDir() {
+				logger.Verbosef("Skipped %s, it's a symlink to a directory", osPathname)
+				return nil
+			}
+			linkTarget = target
+		}
+
+		// We don't know how big a file is or when it was modified from the Dirent, so those filters need a stat
+		// Doing it here instead of in the worker means skipped files don't end up on the hashing bar
+		// Stat only and dry runs need one too, it's the only thing a stat only record is made from,
+		// and so does a bar counting bytes since it has to know how many there are before the hashing starts.
+		// Counting only needs one for a symlink, the Dirent already says anything else is there but a link can go nowhere
+		// and a dry run would turn that down, so the two counts come out the same
+		var info os.FileInfo
+		if opts.StatOnly || (opts.DryRun && !opts.CountOnly) || (opts.CountOnly && de.IsSymlink()) || opts.MinSize > 0 || opts.MaxSize >= 0 || !opts.ModifiedSince.IsZero() || hashBar.bytes {
+			var err error
+			if info, err = os.Stat(longPath(osPathname)); err != nil {
+				errs.add(osPathname, err)
+				return nil
+			}
+			if info.Size() < opts.MinSize || (opts.MaxSize >= 0 && info.Size() > opts.MaxSize) {
+				return nil
+			}
+			if info.ModTime().Before(opts.ModifiedSince) {
+				return nil
+			}
+		}
+
+		if !admit(osPathname) {
+			return nil
+		}
+
+		// Increment our index progress bar so we know the program is working and we know how far along we are
+		indexBar.Add(1)
+
+		// Without hashing there's nothing for the workers to do, we already have everything that goes in the record
+		// The hash columns are still there but empty, so anything reading the file doesn't have to know about stat only
+		// A dry run stops here, all we wanted to know is that this file would have made it
+		if opts.CountOnly {
+			stats.countFile()
+			settle(osPathname, true)
+			return nil
+		}
+		if opts.DryRun {
+			stats.addFile(osPathname, info.Size())
+			settle(osPathname, true)
+			return nil
+		}
+		if opts.StatOnly {
+			logger.Verbosef("Listed %s", osPathname)
+			rec := record{Path: opts.recordPath(root, osPathname), Root: rootName, Hashes: make([]string, len(hashNames)), Size: info.Size(), ModTime: info.ModTime()}
+			opts.setType(&rec, linkTarget)
+			if opts.Permissions {
+				fillPermissions(&rec, info)
+			}
+			rec.Extra = opts.extraValues(osPathname, info)
+			written(osPathname, rec)
+			return nil
+		}
+
+		atomic.AddInt64(&queued, 1)
+		// Without a stat we don't know the size, but then the bar is only counting files
+		var size int64
+		if info != nil {
+			size = info.Size()
+			atomic.AddInt64(&queuedBytes, size)
+		}
+		// Set once the file's size has gone towards Options.BytesLimit, so another go at it doesn't count it twice
+		var counted bool
+		// One go at reading the file, with Options.Retries this can happen more than once
+		read := func(canRetry bool) error {
+			// If anything goes wrong with this file we write it down and move on, one unreadable file shouldn't stop the whole run
+			// unless it looks like it might work next time, then it goes back to withRetries for another go
+			fail := func(err error) error {
+				if canRetry && isTransient(err) {
+					return err
+				}
+				errs.add(osPathname, err)
+				hashBar.done(size)
+				return nil
+			}
+
+			// Open the file
+			open := os.Open
+			if special {
+				open = openSpecial
+			}
+			opened := time.Now()
+			f, err := open(longPath(osPathname))
+			if err != nil {
+				return fail(err)
+			}
+
+			// Defer closing of the file until the end of the function
+			defer f.Close()
+			hashBar.current(osPathname)
+
+			// Get file info
+			finfo, err := f.Stat()
+			if err != nil {
+				return fail(err)
+			}
+			// Opening and a stat are all the storage, waiting on a hasher doesn't come into it, so that's what sizes the pool
+			pool.observe(time.Since(opened))
+
+			recordPath := opts.recordPath(root, osPathname)
+
+			rec := record{Path: recordPath, Root: rootName, Size: finfo.Size(), ModTime: finfo.ModTime()}
+			opts.setType(&rec, linkTarget)
+			if opts.Permissions {
+				fillPermissions(&rec, finfo)
+			}
+			rec.Extra = opts.extraValues(osPathname, finfo)
+
+			// Anything that looks the same as last time isn't a change, so it's not even read
+			if opts.SinceIndex != nil {
+				old, ok := opts.SinceIndex.records[recordPath]
+				switch {
+				case !ok:
+					rec.Change = changeAdded
+				case old.Size == finfo.Size() && old.ModTime.Equal(finfo.ModTime()):
+					stats.addUnchanged()
+					hashBar.done(size)
+					settle(osPathname, false)
+					return nil
+				default:
+					rec.Change = changeChanged
+				}
+			}
+
+			// The bytes count as soon as a reader starts on the file rather than when it's hashed, so with one reader
+			// it's always the same file that takes us over the limit. That one still gets done, nothing after it is started
+			if opts.BytesLimit > 0 && !counted {
+				counted = true
+				total := atomic.AddInt64(&indexedBytes, finfo.Size())
+				if total-finfo.Size() >= opts.BytesLimit {
+					hashBar.done(size)
+					settle(osPathname, false)
+					return nil
+				}
+				if total >= opts.BytesLimit {
+					logger.Infof("Got to %d bytes, that's the limit so no more files are being started", total)
+					stopStarting()
+				}
+			}
+
+			if special {
+				hashers.submitSpecial(osPathname, newDeadlineReader(f), rec)
+				return nil
+			}
+
+			// If the file looks exactly like it did in the base index we trust the old hash instead of reading the whole thing again
+			if opts.Base != nil {
+				if old, ok := opts.Base.records[recordPath]; ok && old.Size == finfo.Size() && old.ModTime.Equal(finfo.ModTime()) {
+					if rec.Hashes, ok = opts.Base.hashesFor(old, hashNames, opts.HashEncoding); ok {
+						// The base index doesn't keep the type, but the start of the file is all we need for that
+						if opts.DetectType {
+							sniffer := &typeSniffer{}
+							if _, err := io.CopyN(sniffer, f, sniffLen); err != nil && err != io.EOF {
+								return fail(err)
+							}
+							rec.Mime = sniffer.contentType()
+						}
+						logger.Verbosef("Reused the hash for %s from the base index", osPathname)
+						written(osPathname, rec)
+						return nil
+					}
+				}
+			}
+
+			// If another path to the same file got here first we wait for its hashes instead of reading the whole thing again
+			// Should that one fail we fall through and have a go at it ourselves
+			if opts.HardlinkAware {
+				if key, ok := hardlinkKey(finfo); ok {
+					entry, first := links.claim(key, osPathname)
+					if first {
+						err := hashers.submit(osPathname, f, rec, opts.RetryChanged, canRetry)
+						if err != nil {
+							links.abandon(osPathname)
+						}
+						return err
+					}
+					<-entry.done
+					if entry.ok {
+						rec.Hashes, rec.Chunks = entry.rec.Hashes, entry.rec.Chunks
+						if opts.DetectType {
+							rec.Mime = entry.rec.Mime
+						}
+						logger.Verbosef("Reused the hash for %s, it's a hard link to %s", osPathname, entry.rec.Path)
+						written(osPathname, rec)
+						return nil
+					}
+				}
+			}
+
+			// Hand the file over to a hasher, it'll write the record once it has one hash per algorithm
+			// If reading it fails part way with something worth retrying we get the error back, the hasher throws away what it had
+			return hashers.submit(osPathname, f, rec, opts.RetryChanged, canRetry)
+		}
+
+		// Hand the file to the readers, this waits if they're fileQueue files behind
+		files <- func() {
+			// Anything still in the queue after we've been cancelled gets skipped, only the files already being hashed get finished
+			if readCtx.Err() != nil {
+				return
+			}
+			// We only get an error back if we were cancelled while waiting to try again
+			if err := withRetries(readCtx, opts.Retries, logger, osPathname, read); err != nil {
+				errs.add(osPathname, err)
+				hashBar.done(size)
+			}
+		}
+		return nil
+	}
+
+	// Walks one root, everything it finds goes to the same readers so the roots all end up in the same output
+	walkRoot := func(walkDir string) error {
+		// godirwalk cleans the path it's given, so we do the same to be able to recognise the root in the callback
+		root := filepath.Clean(walkDir)
+		// Only filled in when the records need to say which root they're from
+		var rootName string
+		if opts.rootColumn() {
+			rootName = root
+		}
+		// Every root has its own, so walking / and /mnt/backup together still does both
+		rootDevice, haveDevice := uint64(0), false
+		if opts.OneFilesystem {
+			rootDevice, haveDevice = deviceID(root)
+		}
+
+		// A callback function similar to the go stdlib filepath.WalkDir
+		// With WalkWorkers above 1 this gets called from several goroutines at once, everything it touches has to be safe for that
+		callback := func(osPathname string, de *godirwalk.Dirent) error {
+			// Stop walking once we've been cancelled, the ErrorCallback turns this into a Halt
+			if err := walkCtx.Err(); err != nil {
+				return err
+			}
+
+			// When we're following symlinks a link to a directory gets treated just like the directory
+			isDir := de.IsDir()
+			if opts.FollowSymlinks && de.IsSymlink() {
+				isDir, _ = de.IsDirOrSymlinkToDir()
+			}
+
+			// Never skip the directory we were asked to walk, even if it's hidden itself
+			if opts.SkipHidden && osPathname != root && isHidden(osPathname, de) {
+				// Returning SkipThis on a directory means godirwalk won't even look inside it
+				if isDir {
+					return godirwalk.SkipThis
+				}
+				return nil
+			}
+
+			// Skipping these here means we never even read what's inside them, which is a huge time saver on something like node_modules
+			if isDir && filter.skipDir(osPathname) {
+				return godirwalk.SkipThis
+			}
+
+			// A directory that's somewhere else mounted, there's no telling how much is in there
+			if haveDevice && isDir && osPathname != root {
+				if device, ok := deviceID(osPathname); ok && device != rootDevice {
+					logger.Verbosef("Skipped %s, it's on a different filesystem", osPathname)
+					return godirwalk.SkipThis
+				}
+			}
+
+			// Same as the hidden check, the root is walked even if git would ignore it
+			if opts.GitIgnore && osPathname != root && gitignores.ignored(osPathname, isDir) {
+				if isDir {
+					return godirwalk.SkipThis
+				}
+				return nil
+			}
+
+			// If we've already been in this directory through some other path we've already got everything in it
+			if opts.FollowSymlinks && isDir && !visited.visit(osPathname) {
+				return godirwalk.SkipThis
+			}
+
+			// godirwalk doesn't keep track of how deep we are, so we work it out from the path
+			// A directory at depth N holds files at depth N+1, so anything at the limit doesn't need to be opened at all
+			if opts.MaxDepth >= 0 && isDir && pathDepth(root, osPathname) >= opts.MaxDepth {
+				return godirwalk.SkipThis
+			}
+
+			// We're definitely going in to this directory, so its .gitignore needs to be ready for what's inside
+			if opts.GitIgnore && isDir {
+				if err := gitignores.load(osPathname); err != nil {
+					errs.add(osPathname, err)
+				}
+			}
+
+			// Ignore directories since we're only looking for files
+			// Files that don't pass the filter never make it in to the queue, so they don't count towards the progress bars either
+			if isDir || !filter.match(osPathname) {
+				return nil
+			}
+
+			return queue(root, rootName, osPathname, de)
+		}
+
+		// Callback for any errors we recieve when we're indexing, you could log these to a different file you if you wanted to
+		onError := func(osPathname string, err error) godirwalk.ErrorAction {
+			// This is the error we returned from the Callback after being cancelled, there's no point carrying on
+			if walkCtx.Err() != nil {
+				return godirwalk.Halt
+			}
+			// With FollowSymlinks godirwalk stats every symlink to see if it's a directory to walk into, and one to nowhere fails that.
+			// The callback has already had it as a file, so the hasher reports it and this would only count it twice.
+			// parallelWalk doesn't report those either, and a file that can't be read isn't one for WalkErrorsFatal
+			if opts.FollowSymlinks {
+				if info, lerr := os.Lstat(osPathname); lerr == nil && info.Mode()&os.ModeSymlink != 0 {
+					if _, serr := os.Stat(osPathname); serr != nil {
+						return godirwalk.SkipNode
+					}
+				}
+			}
+			errs.add(osPathname, err)
+			if opts.WalkErrorsFatal {
+				return godirwalk.Halt
+			}
+			return godirwalk.SkipNode
+		}
+
+		if opts.WalkWorkers > 1 {
+			return parallelWalk(root, opts.WalkWorkers, opts.FollowSymlinks, callback, onError)
+		}
+		return godirwalk.Walk(root, &godirwalk.Options{
+			Callback:            callback,
+			ErrorCallback:       onError,
+			Unsorted:            true,
+			FollowSymbolicLinks: opts.FollowSymlinks,
+			// Lets you point WalkDir at a single file and just get that one file hashed
+			AllowNonDirectory: true,
+		})
+	}
+
+	// Every path on the list gets the same treatment as a file the walk found, there's just no walk
+	listed := func(osPathname string) {
+		de, err := godirwalk.NewDirent(osPathname)
+		switch {
+		case err != nil && deleted != nil && os.IsNotExist(err):
+			logger.Infof("Dropped %s from the index, it doesn't exist any more", osPathname)
+			deleted(opts.recordPath(filepath.Clean(opts.WalkDir), osPathname))
+		case err != nil:
+			errs.add(osPathname, err)
+		case de.IsDir():
+			errs.add(osPathname, errListedDir)
+		default:
+			queue(filepath.Clean(opts.WalkDir), "", osPathname, de)
+		}
+	}
+
+	var walkErr error
+	walkDirs := opts.walkDirs()
+	if opts.PathList != nil {
+		walkErr = readPathList(walkCtx, opts.PathList, opts.pathListSeparator(), listed)
+	} else {
+		for _, walkDir := range walkDirs {
+			if walkCtx.Err() != nil {
+				break
+			}
+			err := walkRoot(walkDir)
+			// With more than one root, one we can't walk is just another error and the rest still get done
+			if err != nil && len(walkDirs) > 1 && walkCtx.Err() == nil && !opts.WalkErrorsFatal {
+				errs.add(walkDir, err)
+				continue
+			}
+			if err != nil {
+				walkErr = err
+				break
+			}
+		}
+	}
+
+	// Stopping at the limit makes the walk give back the cancel, but that's just us being done early rather than it failing
+	if walkErr != nil && walkCtx.Err() != nil && ctx.Err() == nil {
+		walkErr = nil
+	}
+
+	// The index bar might be holding back the last few files with Options.ProgressRefresh
+	flushProgress(indexBar)
+
+	// Now that we know how many files there are we can size our hashing progress bar, this is also when it first gets drawn
+	hashBar.setTotal(int(queued), queuedBytes)
+
+	// The walk is over so nothing else is coming, wait for the readers to get through what's left
+	// If the walk itself failed the readers skip everything they haven't started on yet
+	if walkErr != nil && ctx.Err() == nil {
+		stopReading()
+	}
+	close(files)
+	readers.Wait()
+	pool.close()
+
+	// Every reader is done so nothing else can be handed to the hashers, wait for them to finish off what they've got
+	hashers.close()
+
+	// Now that every worker is done wait for the writer to catch up, it flushes rw once it has
+	err := records.Close()
+
+	// Being cancelled isn't the walk's fault, but it does mean the output is missing whatever we didn't get to
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case walkErr != nil:
+		return &WalkError{Err: walkErr}
+	}
+	return err
+}
+
+// What Index and Watch give back when the walk itself failed, like a root that can't be read or the first error
+// with Options.WalkErrorsFatal, rather than the options being wrong or the output not being writable
+type WalkError struct {
+	Err error
+}
+
+func (e *WalkError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *WalkError) Unwrap() error {
+	return e.Err
+}
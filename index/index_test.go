@@ -0,0 +1,303 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Depth 0 is walkDir's own files, every level after that goes one directory further down
+func TestMaxDepth(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"top.txt": "", "a/one.txt": "", "a/b/two.txt": "", "a/b/c/three.txt": "", "x/one.txt": ""})
+	tests := []struct {
+		depth int
+		want  []string
+	}{
+		{0, []string{"top.txt"}},
+		{1, []string{"a/one.txt", "top.txt", "x/one.txt"}},
+		{2, []string{"a/b/two.txt", "a/one.txt", "top.txt", "x/one.txt"}},
+		{3, []string{"a/b/c/three.txt", "a/b/two.txt", "a/one.txt", "top.txt", "x/one.txt"}},
+		{-1, []string{"a/b/c/three.txt", "a/b/two.txt", "a/one.txt", "top.txt", "x/one.txt"}},
+	}
+	for _, test := range tests {
+		opts := testOptions(dir)
+		opts.MaxDepth = test.depth
+		if got := indexedPaths(t, opts); !equalStrings(got, test.want) {
+			t.Errorf("depth %d got %v, want %v", test.depth, got, test.want)
+		}
+	}
+	opts := testOptions(dir)
+	opts.MaxDepth = -2
+	if err := opts.Validate(); err == nil {
+		t.Errorf("a depth of -2 was allowed")
+	}
+}
+
+// Cancelling part way through still leaves a CSV that parses, with a whole row for every file that was hashed
+func TestCancelledRunIsValid(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[string]string)
+	for i := 0; i < 2000; i++ {
+		files[fmt.Sprintf("d%d/f%d.txt", i%10, i)] = fmt.Sprint(i)
+	}
+	writeTree(t, dir, files)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := testOptions(dir)
+	var mu sync.Mutex
+	hashed := make(map[string]bool)
+	opts.OnFileHashed = func(path string, size int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		hashed[path] = true
+		if len(hashed) == 50 {
+			cancel()
+		}
+	}
+	var out bytes.Buffer
+	_, err := Index(ctx, opts, &out)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want it cancelled", err)
+	}
+	records := parseCSV(t, out.String())
+	if len(records) < 50 || len(records) == len(files) {
+		t.Errorf("got %d records, want the 50 or so hashed before the cancel", len(records))
+	}
+	for _, rec := range records {
+		if !hashed[rec["Path"]] || rec["SHA256 Hash"] == "" {
+			t.Errorf("got a row for %s that wasn't hashed: %v", rec["Path"], rec)
+		}
+	}
+	if len(records) != len(hashed) {
+		t.Errorf("got %d records for %d hashed files", len(records), len(hashed))
+	}
+}
+
+// A timeout is a cancel that comes from the clock, the rows written before it are whole and right
+func TestTimeoutLeavesValidOutput(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[string]string)
+	block := strings.Repeat("x", 16<<10)
+	for i := 0; i < 4000; i++ {
+		files[fmt.Sprintf("d%d/f%d.txt", i%20, i)] = fmt.Sprint(i) + block
+	}
+	writeTree(t, dir, files)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var out bytes.Buffer
+	_, err := Index(ctx, testOptions(dir), &out)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the deadline to have run out", err)
+	}
+	records := parseCSV(t, out.String())
+	if len(records) == len(files) {
+		t.Fatalf("all %d files got done inside the timeout, the fixture needs to be bigger", len(files))
+	}
+	for _, rec := range records {
+		rel, err := filepath.Rel(dir, rec["Path"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content, ok := files[filepath.ToSlash(rel)]; !ok || rec["SHA256 Hash"] != sha256Hex(content) || rec["Size"] != fmt.Sprint(len(content)) {
+			t.Errorf("got a bad row for %s: %v", rel, rec)
+		}
+	}
+}
+
+// Every file from every root ends up in the one index, and one root that isn't there doesn't stop the rest
+func TestRoots(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	writeTree(t, first, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+	writeTree(t, second, map[string]string{"a.txt": "other a", "c.txt": "c"})
+	opts := testOptions(first)
+	opts.LogOutput = ioutil.Discard
+	opts.Roots = []string{filepath.Join(t.TempDir(), "missing"), second}
+	var errLog bytes.Buffer
+	opts.ErrorLog = &errLog
+
+	out, stats := runIndex(t, opts)
+	byPath := make(map[string]map[string]string)
+	for _, rec := range parseCSV(t, out) {
+		byPath[rec["Path"]] = rec
+	}
+	want := map[string]string{
+		filepath.Join(first, "a.txt"):        "a",
+		filepath.Join(first, "sub", "b.txt"): "b",
+		filepath.Join(second, "a.txt"):       "other a",
+		filepath.Join(second, "c.txt"):       "c",
+	}
+	for path, content := range want {
+		if byPath[path]["SHA256 Hash"] != sha256Hex(content) {
+			t.Errorf("%s is missing or wrong: %v", path, byPath[path])
+		}
+	}
+	if len(byPath) != len(want) {
+		t.Errorf("got %d records, want %d", len(byPath), len(want))
+	}
+	if stats.Errors != 1 || !strings.Contains(errLog.String(), "missing") {
+		t.Errorf("got %d errors for the missing root, want 1:\n%s", stats.Errors, errLog.String())
+	}
+
+	// Relative paths can be the same in two roots, the Root column tells them apart
+	opts = testOptions(first)
+	opts.LogOutput = ioutil.Discard
+	opts.Roots = []string{second}
+	opts.Relative = true
+	out, _ = runIndex(t, opts)
+	roots := make(map[string]string)
+	for _, rec := range parseCSV(t, out) {
+		if rec["Path"] == "a.txt" {
+			roots[rec["Root"]] = rec["SHA256 Hash"]
+		}
+	}
+	if roots[first] != sha256Hex("a") || roots[second] != sha256Hex("other a") {
+		t.Errorf("got a.txt from roots %v", roots)
+	}
+}
+
+// Only what's new or different since the old index gets a row, each one saying which it is
+func TestSinceIndex(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"same.txt": "same", "edited.txt": "before", "touched.txt": "touched"})
+	old := saveIndex(t, dir)
+
+	writeTree(t, dir, map[string]string{"edited.txt": "after, and longer", "new/added.txt": "added"})
+	// Just the time is enough to count as a change, and it gets hashed again to find out what it is now
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "touched.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions(dir)
+	opts.SinceIndex = old
+	out, stats := runIndex(t, opts)
+	byPath := make(map[string]map[string]string)
+	for _, rec := range parseCSV(t, out) {
+		byPath[rec["Path"]] = rec
+	}
+
+	want := map[string][2]string{
+		"edited.txt":    {changeChanged, "after, and longer"},
+		"touched.txt":   {changeChanged, "touched"},
+		"new/added.txt": {changeAdded, "added"},
+	}
+	for name, w := range want {
+		rec := byPath[filepath.Join(dir, filepath.FromSlash(name))]
+		if rec["Change"] != w[0] || rec["SHA256 Hash"] != sha256Hex(w[1]) {
+			t.Errorf("%s got %v, want it %s", name, rec, w[0])
+		}
+	}
+	if len(byPath) != len(want) {
+		t.Errorf("got %d rows, want %d, same.txt shouldn't be there: %v", len(byPath), len(want), byPath)
+	}
+	if stats.Unchanged != 1 {
+		t.Errorf("got %d unchanged, want 1", stats.Unchanged)
+	}
+}
+
+// One worker with Sort cuts off at the same files every time, the ones in flight when the limit is crossed still get finished
+func TestBytesLimit(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("f%02d.txt", i)] = strings.Repeat("x", 100)
+	}
+	writeTree(t, dir, files)
+	opts := testOptions(dir)
+	opts.Workers = 1
+	opts.Sort = true
+	opts.BytesLimit = 350
+	opts.LogOutput = ioutil.Discard
+
+	first := indexedPaths(t, opts)
+	// The fourth file takes us over and still gets done
+	if len(first) != 4 {
+		t.Fatalf("got %d files with a limit of 350 bytes out of 100 byte files: %v", len(first), first)
+	}
+	for i := 0; i < 5; i++ {
+		if again := indexedPaths(t, opts); !equalStrings(again, first) {
+			t.Fatalf("got %v the first time and %v after", first, again)
+		}
+	}
+
+	// A limit bigger than the tree is everything
+	opts.BytesLimit = 1 << 20
+	if all := indexedPaths(t, opts); len(all) != len(files) {
+		t.Errorf("got %d files under a limit bigger than the tree, want %d", len(all), len(files))
+	}
+}
+
+// Nothing to hash is still a run, with the progress bars drawn and just the header written
+func TestEmptyDirectory(t *testing.T) {
+	opts := testOptions(t.TempDir())
+	opts.ShowProgress = true
+	var progress bytes.Buffer
+	opts.ProgressOutput = &progress
+	out, stats := runIndex(t, opts)
+	if out != "Path,SHA256 Hash,Time,Size\n" {
+		t.Errorf("got %q, want only the header", out)
+	}
+	if stats.Files != 0 || stats.Errors != 0 {
+		t.Errorf("got %d files and %d errors", stats.Files, stats.Errors)
+	}
+}
+
+// Relative paths are the same whatever walkDir looks like, with no separator at the front
+func TestRelativePaths(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"top.txt": "", "a/b/deep.txt": ""})
+	for _, walkDir := range []string{dir, dir + string(filepath.Separator), filepath.Join(dir, "a", "..")} {
+		opts := testOptions(walkDir)
+		opts.Relative = true
+		out, _ := runIndex(t, opts)
+		var got []string
+		for _, rec := range parseCSV(t, out) {
+			got = append(got, rec["Path"])
+		}
+		sort.Strings(got)
+		if want := []string{filepath.Join("a", "b", "deep.txt"), "top.txt"}; !equalStrings(got, want) {
+			t.Errorf("walkDir %s got %v, want %v", walkDir, got, want)
+		}
+		for _, path := range got {
+			if strings.HasPrefix(path, string(filepath.Separator)) || strings.HasPrefix(path, ".") {
+				t.Errorf("walkDir %s got %q", walkDir, path)
+			}
+		}
+	}
+
+	// A file as the root has nothing to be relative to but itself
+	opts := testOptions(filepath.Join(dir, "top.txt"))
+	opts.Relative = true
+	out, _ := runIndex(t, opts)
+	if records := parseCSV(t, out); len(records) != 1 || records[0]["Path"] != "." {
+		t.Errorf("a file as walkDir got %v, want .", records)
+	}
+}
+
+// A walkDir that isn't there is the walk failing, and it says so with a WalkError. Bad options aren't
+func TestWalkError(t *testing.T) {
+	opts := testOptions(filepath.Join(t.TempDir(), "missing"))
+	opts.LogOutput = ioutil.Discard
+	_, err := Index(context.Background(), opts, &bytes.Buffer{})
+	var walkErr *WalkError
+	if !errors.As(err, &walkErr) || !os.IsNotExist(walkErr.Err) || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v, want a WalkError for the missing walkDir", err)
+	}
+
+	opts = testOptions(t.TempDir())
+	opts.Format = "nope"
+	if _, err := Index(context.Background(), opts, &bytes.Buffer{}); err == nil || errors.As(err, &walkErr) {
+		t.Errorf("a bad format got %v, want an error that isn't the walk's", err)
+	}
+}
@@ -0,0 +1,173 @@
+package index
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The layout time.Time.String() uses, which is what ends up in the Time column
+const recordTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// An index we wrote on an earlier run, read back in to memory with LoadIndex
+type IndexFile struct {
+	// The algorithms the index has hashes for, in the order of its columns
+	hashNames []string
+	// Every record in the index keyed by its path, Hashes lines up with hashNames
+	records map[string]record
+}
+
+// Cuts off the last line of name if it was only half written, which is what you get if goindex was killed in the middle of a write
+// Every line we write ends in a newline, so anything after the last one has to go. Call this before appending to a file with Options.Resume
+func TrimPartialLine(name string) error {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Walk backwards from the end a block at a time looking for the last newline, there's no need to read the whole file
+	buf := make([]byte, 64*1024)
+	end := info.Size()
+	for end > 0 {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		block := buf[:end-start]
+		if _, err := f.ReadAt(block, start); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(block, '\n'); i >= 0 {
+			if start+int64(i)+1 == info.Size() {
+				return nil
+			}
+			return f.Truncate(start + int64(i) + 1)
+		}
+		end = start
+	}
+	// Not a single complete line, not even the header
+	return f.Truncate(0)
+}
+
+// Reads back a CSV index written by Index
+// The header tells us where everything is, so it doesn't matter which hashes it has or what order they're in.
+// Leading spaces are trimmed so the old "Path, Hash, Time" files with a space after every comma still work
+func LoadIndex(name string) (*IndexFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.TrimLeadingSpace = true
+	// Older indexes don't have a Size column, so don't insist every file has the same number of fields
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: can't read the header: %s", name, err)
+	}
+
+	ix := &IndexFile{records: make(map[string]record)}
+	pathCol, timeCol, sizeCol, errorCol := -1, -1, -1, -1
+	var hashCols []int
+	for i, column := range header {
+		column = strings.TrimSpace(column)
+		switch {
+		case strings.EqualFold(column, "Path"):
+			pathCol = i
+		case strings.EqualFold(column, "Time"):
+			timeCol = i
+		case strings.EqualFold(column, "Size"):
+			sizeCol = i
+		case strings.EqualFold(column, "Error"):
+			errorCol = i
+		case strings.EqualFold(column, "Hash"):
+			// The very first version of goindex only did sha256 and just called the column Hash
+			ix.hashNames = append(ix.hashNames, "sha256")
+			hashCols = append(hashCols, i)
+		case strings.HasSuffix(strings.ToLower(column), " hash"):
+			ix.hashNames = append(ix.hashNames, strings.ToLower(strings.TrimSpace(column[:len(column)-len(" hash")])))
+			hashCols = append(hashCols, i)
+		}
+	}
+	if pathCol < 0 || timeCol < 0 || len(hashCols) == 0 {
+		return nil, fmt.Errorf("%s: doesn't look like a goindex CSV, it needs Path, Hash and Time columns", name)
+	}
+
+	// Line numbers are only for error messages, they'll be off if a path has a newline in it but that's rare enough
+	for line := 2; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		if len(row) <= pathCol || len(row) <= timeCol || len(row) <= hashCols[len(hashCols)-1] {
+			return nil, fmt.Errorf("%s: line %d is missing columns", name, line)
+		}
+
+		// A file Options.ErrorsInline wrote down as failed has nothing we can use
+		if errorCol >= 0 && errorCol < len(row) && row[errorCol] != "" {
+			continue
+		}
+
+		rec := record{Path: row[pathCol], Size: -1}
+		for _, col := range hashCols {
+			rec.Hashes = append(rec.Hashes, row[col])
+		}
+		if rec.ModTime, err = parseRecordTime(row[timeCol]); err != nil {
+			return nil, fmt.Errorf("%s: line %d: bad time: %s", name, line, err)
+		}
+		// A missing size stays at -1 so it never matches a real file
+		if sizeCol >= 0 && sizeCol < len(row) {
+			if rec.Size, err = strconv.ParseInt(strings.TrimSpace(row[sizeCol]), 10, 64); err != nil {
+				return nil, fmt.Errorf("%s: line %d: bad size: %s", name, line, err)
+			}
+		}
+		ix.records[rec.Path] = rec
+	}
+	return ix, nil
+}
+
+// The algorithms the index has hashes for, in the order of its columns
+func (ix *IndexFile) HashNames() []string {
+	return append([]string(nil), ix.hashNames...)
+}
+
+// Picks the hashes for the given algorithms out of a record from this index, written in the given encoding
+// whatever encoding the index was written with. Returns false if the index doesn't have one of them
+func (ix *IndexFile) hashesFor(rec record, hashNames []string, encoding string) ([]string, bool) {
+	hashes := make([]string, len(hashNames))
+	for i, name := range hashNames {
+		found := false
+		for j, have := range ix.hashNames {
+			if have == name {
+				hashes[i] = reencodeSum(name, rec.Hashes[j], encoding)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return hashes, true
+}
+
+// Returns true if the index has a hash for every one of the given algorithms
+func (ix *IndexFile) hasHashes(hashNames []string) bool {
+	_, ok := ix.hashesFor(record{Hashes: make([]string, len(ix.hashNames))}, hashNames, "")
+	return ok
+}
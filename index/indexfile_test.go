@@ -0,0 +1,82 @@
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// What a run that was killed part way through leaves behind, a few whole rows and half of the next one
+func TestResumePartialFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c", "d/e.txt": "e", "d/f.txt": "f"}
+	writeTree(t, dir, files)
+	full, _ := runIndex(t, testOptions(dir))
+	lines := strings.SplitAfter(full, "\n")
+	partial := strings.Join(lines[:3], "") + lines[3][:len(lines[3])/2]
+	name := filepath.Join(t.TempDir(), "files.csv")
+	if err := ioutil.WriteFile(name, []byte(partial), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := TrimPartialLine(name); err != nil {
+		t.Fatal(err)
+	}
+	resume, err := LoadIndex(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions(dir)
+	opts.Resume = resume
+	out, stats := runIndex(t, opts)
+	if stats.Files != 3 {
+		t.Errorf("hashed %d files, want the 3 that weren't done", stats.Files)
+	}
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(out)
+	f.Close()
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(data), "Path,") != 1 {
+		t.Errorf("got more than one header:\n%s", data)
+	}
+	records := parseCSV(t, string(data))
+	if len(records) != len(files) {
+		t.Errorf("got %d records, want %d:\n%s", len(records), len(files), data)
+	}
+	for _, rec := range records {
+		rel, _ := filepath.Rel(dir, rec["Path"])
+		if content, ok := files[filepath.ToSlash(rel)]; !ok || rec["SHA256 Hash"] != sha256Hex(content) {
+			t.Errorf("got %v", rec)
+		}
+		delete(files, filepath.ToSlash(rel))
+	}
+}
+
+func TestTrimPartialLine(t *testing.T) {
+	for contents, want := range map[string]string{
+		"Path,Hash\na,1\nb,2\n": "Path,Hash\na,1\nb,2\n",
+		"Path,Hash\na,1\nb,":    "Path,Hash\na,1\n",
+		"Path,Ha":               "",
+		"":                      "",
+	} {
+		name := filepath.Join(t.TempDir(), "files.csv")
+		if err := ioutil.WriteFile(name, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := TrimPartialLine(name); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := ioutil.ReadFile(name); string(got) != want {
+			t.Errorf("%q came out as %q, want %q", contents, got, want)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import (
+	"os"
+	"syscall"
+)
+
+// Which file on disk info is, ok is only true if there's more than one path to it
+// A file with a link count of 1 can't have been hashed already under another name, so there's no point keeping track of it
+func hardlinkKey(info os.FileInfo) (inodeKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return inodeKey{}, false
+	}
+	return inodeKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package index
+
+import "os"
+
+// NTFS has hard links too, but the file index that tells you two paths are the same file needs the file open and a
+// call os.Stat doesn't make, so on Windows every path gets hashed on its own
+func hardlinkKey(info os.FileInfo) (inodeKey, bool) {
+	return inodeKey{}, false
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Broken symlinks fail to open, so they can't use up any of the limit
+func TestFileLimitExact(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("d%d/file%02d.txt", i%3, i)] = fmt.Sprint(i)
+	}
+	writeTree(t, dir, files)
+	for i := 0; i < 5; i++ {
+		if err := os.Symlink(filepath.Join(dir, "nowhere"), filepath.Join(dir, fmt.Sprintf("d%d", i%3), fmt.Sprintf("broken%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, limit := range []int{1, 2, 7, 20} {
+		opts := testOptions(dir)
+		opts.LogOutput = ioutil.Discard
+		opts.FileLimit = limit
+		if paths := indexedPaths(t, opts); len(paths) != limit {
+			t.Errorf("limit %d got %d records: %v", limit, len(paths), paths)
+		}
+	}
+	// More than there are is everything there is
+	opts := testOptions(dir)
+	opts.LogOutput = ioutil.Discard
+	opts.FileLimit = 100
+	if paths := indexedPaths(t, opts); len(paths) != 20 {
+		t.Errorf("limit 100 got %d records, want all 20", len(paths))
+	}
+}
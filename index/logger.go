@@ -0,0 +1,83 @@
+package index
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// How much gets logged, see Logger
+type LogLevel int
+
+const (
+	// Only errors
+	LogQuiet LogLevel = -1
+	// Errors plus the startup message and summary, what you get if you don't pick anything
+	LogNormal LogLevel = 0
+	// Everything, including a line for every file with how long it took
+	LogVerbose LogLevel = 1
+)
+
+// Writes log lines at a level, anything above the level the Logger was made with is dropped
+// Every line goes out in one write under a lock, so lines from different workers never get mixed together
+// Index logs to stderr by default so none of it ever ends up in -output -
+type Logger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level LogLevel
+}
+
+func NewLogger(w io.Writer, level LogLevel) *Logger {
+	return &Logger{w: w, level: level}
+}
+
+// Changes the level, for when the logger was needed before you knew what the level was going to be
+func (l *Logger) SetLevel(level LogLevel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+// Returns true if lines at level would be written, so you can skip working out something that's only for the log
+func (l *Logger) Enabled(level LogLevel) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return level <= l.level
+}
+
+// Errors are always written, they start with ERROR: so they stand out from everything else
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.logf(LogQuiet, "ERROR: "+format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.logf(LogNormal, format, args...)
+}
+
+func (l *Logger) Verbosef(format string, args ...interface{}) {
+	l.logf(LogVerbose, format, args...)
+}
+
+func (l *Logger) logf(level LogLevel, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level > l.level {
+		return
+	}
+	line := fmt.Sprintf(format, args...)
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+	io.WriteString(l.w, line)
+}
+
+// The logger Index uses, made from Options.LogOutput and Options.LogLevel
+func (opts Options) logger() *Logger {
+	w := opts.LogOutput
+	if w == nil {
+		w = os.Stderr
+	}
+	return NewLogger(w, opts.LogLevel)
+}
@@ -0,0 +1,70 @@
+package index
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Each level gets what's at it and below, errors always come through
+func TestLoggerLevels(t *testing.T) {
+	tests := []struct {
+		level LogLevel
+		want  string
+	}{
+		{LogQuiet, "ERROR: broke 1\n"},
+		{LogNormal, "ERROR: broke 1\ninfo 2\n"},
+		{LogVerbose, "ERROR: broke 1\ninfo 2\nverbose 3\n"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		l := NewLogger(&out, test.level)
+		l.Errorf("broke %d", 1)
+		l.Infof("info %d", 2)
+		// One that already ends in a newline doesn't get another
+		l.Verbosef("verbose %d\n", 3)
+		if out.String() != test.want {
+			t.Errorf("level %d wrote %q, want %q", test.level, out.String(), test.want)
+		}
+		if l.Enabled(LogVerbose) != (test.level == LogVerbose) {
+			t.Errorf("level %d says verbose is enabled is %v", test.level, l.Enabled(LogVerbose))
+		}
+	}
+}
+
+// Verbose logs every file it hashes to the log, never to the index, and quiet logs nothing when nothing goes wrong
+func TestIndexLogLevels(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "b/c.txt": "c"})
+	for _, level := range []LogLevel{LogQuiet, LogNormal, LogVerbose} {
+		opts := testOptions(dir)
+		opts.LogLevel = level
+		// Hitting the limit is worth an Infof
+		opts.FileLimit = 1
+		var log bytes.Buffer
+		opts.LogOutput = &log
+		out, _ := runIndex(t, opts)
+		if strings.Contains(out, "Hashed") || strings.Contains(out, "limit") {
+			t.Errorf("level %d put log lines in the index:\n%s", level, out)
+		}
+
+		hashed := 0
+		for _, line := range strings.Split(strings.TrimRight(log.String(), "\n"), "\n") {
+			if strings.HasPrefix(line, "Hashed ") {
+				hashed++
+			}
+		}
+		if want := map[LogLevel]int{LogVerbose: 1}[level]; hashed != want {
+			t.Errorf("level %d logged %d hashed files, want %d:\n%s", level, hashed, want, log.String())
+		}
+		if limit := strings.Contains(log.String(), "Got 1 files"); limit != (level >= LogNormal) {
+			t.Errorf("level %d logged hitting the limit is %v:\n%s", level, limit, log.String())
+		}
+		if level == LogQuiet && log.Len() != 0 {
+			t.Errorf("quiet logged %q", log.String())
+		}
+		if level == LogVerbose && !strings.Contains(log.String(), dir) {
+			t.Errorf("verbose didn't log which file it hashed:\n%s", log.String())
+		}
+	}
+}
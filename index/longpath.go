@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+// Only Windows has a limit on how long a path can be that a prefix gets you around, everywhere else a path is just a path
+func longPath(osPathname string) string {
+	return osPathname
+}
+
+func trimLongPath(osPathname string) string {
+	return osPathname
+}
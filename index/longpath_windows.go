@@ -0,0 +1,43 @@
+//go:build windows
+// +build windows
+
+package index
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Windows won't open anything whose path is longer than MAX_PATH, 260 characters, unless the path starts with \\?\
+// which tells it to skip its own path parsing and hand the path straight to the filesystem. The os package already does
+// this for absolute paths, but not for relative ones or for calls like GetFileAttributes that don't go through it.
+// Directories only get 248 before they fail, so that's where we start adding it, same as the os package does
+const maxShortPath = 248
+
+// The path to give Windows for osPathname, with \\?\ in front of it if it's long enough to need it
+// A \\?\ path has to be absolute with nothing like . or .. left in it, Abs takes care of both.
+// A share like \\server\share\dir needs \\?\UNC\server\share\dir instead
+func longPath(osPathname string) string {
+	if len(osPathname) < maxShortPath || strings.HasPrefix(osPathname, `\\?\`) {
+		return osPathname
+	}
+	abs, err := filepath.Abs(osPathname)
+	if err != nil {
+		return osPathname
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
+
+// Takes the prefix back off a path that has one, so a walkDir given as \\?\C:\data still gets written down as C:\data
+func trimLongPath(osPathname string) string {
+	switch {
+	case strings.HasPrefix(osPathname, `\\?\UNC\`):
+		return `\\` + osPathname[len(`\\?\UNC\`):]
+	case strings.HasPrefix(osPathname, `\\?\`):
+		return osPathname[len(`\\?\`):]
+	}
+	return osPathname
+}
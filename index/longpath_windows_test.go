@@ -0,0 +1,59 @@
+//go:build windows
+// +build windows
+
+package index
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A file more than 260 characters down gets hashed, and its path is written down without the \\?\ in front
+func TestLongPath(t *testing.T) {
+	dir := t.TempDir()
+	var parts []string
+	for len(filepath.Join(append([]string{dir}, parts...)...)) < 300 {
+		parts = append(parts, strings.Repeat("d", 40))
+	}
+	name := strings.Join(parts, "/") + "/deep.txt"
+	writeTree(t, dir, map[string]string{name: "deep", "short.txt": "short"})
+	long := filepath.Join(dir, filepath.FromSlash(name))
+	if len(long) <= 260 {
+		t.Fatalf("%s isn't long enough", long)
+	}
+
+	byPath := indexByPath(t, testOptions(dir))
+	if rec, ok := byPath[long]; !ok || rec["SHA256 Hash"] != sha256Hex("deep") {
+		t.Errorf("the long path wasn't hashed, got %v", byPath)
+	}
+	for path := range byPath {
+		if strings.HasPrefix(path, `\\?\`) {
+			t.Errorf("%s was written down with the prefix", path)
+		}
+	}
+
+	// Same again starting from a walkDir that already has the prefix
+	byPath = indexByPath(t, testOptions(`\\?\`+dir))
+	if rec, ok := byPath[long]; !ok || rec["SHA256 Hash"] != sha256Hex("deep") {
+		t.Errorf("from a \\\\?\\ walkDir the long path wasn't hashed, got %v", byPath)
+	}
+}
+
+func TestLongPathPrefix(t *testing.T) {
+	long := `C:\` + strings.Repeat(`d\`, 130) + "f.txt"
+	tests := map[string]string{
+		`C:\short.txt`:               `C:\short.txt`,
+		long:                         `\\?\` + long,
+		`\\?\` + long:                `\\?\` + long,
+		`\\server\share\` + long[3:]: `\\?\UNC\server\share\` + long[3:],
+	}
+	for path, want := range tests {
+		if got := longPath(path); got != want {
+			t.Errorf("longPath(%q) is %q, want %q", path, got, want)
+		}
+		if got := trimLongPath(longPath(path)); got != strings.TrimPrefix(path, `\\?\`) {
+			t.Errorf("trimLongPath(longPath(%q)) is %q", path, got)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package index
+
+import "net/http"
+
+// http.DetectContentType never looks past this many bytes
+const sniffLen = 512
+
+// Keeps hold of the start of whatever gets written to it so we can work out what kind of file it is
+// It sits next to the hashers so the file still only gets read once
+type typeSniffer struct {
+	head []byte
+}
+
+func (t *typeSniffer) Write(p []byte) (int, error) {
+	if n := sniffLen - len(t.head); n > 0 {
+		if len(p) < n {
+			n = len(p)
+		}
+		t.head = append(t.head, p[:n]...)
+	}
+	return len(p), nil
+}
+
+// DetectContentType calls an empty file text/plain, which isn't really true of nothing at all
+func (t *typeSniffer) contentType() string {
+	if len(t.head) == 0 {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(t.head)
+}
@@ -0,0 +1,45 @@
+package index
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectType(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"image.png": "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR" + strings.Repeat("\x00", 100),
+		"doc.pdf":   "%PDF-1.4\n%âãÏÓ\n1 0 obj\n",
+		"notes.txt": "just some words\n",
+		"empty":     "",
+	})
+	want := map[string]string{
+		"image.png": "image/png",
+		"doc.pdf":   "application/pdf",
+		"notes.txt": "text/plain; charset=utf-8",
+		"empty":     "application/octet-stream",
+	}
+	// Read in buffers much smaller than what gets sniffed, and out of a mapping, it's the same start of the file either way
+	for _, variant := range []struct {
+		name              string
+		buffer, mmapAbove int
+	}{
+		{"default", 0, 0},
+		{"small buffers", 4, 0},
+		{"mapped", 0, 1},
+	} {
+		opts := testOptions(dir)
+		opts.DetectType = true
+		if variant.buffer > 0 {
+			opts.BufferSize = variant.buffer
+		}
+		opts.MmapThreshold = int64(variant.mmapAbove)
+		byPath := indexByPath(t, opts)
+		for name, mime := range want {
+			if got := byPath[filepath.Join(dir, name)]["Mime"]; got != mime {
+				t.Errorf("%s: %s got %q, want %q", variant.name, name, got, mime)
+			}
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package index
+
+import (
+	"errors"
+	"os"
+)
+
+// Anywhere else files are always streamed, submit falls back to that when mapping fails
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("memory mapping files isn't supported here")
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,72 @@
+package index
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// How big a file BenchmarkMmap hashes, in bytes. It's 256MB by default and 16MB with -short,
+// set GOINDEX_MMAP_BENCH_BYTES=4294967296 or so to see how it goes on a multi-GB file
+func mmapBenchSize(b *testing.B) int64 {
+	if env := os.Getenv("GOINDEX_MMAP_BENCH_BYTES"); env != "" {
+		size, err := strconv.ParseInt(env, 10, 64)
+		if err != nil || size <= 0 {
+			b.Fatalf("GOINDEX_MMAP_BENCH_BYTES=%q isn't a number of bytes", env)
+		}
+		return size
+	}
+	if testing.Short() {
+		return 16 << 20
+	}
+	return 256 << 20
+}
+
+// One big file hashed by streaming it a buffer at a time and out of a memory mapping. It's read once first
+// so both come out of the page cache, the mapping saves the copy into the buffer and it can't help a slow disk
+func BenchmarkMmap(b *testing.B) {
+	size := mmapBenchSize(b)
+	dir := b.TempDir()
+	f, err := os.Create(filepath.Join(dir, "big.bin"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	chunk := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(chunk)
+	for written := int64(0); written < size; written += int64(len(chunk)) {
+		if left := size - written; left < int64(len(chunk)) {
+			chunk = chunk[:left]
+		}
+		if _, err := f.Write(chunk); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		b.Fatal(err)
+	}
+	opts := testOptions(dir)
+	opts.LogOutput = ioutil.Discard
+	runIndex(b, opts)
+
+	for _, mode := range []struct {
+		name      string
+		threshold int64
+	}{
+		{"stream", 0},
+		{"mmap", 1},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			opts := testOptions(dir)
+			opts.MmapThreshold = mode.threshold
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if _, stats := runIndex(b, opts); stats.Files != 1 {
+					b.Fatalf("hashed %d files, want the one", stats.Files)
+				}
+			}
+		})
+	}
+}
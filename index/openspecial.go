@@ -0,0 +1,52 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// Opening a FIFO blocks until something opens the other end. Non-blocking would come straight back, but then the first read
+// is an EOF if nobody has opened it for writing yet, and we'd write down the hash of nothing for a FIFO that was about to get data.
+// So the open blocks on a goroutine of its own and we wait for it for as long as we'd wait for the data, then the file is
+// pollable like any FIFO Go opens and the read deadline works. Anything else special is opened non-blocking so a device can't hang the open
+func openSpecial(osPathname string) (*os.File, error) {
+	info, err := os.Stat(osPathname)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return os.OpenFile(osPathname, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	}
+
+	type result struct {
+		f   *os.File
+		err error
+	}
+	opened := make(chan result, 1)
+	go func() {
+		f, err := os.OpenFile(osPathname, os.O_RDONLY, 0)
+		opened <- result{f, err}
+	}()
+	timer := time.NewTimer(specialReadTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-opened:
+		return r.f, r.err
+	case <-timer.C:
+	}
+	// Nobody came, so we open the other end ourselves to let the goroutine's open return instead of leaving it stuck forever
+	// Whatever it opened gets closed straight away, it's an EOF with nothing in it
+	if w, err := os.OpenFile(osPathname, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+		w.Close()
+	}
+	go func() {
+		if r := <-opened; r.f != nil {
+			r.f.Close()
+		}
+	}()
+	return nil, errSpecialTimeout
+}
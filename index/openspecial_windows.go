@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package index
+
+import "os"
+
+// Windows doesn't have FIFOs you can find on disk, about all you'll come across is a reparse point we couldn't follow,
+// so there's nothing special about opening one
+func openSpecial(osPathname string) (*os.File, error) {
+	return os.Open(osPathname)
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// On Unix the owner and group are right there in the stat we already did
+func fileOwner(info os.FileInfo) (string, string) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", ""
+	}
+	return userNames.name(strconv.FormatUint(uint64(st.Uid), 10)), groupNames.name(strconv.FormatUint(uint64(st.Gid), 10))
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// A file we've just made belongs to us, by name if there is one for our uid
+func TestPermissionsOwner(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"mine.txt": "mine"})
+	if err := os.Chmod(filepath.Join(dir, "mine.txt"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions(dir)
+	opts.Permissions = true
+
+	rec := indexByPath(t, opts)[filepath.Join(dir, "mine.txt")]
+	uid := strconv.Itoa(os.Getuid())
+	want := uid
+	if u, err := user.LookupId(uid); err == nil {
+		want = u.Username
+	}
+	if rec["Owner"] != want {
+		t.Errorf("got owner %q, want %q for uid %s", rec["Owner"], want, uid)
+	}
+	if rec["Group"] == "" {
+		t.Errorf("no group in %v", rec)
+	}
+	if rec["Mode"] != "-rw-r-----" {
+		t.Errorf("got mode %q, want -rw-r-----", rec["Mode"])
+	}
+}
@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package index
+
+import "os"
+
+// Windows keeps the owner in the file's security descriptor rather than anything os.Stat gives us,
+// and reading that for every file is slow and needs rights you often don't have, so the owner and group are left empty
+// The mode still gets written, it only ever has the read only bit in it on Windows
+func fileOwner(info os.FileInfo) (string, string) {
+	return "", ""
+}
@@ -0,0 +1,158 @@
+package index
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/karrick/godirwalk"
+)
+
+// Walks root like godirwalk.Walk with Unsorted and AllowNonDirectory set, except that up to workers directories are read at the same time
+// On a big tree most of the walk is waiting on the disk to read one directory after another, with a few going at once
+// there's always something to do while we wait. Callback and ErrorCallback work just like they do for godirwalk,
+// a directory is always given to Callback before anything in it, but they get called from every worker at once so they have to be safe for that
+func parallelWalk(root string, workers int, followSymlinks bool, callback godirwalk.WalkFunc, errorCallback func(string, error) godirwalk.ErrorAction) error {
+	de, err := godirwalk.NewDirent(root)
+	if err != nil {
+		if errorCallback(root, err) == godirwalk.Halt {
+			return err
+		}
+		return nil
+	}
+
+	q := newDirQueue()
+	// The root is always walked into if it's a directory, even a symlink to one, same as godirwalk
+	if q.visit(root, de, callback, errorCallback) {
+		if isDir, _ := de.IsDirOrSymlinkToDir(); isDir {
+			q.push([]string{root})
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			// Every worker gets its own buffer for reading directories, same as godirwalk does for its one
+			scratch := make([]byte, godirwalk.MinimumScratchBufferSize)
+			for {
+				dir, ok := q.pop()
+				if !ok {
+					return
+				}
+				q.done(q.readDir(dir, scratch, followSymlinks, callback, errorCallback))
+			}
+		}()
+	}
+	wg.Wait()
+	return q.err
+}
+
+// The directories waiting to be read, shared by every worker in parallelWalk
+// It grows as much as it needs to, so a worker handing back what it found never has to wait on one that's waiting for work
+type dirQueue struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	dirs []string
+	// How many directories are being read right now, once it's 0 and nothing is waiting the walk is done
+	active int
+	// Set when a callback asked us to Halt, that's the error the walk returns
+	halted bool
+	err    error
+}
+
+func newDirQueue() *dirQueue {
+	q := &dirQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *dirQueue) push(dirs []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dirs = append(q.dirs, dirs...)
+	q.cond.Broadcast()
+}
+
+// Waits for a directory to read, ok is false once there's nothing left and nobody is going to find any more
+func (q *dirQueue) pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.dirs) == 0 && q.active > 0 && !q.halted {
+		q.cond.Wait()
+	}
+	if q.halted || len(q.dirs) == 0 {
+		return "", false
+	}
+	// Last in first out keeps the walk going deep before it goes wide, so the queue stays small
+	dir := q.dirs[len(q.dirs)-1]
+	q.dirs = q.dirs[:len(q.dirs)-1]
+	q.active++
+	return dir, true
+}
+
+// Hands back whatever a worker found inside the directory it just read
+func (q *dirQueue) done(found []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.active--
+	q.dirs = append(q.dirs, found...)
+	q.cond.Broadcast()
+}
+
+func (q *dirQueue) halt(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.halted {
+		q.halted, q.err = true, err
+	}
+	q.cond.Broadcast()
+}
+
+func (q *dirQueue) isHalted() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.halted
+}
+
+// Calls callback for one entry and deals with what it returns, true means carry on into it if it's a directory
+func (q *dirQueue) visit(osPathname string, de *godirwalk.Dirent, callback godirwalk.WalkFunc, errorCallback func(string, error) godirwalk.ErrorAction) bool {
+	err := callback(osPathname, de)
+	switch {
+	case err == nil:
+		return true
+	case err == godirwalk.SkipThis:
+		return false
+	}
+	if errorCallback(osPathname, err) == godirwalk.Halt {
+		q.halt(err)
+	}
+	return false
+}
+
+// Reads one directory, gives everything in it to callback and returns the directories in it that need reading next
+func (q *dirQueue) readDir(dir string, scratch []byte, followSymlinks bool, callback godirwalk.WalkFunc, errorCallback func(string, error) godirwalk.ErrorAction) []string {
+	children, err := godirwalk.ReadDirents(dir, scratch)
+	if err != nil {
+		if errorCallback(dir, err) == godirwalk.Halt {
+			q.halt(err)
+		}
+		return nil
+	}
+
+	var found []string
+	for _, de := range children {
+		if q.isHalted() {
+			return nil
+		}
+		osPathname := filepath.Join(dir, de.Name())
+		isDir := de.IsDir()
+		if followSymlinks && de.IsSymlink() {
+			isDir, _ = de.IsDirOrSymlinkToDir()
+		}
+		if q.visit(osPathname, de, callback, errorCallback) && isDir {
+			found = append(found, osPathname)
+		}
+	}
+	return found
+}
@@ -0,0 +1,124 @@
+package index
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/karrick/godirwalk"
+)
+
+// A tree fanout wide and depth deep with files in every directory, what a huge tree looks like to the walk
+func deepTree(t testing.TB, fanout, depth, files int) string {
+	t.Helper()
+	dir := t.TempDir()
+	tree := make(map[string]string)
+	var fill func(prefix string, level int)
+	fill = func(prefix string, level int) {
+		for i := 0; i < files; i++ {
+			tree[fmt.Sprintf("%sf%d", prefix, i)] = ""
+		}
+		if level == depth {
+			return
+		}
+		for i := 0; i < fanout; i++ {
+			fill(fmt.Sprintf("%sd%d/", prefix, i), level+1)
+		}
+	}
+	fill("", 0)
+	writeTree(t, dir, tree)
+	return dir
+}
+
+// Every path the walk was given, sorted
+func walkPaths(t *testing.T, walk func(godirwalk.WalkFunc) error) []string {
+	t.Helper()
+	var mu sync.Mutex
+	var paths []string
+	err := walk(func(osPathname string, de *godirwalk.Dirent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, osPathname)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func noWalkErrors(string, error) godirwalk.ErrorAction {
+	return godirwalk.SkipNode
+}
+
+// However many workers there are a parallel walk sees exactly what godirwalk does, and SkipDir leaves out everything under it
+func TestParallelWalk(t *testing.T) {
+	dir := deepTree(t, 3, 4, 2)
+	want := walkPaths(t, func(callback godirwalk.WalkFunc) error {
+		return godirwalk.Walk(dir, &godirwalk.Options{Callback: callback, ErrorCallback: noWalkErrors, Unsorted: true, AllowNonDirectory: true})
+	})
+	for _, workers := range []int{1, 2, 8} {
+		got := walkPaths(t, func(callback godirwalk.WalkFunc) error {
+			return parallelWalk(dir, workers, false, callback, noWalkErrors)
+		})
+		if !equalStrings(got, want) {
+			t.Errorf("%d workers walked %d paths, godirwalk walked %d", workers, len(got), len(want))
+		}
+	}
+
+	skipped := filepath.Join(dir, "d1")
+	got := walkPaths(t, func(callback godirwalk.WalkFunc) error {
+		return parallelWalk(dir, 4, false, func(osPathname string, de *godirwalk.Dirent) error {
+			if osPathname == skipped {
+				return godirwalk.SkipThis
+			}
+			return callback(osPathname, de)
+		}, noWalkErrors)
+	})
+	for _, path := range got {
+		if rel, _ := filepath.Rel(skipped, path); filepath.IsLocal(rel) {
+			t.Errorf("walked %s under a skipped directory", path)
+		}
+	}
+	if len(got) == 0 || len(got) >= len(want) {
+		t.Errorf("got %d paths skipping d1 out of %d", len(got), len(want))
+	}
+}
+
+// Just the enumeration on a deep tree, godirwalk on its own against parallelWalk with more and more workers
+// Most of this is the kernel reading directories out of the cache, on a cold cache or a network mount the gap is far bigger
+func BenchmarkWalk(b *testing.B) {
+	dir := deepTree(b, 4, 5, 4)
+	count := func(osPathname string, de *godirwalk.Dirent) error { return nil }
+	b.Run("godirwalk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			godirwalk.Walk(dir, &godirwalk.Options{Callback: count, ErrorCallback: noWalkErrors, Unsorted: true, AllowNonDirectory: true})
+		}
+	})
+	for _, workers := range []int{2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("parallel-%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				parallelWalk(dir, workers, false, count, noWalkErrors)
+			}
+		})
+	}
+}
+
+// The whole of a -count run, so the walk is the only thing there is to be slow
+func BenchmarkWalkWorkers(b *testing.B) {
+	dir := deepTree(b, 4, 5, 4)
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			opts := testOptions(dir)
+			opts.DryRun = true
+			opts.CountOnly = true
+			opts.WalkWorkers = workers
+			for i := 0; i < b.N; i++ {
+				runIndex(b, opts)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package index
+
+import (
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// How many rows are held in memory before they're written out as a row group
+// Readers like DuckDB and Spark split the work up by row group, so a huge index still gets read in parallel
+const parquetRowGroupRows = 100000
+
+// Writes records as an Apache Parquet file with typed columns, so DuckDB, Spark or pandas can load it without parsing a CSV
+// The columns get the same names as the sqlite table, path, hash, size and mod_time, with mod_time a proper timestamp.
+// parquet-go puts the columns of a schema in name order, which doesn't matter to anything that reads them by name.
+// Rows are collected into row groups and written a group at a time, the footer describing all of them goes on when the writer is closed.
+// That happens in Flush, which always gets called even when the run is cancelled, so you still get a file you can open
+type parquetWriter struct {
+	w       *parquet.Writer
+	columns optionalColumns
+	// hash and then one for every algorithm if there's more than one, see tableHashColumns
+	hashColumns []string
+	// Where each of our values goes in a row, the schema's order isn't the order we work them out in
+	index map[string]int
+	row   parquet.Row
+}
+
+func newParquetWriter(w io.Writer, hashNames []string, columns optionalColumns) *parquetWriter {
+	fields := parquet.Group{
+		"path":     parquet.String(),
+		"size":     parquet.Int(64),
+		"mod_time": parquet.Timestamp(parquet.Microsecond),
+	}
+	for _, name := range tableHashColumns(hashNames) {
+		fields[name] = parquet.String()
+	}
+	for _, name := range tableOptionalColumns(columns) {
+		switch name {
+		case "chunk_offset":
+			fields[name] = parquet.Int(64)
+		case "hash_ms":
+			fields[name] = parquet.Leaf(parquet.DoubleType)
+		default:
+			fields[name] = parquet.String()
+		}
+	}
+	// Snappy is what every reader expects by default and it costs next to nothing, hashes don't compress but paths do
+	schema := parquet.NewSchema("files", fields)
+	p := &parquetWriter{
+		w:           parquet.NewWriter(w, schema, parquet.Compression(&parquet.Snappy), parquet.MaxRowsPerRowGroup(parquetRowGroupRows)),
+		columns:     columns,
+		hashColumns: tableHashColumns(hashNames),
+		index:       make(map[string]int),
+	}
+	for i, path := range schema.Columns() {
+		p.index[path[0]] = i
+	}
+	p.row = make(parquet.Row, len(p.index))
+	return p
+}
+
+// The schema is in the footer, so there's nothing to write up front
+func (p *parquetWriter) WriteHeader() error {
+	return nil
+}
+
+func (p *parquetWriter) set(column string, v parquet.Value) {
+	i := p.index[column]
+	p.row[i] = v.Level(0, 0, i)
+}
+
+func (p *parquetWriter) WriteRecord(r record) error {
+	p.set("path", parquet.ByteArrayValue([]byte(r.Path)))
+	p.set(p.hashColumns[0], parquet.ByteArrayValue([]byte(r.Hashes[0])))
+	for i, name := range p.hashColumns[1:] {
+		p.set(name, parquet.ByteArrayValue([]byte(r.Hashes[i])))
+	}
+	p.set("size", parquet.Int64Value(r.Size))
+	// Microseconds are as fine as most readers go, worked out this way so it doesn't overflow for times a long way off
+	p.set("mod_time", parquet.Int64Value(r.ModTime.Unix()*1e6+int64(r.ModTime.Nanosecond()/1e3)))
+	names := tableOptionalColumns(p.columns)
+	for i, v := range p.columns.values(r) {
+		switch names[i] {
+		case "chunk_offset":
+			p.set(names[i], parquet.Int64Value(r.Offset))
+		case "hash_ms":
+			// The columns can't be null, so a directory or a symlink that wasn't read gets 0
+			p.set(names[i], parquet.DoubleValue(hashMillis(r.HashTime)))
+		default:
+			p.set(names[i], parquet.ByteArrayValue([]byte(v)))
+		}
+	}
+	// The writer copies the values, so the same row can be filled in again for the next record
+	_, err := p.w.WriteRows([]parquet.Row{p.row})
+	return err
+}
+
+// Writes the last row group and the footer
+func (p *parquetWriter) Flush() error {
+	return p.w.Close()
+}
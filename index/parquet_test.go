@@ -0,0 +1,104 @@
+package index
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+type parquetRow struct {
+	Path    string    `parquet:"path"`
+	Hash    string    `parquet:"hash"`
+	Size    int64     `parquet:"size"`
+	ModTime time.Time `parquet:"mod_time,timestamp(microsecond)"`
+	Mime    string    `parquet:"mime"`
+}
+
+func TestParquetReadBack(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "hello", "b/c.txt": "goodbye, world"})
+	modTime := time.Date(2021, 5, 1, 10, 30, 0, 123456000, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "a.txt"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions(dir)
+	opts.Format = "parquet"
+	opts.DetectType = true
+
+	out, _ := runIndex(t, opts)
+	file, err := parquet.OpenFile(bytes.NewReader([]byte(out)), int64(len(out)))
+	if err != nil {
+		t.Fatalf("opening the output: %s", err)
+	}
+	if file.NumRows() != 2 {
+		t.Errorf("got %d rows, want 2", file.NumRows())
+	}
+
+	// Every column is there with the type a reader would want for it
+	want := map[string]string{"path": "BYTE_ARRAY", "hash": "BYTE_ARRAY", "size": "INT64", "mod_time": "INT64", "mime": "BYTE_ARRAY"}
+	got := make(map[string]string)
+	for _, field := range file.Schema().Fields() {
+		got[field.Name()] = field.Type().Kind().String()
+	}
+	for name, kind := range want {
+		if got[name] != kind {
+			t.Errorf("column %s is %q, want %s", name, got[name], kind)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got columns %v, want %v", got, want)
+	}
+	var timestamp bool
+	for _, field := range file.Schema().Fields() {
+		if field.Name() == "mod_time" {
+			timestamp = strings.HasPrefix(field.Type().String(), "TIMESTAMP")
+		}
+	}
+	if !timestamp {
+		t.Errorf("mod_time isn't a timestamp, it's %s", got["mod_time"])
+	}
+
+	rows, err := parquet.Read[parquetRow](bytes.NewReader([]byte(out)), int64(len(out)))
+	if err != nil {
+		t.Fatalf("reading the rows: %s", err)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Path < rows[j].Path })
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+	a := rows[0]
+	if a.Path != filepath.Join(dir, "a.txt") || a.Hash != sha256Hex("hello") || a.Size != 5 || !a.ModTime.Equal(modTime) || a.Mime != "text/plain; charset=utf-8" {
+		t.Errorf("got %+v for a.txt", a)
+	}
+	if c := rows[1]; c.Path != filepath.Join(dir, "b", "c.txt") || c.Hash != sha256Hex("goodbye, world") || c.Size != 14 {
+		t.Errorf("got %+v for b/c.txt", c)
+	}
+}
+
+// With more than one algorithm every one of them gets its own column as well
+func TestParquetSeveralHashes(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "hello"})
+	opts := testOptions(dir)
+	opts.Format = "parquet"
+	opts.Hashes = []string{"sha256", "md5"}
+
+	out, _ := runIndex(t, opts)
+	rows, err := parquet.Read[struct {
+		Hash   string `parquet:"hash"`
+		SHA256 string `parquet:"sha256_hash"`
+		MD5    string `parquet:"md5_hash"`
+	}](bytes.NewReader([]byte(out)), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Hash != sha256Hex("hello") || rows[0].SHA256 != sha256Hex("hello") || rows[0].MD5 != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("got %+v", rows)
+	}
+}
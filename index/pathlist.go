@@ -0,0 +1,45 @@
+package index
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+// What a directory in Options.PathList goes in the error log with, a list is for files and we don't walk anything
+var errListedDir = errors.New("is a directory, only files can be hashed from a path list")
+
+// Reads every path out of r and hands each one to found, paths end at sep
+// A newline separated list can have Windows line endings, so a \r on the end of a path gets dropped too. Empty paths are skipped
+func readPathList(ctx context.Context, r io.Reader, sep byte, found func(osPathname string)) error {
+	br := bufio.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		path, err := br.ReadString(sep)
+		path = strings.TrimSuffix(path, string(sep))
+		if sep == '\n' {
+			path = strings.TrimSuffix(path, "\r")
+		}
+		if path != "" {
+			found(path)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// What Options.PathList is split on
+func (opts Options) pathListSeparator() byte {
+	if opts.PathListNul {
+		return 0
+	}
+	return '\n'
+}
@@ -0,0 +1,56 @@
+package index
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Exactly what's on the list gets hashed, fed through a pipe like find -print0 would, and what isn't a file goes in the error log
+func TestPathList(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "sub/b c.txt": "b", "unlisted.txt": "unlisted", "skip.log": "log"})
+	listed := []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "sub", "b c.txt"), filepath.Join(dir, "missing.txt"), filepath.Join(dir, "sub"), filepath.Join(dir, "skip.log")}
+
+	for _, nul := range []bool{true, false} {
+		r, w := io.Pipe()
+		go func() {
+			for _, path := range listed {
+				if nul {
+					io.WriteString(w, path+"\x00")
+				} else {
+					// Windows line endings come off too
+					io.WriteString(w, path+"\r\n")
+				}
+			}
+			w.Close()
+		}()
+		opts := testOptions(dir)
+		opts.LogOutput = ioutil.Discard
+		opts.PathList, opts.PathListNul = r, nul
+		// None of the filters apply to a list
+		opts.Exclude = []string{"*.log"}
+		var errLog bytes.Buffer
+		opts.ErrorLog = &errLog
+
+		out, stats := runIndex(t, opts)
+		byPath := make(map[string]map[string]string)
+		for _, rec := range parseCSV(t, out) {
+			byPath[rec["Path"]] = rec
+		}
+		for path, content := range map[string]string{listed[0]: "a", listed[1]: "b", listed[4]: "log"} {
+			if byPath[path]["SHA256 Hash"] != sha256Hex(content) {
+				t.Errorf("nul %v: %s is missing or wrong, got %v", nul, path, byPath[path])
+			}
+		}
+		if len(byPath) != 3 {
+			t.Errorf("nul %v: got %d records, want 3: %v", nul, len(byPath), byPath)
+		}
+		if stats.Errors != 2 || !strings.Contains(errLog.String(), "missing.txt") || !strings.Contains(errLog.String(), errListedDir.Error()) {
+			t.Errorf("nul %v: got %d errors, want 2 for the missing file and the directory:\n%s", nul, stats.Errors, errLog.String())
+		}
+	}
+}
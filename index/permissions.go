@@ -0,0 +1,59 @@
+package index
+
+import (
+	"os"
+	"os/user"
+	"sync"
+)
+
+// Fills in the Owner, Group and Mode of a record for Options.Permissions
+// The mode works the same everywhere, where the owner comes from depends on the platform, see fileOwner
+func fillPermissions(rec *record, info os.FileInfo) {
+	rec.Owner, rec.Group = fileOwner(info)
+	rec.Mode = info.Mode().String()
+}
+
+// Looking up a name means reading /etc/passwd or asking the directory service, and most trees only have a handful of owners,
+// so every id only gets looked up once. The workers all share these so they need a lock
+type nameCache struct {
+	mu     sync.Mutex
+	names  map[string]string
+	lookup func(id string) (string, error)
+}
+
+var (
+	userNames  = &nameCache{names: make(map[string]string), lookup: lookupUser}
+	groupNames = &nameCache{names: make(map[string]string), lookup: lookupGroup}
+)
+
+func lookupUser(id string) (string, error) {
+	u, err := user.LookupId(id)
+	if err != nil {
+		return "", err
+	}
+	return u.Username, nil
+}
+
+func lookupGroup(id string) (string, error) {
+	g, err := user.LookupGroupId(id)
+	if err != nil {
+		return "", err
+	}
+	return g.Name, nil
+}
+
+// Returns the name for an id, or the id itself if it doesn't have one
+// That happens a lot with files off another machine or out of a container, the number is still better than nothing
+func (c *nameCache) name(id string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name, ok := c.names[id]; ok {
+		return name
+	}
+	name, err := c.lookup(id)
+	if err != nil {
+		name = id
+	}
+	c.names[id] = name
+	return name
+}
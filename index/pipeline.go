@@ -0,0 +1,335 @@
+package index
+
+import (
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// What a file gets put in the error log with when it was written to while we were reading it
+// The record still gets written, but its hash is of something in between the old and new contents
+var errChangedWhileHashing = errors.New("file changed while it was being hashed, the hash may not match the size and modified time")
+
+// What a file gets put in the error log with when it got shorter while we were hashing it out of a memory mapping
+var errCutShortWhileMapped = errors.New("file got shorter while it was being hashed from a memory mapping")
+
+// How many chunks a reader can get ahead of the hasher working on its file
+// Every hash worker has at most this many chunks waiting, plus the one it's hashing and the one being read,
+// so the memory used for file contents is bounded by HashWorkers * (readAhead + 2) * BufferSize no matter how big the files are
+const readAhead = 4
+
+// One file on its way from the read stage to the hash stage
+type hashJob struct {
+	osPathname string
+	rec        record
+	// The reader closes this once the whole file has been sent, or it gave up on it
+	// Every chunk is a buffer from the pool, whoever takes one out of here has to put it back
+	chunks chan *[]byte
+	// Only safe to look at once chunks has been closed
+	err error
+	// The reader is going to try this file again, so whatever was hashed gets thrown away
+	retry bool
+	// The file changed while it was being read and we aren't going to try again
+	changed bool
+	// Only set when the one chunk coming is a memory mapping of the file rather than a buffer from the pool,
+	// the hasher sends back whether it got through all of it and the reader can't unmap it until then
+	mapped chan error
+}
+
+// The CPU side of the pipeline, a fixed number of goroutines that hash whatever the readers hand them
+// Jobs are handed over on an unbuffered channel so a reader only starts pulling a file into memory once a hasher is free to take it
+type hashStage struct {
+	jobs chan *hashJob
+	wg   sync.WaitGroup
+	// Buffers get passed from the readers to the hashers and back again instead of every read allocating a new one,
+	// there's pointers in here rather than slices so putting one back doesn't allocate either
+	buffers sync.Pool
+	// How long a file gets to be read, 0 means forever
+	fileTimeout time.Duration
+	// Files at least this big are hashed out of a memory mapping instead of being read, 0 means never
+	mmapThreshold int64
+	// Shared by every reader, nil means they read as fast as they can
+	limiter *rateLimiter
+	// Only this many bytes from the start of every file get hashed, 0 means all of it
+	headBytes int64
+	// Drops buffers instead of putting them back, so every read gets a new one. Only BenchmarkBufferPool sets it, to show what the pool saves
+	unpooled bool
+}
+
+// Starts the hash workers, every file they're given ends up in done exactly once
+// If err is set and the record wasn't hashed the file failed, if it was it should still be written
+// With detectType set the start of every file is kept as it goes past so the record gets a Mime as well
+// With chunkSize above 0 the file is cut into chunks averaging that many bytes and the record gets Chunks instead of Hashes
+// With fileTimeout above 0 a file that hasn't been read in that long is given up on and reported as failed
+// With mmapThreshold above 0 any file at least that big is mapped and handed to the hasher in one go, see submitMapped
+// With rateLimit above 0 the readers between them read no more than that many bytes a second
+// With headBytes above 0 only that many bytes from the start of every file are read and hashed
+// elapsed is how long it took from a hasher taking the file to it being done, reading and hashing together
+func newHashStage(workers int, bufferSize int, hashNames []string, encoding string, detectType bool, chunkSize int, fileTimeout time.Duration, mmapThreshold int64, rateLimit int64, headBytes int64, done func(osPathname string, rec record, elapsed time.Duration, err error)) *hashStage {
+	hs := &hashStage{jobs: make(chan *hashJob), fileTimeout: fileTimeout, mmapThreshold: mmapThreshold, limiter: newRateLimiter(rateLimit), headBytes: headBytes}
+	hs.buffers.New = func() interface{} {
+		buf := make([]byte, bufferSize)
+		return &buf
+	}
+	hs.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer hs.wg.Done()
+			for job := range hs.jobs {
+				start := time.Now()
+				// Chunking hashes every chunk on its own, there's no hash of the whole file
+				var h io.Writer
+				var chunks *chunker
+				var whole *multiHasher
+				if chunkSize > 0 {
+					chunks = newChunker(chunkSize, hashNames, encoding)
+					h = chunks
+				} else {
+					whole = newMultiHasher(hashNames, encoding)
+					h = whole
+				}
+				sums := func(rec *record) {
+					if chunks != nil {
+						rec.Chunks = chunks.finish()
+					} else {
+						rec.Hashes = whole.sums()
+					}
+				}
+				sniffer := &typeSniffer{}
+				// Keep draining even if the reader hit an error, every chunk in here needs to go back in the pool
+				for chunk := range job.chunks {
+					if job.mapped != nil {
+						w := h
+						if detectType {
+							w = io.MultiWriter(h, sniffer)
+						}
+						job.mapped <- hashMapped(w, *chunk)
+						continue
+					}
+					h.Write(*chunk)
+					if detectType {
+						sniffer.Write(*chunk)
+					}
+					hs.putBuffer(chunk)
+				}
+				if detectType {
+					job.rec.Mime = sniffer.contentType()
+				}
+				switch {
+				case job.retry:
+				case job.err != nil:
+					done(job.osPathname, job.rec, time.Since(start), job.err)
+				case job.changed:
+					sums(&job.rec)
+					done(job.osPathname, job.rec, time.Since(start), errChangedWhileHashing)
+				default:
+					sums(&job.rec)
+					done(job.osPathname, job.rec, time.Since(start), nil)
+				}
+			}
+		}()
+	}
+	return hs
+}
+
+// The read side, hands f over to a hash worker and then streams it across in chunks
+// This blocks until a hash worker is free, and then for as long as it takes to read the file
+// rec has to have the size and modified time from before we started reading, if they're different afterwards the file
+// changed under us. With retry set we have one more go at it, otherwise the record goes out flagged as changed
+// With canRetry set a read error that might go away next time is handed back to the caller instead of being reported,
+// it's up to them to open the file again and have another go. Any other time this returns nil
+func (hs *hashStage) submit(osPathname string, f *os.File, rec record, retry bool, canRetry bool) error {
+	// Anything that won't map, like a file on a filesystem that can't or one too big for a 32 bit address space, is just read instead
+	if hs.mmapThreshold > 0 && rec.Size >= hs.mmapThreshold {
+		if data, err := mmapFile(f, rec.Size); err == nil {
+			done, err := hs.submitMapped(osPathname, f, data, &rec, retry, canRetry)
+			if done {
+				return err
+			}
+			// It changed while it was mapped, the second go reads it like any other file
+			retry = false
+		}
+	}
+
+	var r io.Reader = f
+	for {
+		job := &hashJob{osPathname: osPathname, rec: rec, chunks: make(chan *[]byte, readAhead)}
+		hs.jobs <- job
+		// The clock only starts once a hasher has the file, waiting for one to be free doesn't count against it
+		// A second go after the file changed is still on the same clock
+		if hs.fileTimeout > 0 && r == io.Reader(f) {
+			r = newTimeoutReader(f, hs.fileTimeout)
+		}
+		read := hs.stream(hs.limiter.reader(hs.head(r)), job)
+		if job.err != nil {
+			return hs.finishFailed(job, canRetry)
+		}
+
+		// Something that's being written to will have a different size or time by now, or we read a different amount than it said it had
+		info, err := f.Stat()
+		if err != nil {
+			job.err = err
+			return hs.finishFailed(job, canRetry)
+		}
+		if read == hs.headSize(rec.Size) && info.Size() == rec.Size && info.ModTime().Equal(rec.ModTime) {
+			close(job.chunks)
+			return nil
+		}
+		if !retry {
+			job.changed = true
+			close(job.chunks)
+			return nil
+		}
+
+		// Start over from the top with what the file looks like now
+		job.retry = true
+		close(job.chunks)
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			hs.fail(osPathname, rec, err)
+			return nil
+		}
+		rec.Size, rec.ModTime = info.Size(), info.ModTime()
+		retry = false
+	}
+}
+
+// Hashes f out of data, a mapping of the whole file, instead of reading it a buffer at a time
+// There's no read for every buffer full and no copying, the hasher reads the page cache directly, which is what makes it
+// quicker on a big file that's already cached. It's no help at all to a slow disk, that's still the slow part.
+// The file is checked afterwards the same as submit does, done is false if it changed and retry says to try again,
+// rec has what it looks like now in that case and the caller streams it. Options.FileTimeout doesn't apply, there are no reads to time
+func (hs *hashStage) submitMapped(osPathname string, f *os.File, data []byte, rec *record, retry bool, canRetry bool) (bool, error) {
+	defer munmap(data)
+	job := &hashJob{osPathname: osPathname, rec: *rec, chunks: make(chan *[]byte, 1), mapped: make(chan error)}
+	hs.jobs <- job
+	// The whole file still gets unmapped, the hasher only gets its head
+	hashed := data[:hs.headSize(int64(len(data)))]
+	job.chunks <- &hashed
+	if err := <-job.mapped; err != nil {
+		job.err = err
+		return true, hs.finishFailed(job, canRetry)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		job.err = err
+		return true, hs.finishFailed(job, canRetry)
+	}
+	if info.Size() == rec.Size && info.ModTime().Equal(rec.ModTime) {
+		close(job.chunks)
+		return true, nil
+	}
+	if !retry {
+		job.changed = true
+		close(job.chunks)
+		return true, nil
+	}
+	job.retry = true
+	close(job.chunks)
+	rec.Size, rec.ModTime = info.Size(), info.ModTime()
+	return false, nil
+}
+
+// Touching a page of a mapping past the end of a file that got shorter after we mapped it is a SIGBUS, which would
+// normally take the whole program down. With panic on fault set it's a panic in this goroutine instead, and that we can recover
+func hashMapped(w io.Writer, data []byte) (err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			if _, fault := r.(runtime.Error); !fault {
+				panic(r)
+			}
+			err = errCutShortWhileMapped
+		}
+	}()
+	w.Write(data)
+	return nil
+}
+
+// Closes off a job whose read failed, either reporting the error or throwing it away for the caller to try again
+func (hs *hashStage) finishFailed(job *hashJob, canRetry bool) error {
+	if canRetry && isTransient(job.err) {
+		job.retry = true
+		close(job.chunks)
+		return job.err
+	}
+	close(job.chunks)
+	return nil
+}
+
+// Hashes a special file for Options.IncludeSpecial, there's no size or time to check a FIFO or device against
+// so it only gets read the once and the record's size is however many bytes came out of it, up to Options.HeadBytes if that's set
+func (hs *hashStage) submitSpecial(osPathname string, r io.Reader, rec record) {
+	job := &hashJob{osPathname: osPathname, rec: rec, chunks: make(chan *[]byte, readAhead)}
+	hs.jobs <- job
+	// The hasher doesn't look at the record until the channel is closed, so it's fine to change it until then
+	job.rec.Size = hs.stream(hs.limiter.reader(hs.head(r)), job)
+	close(job.chunks)
+}
+
+// Sends the rest of f to the job's hasher, returns how many bytes that was
+// Any read error ends up in job.err, closing the channel is left to the caller
+func (hs *hashStage) stream(f io.Reader, job *hashJob) int64 {
+	var read int64
+	for {
+		buf := hs.buffers.Get().(*[]byte)
+		n, err := io.ReadFull(f, *buf)
+		read += int64(n)
+		if n > 0 {
+			// The hasher only looks at what we read, the buffer goes back to full size when it's returned
+			*buf = (*buf)[:n]
+			job.chunks <- buf
+		} else {
+			hs.putBuffer(buf)
+		}
+		// ReadFull only says EOF if it got nothing at all, a last short chunk comes back as ErrUnexpectedEOF
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return read
+		}
+		if err != nil {
+			job.err = err
+			return read
+		}
+	}
+}
+
+// Cuts r off after hs.headBytes, r comes back as it is without a head
+func (hs *hashStage) head(r io.Reader) io.Reader {
+	if hs.headBytes <= 0 {
+		return r
+	}
+	return io.LimitReader(r, hs.headBytes)
+}
+
+// How much of a file size bytes big gets hashed
+func (hs *hashStage) headSize(size int64) int64 {
+	if hs.headBytes > 0 && size > hs.headBytes {
+		return hs.headBytes
+	}
+	return size
+}
+
+// Reports a file as failed without it having to go through streaming again
+func (hs *hashStage) fail(osPathname string, rec record, err error) {
+	job := &hashJob{osPathname: osPathname, rec: rec, chunks: make(chan *[]byte), err: err}
+	hs.jobs <- job
+	close(job.chunks)
+}
+
+// Hands a buffer back to the pool at its full size so the next reader gets the whole thing
+func (hs *hashStage) putBuffer(buf *[]byte) {
+	if hs.unpooled {
+		return
+	}
+	*buf = (*buf)[:cap(*buf)]
+	hs.buffers.Put(buf)
+}
+
+// Waits for the hash workers to finish everything they've been given, only call this once nothing else is going to be submitted
+func (hs *hashStage) close() {
+	close(hs.jobs)
+	hs.wg.Wait()
+}
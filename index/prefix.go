@@ -0,0 +1,62 @@
+package index
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// Swaps Options.StripPrefix on the front of path for Options.AddPrefix, see those for how it goes
+func (opts Options) rewritePrefix(path string) string {
+	if opts.StripPrefix == "" && opts.AddPrefix == "" {
+		return path
+	}
+	rest := path
+	if opts.StripPrefix != "" {
+		var ok bool
+		if rest, ok = stripPathPrefix(path, opts.StripPrefix); !ok {
+			return path
+		}
+	}
+	// Only the separators Go knows about for this OS get turned into /, a \ in a file name on Linux is just part of the name
+	rest = filepath.ToSlash(strings.TrimLeft(rest, `/\`))
+	add := strings.TrimRight(strings.ReplaceAll(opts.AddPrefix, `\`, "/"), "/")
+	switch {
+	case add == "":
+		return rest
+	case rest == "":
+		return add
+	}
+	return add + "/" + rest
+}
+
+// Cuts prefix off the front of path if it's there, ok is false if it isn't
+// / and \ are the same thing here so a prefix written either way works on either kind of path, and it has to end on a
+// separator so /mnt/backup doesn't take the front off /mnt/backups. Windows doesn't care about case so neither do we there
+func stripPathPrefix(path, prefix string) (string, bool) {
+	prefix = strings.TrimRight(prefix, `/\`)
+	rest := path
+	for _, want := range prefix {
+		got, size := utf8.DecodeRuneInString(rest)
+		if size == 0 || !samePathRune(got, want) {
+			return path, false
+		}
+		rest = rest[size:]
+	}
+	if rest != "" && rest[0] != '/' && rest[0] != '\\' && prefix != "" {
+		return path, false
+	}
+	return rest, true
+}
+
+func samePathRune(a, b rune) bool {
+	isSeparator := func(r rune) bool { return r == '/' || r == '\\' }
+	switch {
+	case isSeparator(a) || isSeparator(b):
+		return isSeparator(a) && isSeparator(b)
+	case runtime.GOOS == "windows":
+		return strings.EqualFold(string(a), string(b))
+	}
+	return a == b
+}
@@ -0,0 +1,62 @@
+package index
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestRewritePrefix(t *testing.T) {
+	tests := []struct {
+		path, strip, add string
+		want             string
+	}{
+		{"/mnt/backup/photos/a.jpg", "/mnt/backup", "", "photos/a.jpg"},
+		{"/mnt/backup/photos/a.jpg", "/mnt/backup/", "backup", "backup/photos/a.jpg"},
+		// A prefix written with either separator matches a path written with either
+		{"/mnt/backup/photos/a.jpg", `\mnt\backup`, `logical\root\`, "logical/root/photos/a.jpg"},
+		{`D:\backup\photos`, `D:/backup`, "backup", "backup/photos"},
+		{`D:\backup`, `D:\backup`, "backup", "backup"},
+		// It has to end on a separator, backups isn't under backup
+		{"/mnt/backups/a.jpg", "/mnt/backup", "x", "/mnt/backups/a.jpg"},
+		// Not under the prefix at all is left alone
+		{"/home/me/a.jpg", "/mnt/backup", "x", "/home/me/a.jpg"},
+		// No strip is just something in front of every path
+		{"photos/a.jpg", "", "/srv", "/srv/photos/a.jpg"},
+	}
+	for _, test := range tests {
+		opts := Options{StripPrefix: test.strip, AddPrefix: test.add}
+		if got := opts.rewritePrefix(test.path); got != test.want {
+			t.Errorf("rewritePrefix(%q) stripping %q and adding %q got %q, want %q", test.path, test.strip, test.add, got, test.want)
+		}
+	}
+
+	// Past the prefix a \ is only a separator where the OS says it is, on Linux it can be part of a file name
+	opts := Options{StripPrefix: `D:\backup`}
+	if got, want := opts.rewritePrefix(`D:\backup\photos\a.jpg`), filepath.ToSlash(`photos\a.jpg`); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// Two copies of the same tree in different places come out with the same paths once their roots are swapped for one name
+func TestPrefixIndexesCompare(t *testing.T) {
+	files := map[string]string{"a.txt": "a", "photos/b.jpg": "b"}
+	var runs [][]string
+	for i := 0; i < 2; i++ {
+		dir := t.TempDir()
+		writeTree(t, dir, files)
+		opts := testOptions(dir)
+		opts.StripPrefix = dir
+		opts.AddPrefix = "backup"
+		out, _ := runIndex(t, opts)
+		var paths []string
+		for _, rec := range parseCSV(t, out) {
+			paths = append(paths, rec["Path"])
+		}
+		sort.Strings(paths)
+		runs = append(runs, paths)
+	}
+	if want := []string{"backup/a.txt", "backup/photos/b.jpg"}; !equalStrings(runs[0], want) || !equalStrings(runs[1], want) {
+		t.Errorf("got %v and %v, want %v for both", runs[0], runs[1], want)
+	}
+}
@@ -0,0 +1,220 @@
+package index
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// The bits of a progress bar the walk and the workers use
+// Having an interface means they can just call Add without checking whether progress is turned on
+type progress interface {
+	Add(num int) error
+	Add64(num int64) error
+	ChangeMax(newMax int)
+	ChangeMax64(newMax int64)
+	Describe(description string)
+}
+
+// What you get when Options.ShowProgress is off, it does nothing at all
+type noProgress struct{}
+
+func (noProgress) Add(num int) error        { return nil }
+func (noProgress) Add64(num int64) error    { return nil }
+func (noProgress) ChangeMax(newMax int)     {}
+func (noProgress) ChangeMax64(newMax int64) {}
+func (noProgress) Describe(string)          {}
+
+// Progress bar for indexing files, -1 sets this to indeterminate
+// We don't know how many files we'll be parsing, could be a single file or an entire drive
+// description goes in front of the bar, empty leaves it off like it's always been
+// progressbar's own throttle would leave a spinner on whatever it last drew, rather than the real count once the walk is done,
+// so this bar does its throttling in a throttledBar where the walk can flush it at the end
+func newIndexBar(show bool, w io.Writer, description string, refresh time.Duration) progress {
+	if !show {
+		return noProgress{}
+	}
+	bar := newBar(w, -1, 0, progressbar.OptionShowCount(), progressbar.OptionShowIts(), progressbar.OptionSetDescription(description))
+	return &throttledBar{bar: bar, refresh: barRefresh(refresh)}
+}
+
+// Holds on to what the walk adds and only passes it on to the bar once every refresh, so a spinner doesn't redraw for every file
+type throttledBar struct {
+	mu      sync.Mutex
+	bar     *progressbar.ProgressBar
+	refresh time.Duration
+	last    time.Time
+	pending int64
+}
+
+func (t *throttledBar) Add(num int) error { return t.Add64(int64(num)) }
+
+func (t *throttledBar) Add64(num int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending += num
+	if time.Since(t.last) < t.refresh {
+		return nil
+	}
+	return t.flushLocked()
+}
+
+func (t *throttledBar) flushLocked() error {
+	t.last = time.Now()
+	n := t.pending
+	t.pending = 0
+	return t.bar.Add64(n)
+}
+
+// Draws whatever is still being held back, the walk calls this at the end so the bar is left on the real count
+func (t *throttledBar) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending > 0 {
+		t.flushLocked()
+	}
+}
+
+func (t *throttledBar) ChangeMax(newMax int)        { t.bar.ChangeMax(newMax) }
+func (t *throttledBar) ChangeMax64(newMax int64)    { t.bar.ChangeMax64(newMax) }
+func (t *throttledBar) Describe(description string) { t.bar.Describe(description) }
+
+// Only a throttledBar has anything to flush, the rest are always drawn up to date
+func flushProgress(p progress) {
+	if t, ok := p.(*throttledBar); ok {
+		t.flush()
+	}
+}
+
+// Progress bar for when we're hashing the files, it counts either files or bytes
+// Counting bytes gives you an ETA you can trust when a few huge files are in with lots of little ones,
+// a file still only moves the bar once it's done though, so one huge file on its own sits still until it's finished
+// Hashing starts while the walk is still going, so for a while there's no total. Rather than a second spinner fighting
+// the index bar for the same line, anything done before then is kept in pending and the bar shows up with it already counted
+type hashBar struct {
+	mu    sync.Mutex
+	show  bool
+	bytes bool
+	w     io.Writer
+	// What goes in front of the bar, and whether the file being read goes after it
+	description string
+	currentFile bool
+	// nil until setTotal
+	bar     progress
+	pending int64
+	// What current last described, a file can start before setTotal and the bar has to start out showing it
+	described string
+	// Options.ProgressRefresh
+	refresh time.Duration
+}
+
+func newHashBar(show, bytes bool, w io.Writer, description string, currentFile bool, refresh time.Duration) *hashBar {
+	return &hashBar{show: show, bytes: show && bytes, w: w, description: description, currentFile: currentFile, refresh: refresh}
+}
+
+// The longest a file name gets in the bar's description, any more and the bar itself gets squeezed down to nothing
+const maxDescribedName = 32
+
+// Puts the name of the file that's just started being read in the bar's description
+// progressbar doesn't lock anything when the description changes, so this has to hold the same lock as done,
+// that way it can't change while the bar is drawing. It only swaps a string, the bar picks it up the next time it draws
+func (h *hashBar) current(osPathname string) {
+	if !h.show || !h.currentFile {
+		return
+	}
+	name := filepath.Base(osPathname)
+	if len(name) > maxDescribedName {
+		// Cut on a whole character so a multi byte name doesn't end up with half of one
+		cut := maxDescribedName
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut] + "..."
+	}
+	if h.description != "" {
+		name = h.description + " " + name
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.described = name
+	if h.bar != nil {
+		h.bar.Describe(name)
+	}
+}
+
+// Moves the bar on by one file that was size bytes big, whether or not we managed to hash it
+func (h *hashBar) done(size int64) {
+	if !h.show {
+		return
+	}
+	n := int64(1)
+	if h.bytes {
+		n = size
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.bar == nil {
+		h.pending += n
+		return
+	}
+	h.bar.Add64(n)
+}
+
+// Sizes the bar once the walk knows how many files and bytes there are, this is also when it first gets drawn
+// If a file changes size between being found and being hashed the bar can end up a little short or over, it only ever goes by what the walk saw
+func (h *hashBar) setTotal(files int, bytes int64) {
+	if !h.show {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	description := h.description
+	if h.described != "" {
+		description = h.described
+	}
+	if h.bytes {
+		h.bar = newBar(h.w, bytes, barRefresh(h.refresh), progressbar.OptionShowBytes(true), progressbar.OptionSetDescription(description))
+	} else {
+		h.bar = newBar(h.w, int64(files), barRefresh(h.refresh), progressbar.OptionShowCount(), progressbar.OptionShowIts(), progressbar.OptionSetDescription(description))
+	}
+	h.bar.Add64(h.pending)
+}
+
+// The same bar progressbar.Default gives you, except it doesn't draw anything until it's used and it draws on w
+// extra is for the options that depend on what the bar is counting and refresh is the least time between redraws
+// progressbar always draws a bar once it gets to its total, so a bar with one never ends on a count that's behind
+func newBar(w io.Writer, max int64, refresh time.Duration, extra ...progressbar.Option) *progressbar.ProgressBar {
+	options := []progressbar.Option{
+		progressbar.OptionSetWriter(w),
+		progressbar.OptionSetWidth(10),
+		progressbar.OptionThrottle(refresh),
+		progressbar.OptionOnCompletion(func() {
+			fmt.Fprint(w, "\n")
+		}),
+		progressbar.OptionSpinnerType(14),
+		progressbar.OptionFullWidth(),
+	}
+	return progressbar.NewOptions64(max, append(options, extra...)...)
+}
+
+// Options.ProgressRefresh, with 0 being the 65ms we've always used
+func barRefresh(refresh time.Duration) time.Duration {
+	if refresh <= 0 {
+		return 65 * time.Millisecond
+	}
+	return refresh
+}
+
+// Where the bars get drawn, stderr unless Options.ProgressOutput says otherwise
+func (opts Options) progressOutput() io.Writer {
+	if opts.ProgressOutput == nil {
+		return os.Stderr
+	}
+	return opts.ProgressOutput
+}
@@ -0,0 +1,86 @@
+package index
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// The labels end up in what's drawn, and with ProgressCurrentFile so does a file that's being hashed
+func TestProgressDescriptions(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"only-file.txt": strings.Repeat("x", 1000)})
+	opts := testOptions(dir)
+	opts.ShowProgress = true
+	opts.IndexDescription = "walking-label"
+	opts.HashDescription = "hashing-label"
+	opts.ProgressCurrentFile = true
+	var progress bytes.Buffer
+	opts.ProgressOutput = &progress
+	out, _ := runIndex(t, opts)
+
+	drawn := progress.String()
+	for _, want := range []string{"walking-label", "hashing-label", "hashing-label only-file.txt"} {
+		if !strings.Contains(drawn, want) {
+			t.Errorf("%q isn't in the progress output:\n%q", want, drawn)
+		}
+	}
+	if strings.Contains(out, "label") {
+		t.Errorf("the progress ended up in the index:\n%s", out)
+	}
+}
+
+// A long name is cut down on a whole character so the bar still fits on a line
+func TestProgressCurrentFileCut(t *testing.T) {
+	var progress bytes.Buffer
+	h := newHashBar(true, false, &progress, "hashing", true, 0)
+	h.setTotal(2, 0)
+	name := strings.Repeat("é", maxDescribedName)
+	h.current("/somewhere/" + name)
+	// Getting to the total always draws the bar
+	h.done(0)
+	h.done(0)
+	drawn := progress.String()
+	if strings.Contains(drawn, name) || !strings.Contains(drawn, "...") {
+		t.Errorf("the name wasn't cut down:\n%q", drawn)
+	}
+	if !utf8.ValidString(drawn) {
+		t.Errorf("cutting the name left half a character:\n%q", drawn)
+	}
+}
+
+// A long refresh holds back the redraws on both bars, but the count they're left on at the end is still the real one
+func TestProgressRefresh(t *testing.T) {
+	var walked bytes.Buffer
+	bar := newIndexBar(true, &walked, "", time.Hour)
+	if tb, ok := bar.(*throttledBar); !ok || tb.refresh != time.Hour {
+		t.Fatalf("got %#v, want a throttledBar refreshing every hour", bar)
+	}
+	for i := 0; i < 100; i++ {
+		bar.Add(1)
+	}
+	// The first file draws it, everything after that is held back until the walk is done
+	if draws := strings.Count(walked.String(), "it/s"); draws != 1 {
+		t.Errorf("drew the walking bar %d times in an hour long refresh:\n%q", draws, walked.String())
+	}
+	flushProgress(bar)
+	if !strings.Contains(walked.String(), "(100/-") {
+		t.Errorf("the walking bar didn't end on 100:\n%q", walked.String())
+	}
+
+	var hashed bytes.Buffer
+	h := newHashBar(true, false, &hashed, "", false, time.Hour)
+	h.setTotal(100, 0)
+	for i := 0; i < 100; i++ {
+		h.done(0)
+	}
+	// The bar always draws when it gets to the total, however soon that is after the last time
+	if drawn := hashed.String(); strings.Count(drawn, "it/s") > 2 || !strings.Contains(drawn, "(100/100") {
+		t.Errorf("an hour long refresh drew the hashing bar as\n%q", drawn)
+	}
+	if barRefresh(0) != 65*time.Millisecond || barRefresh(time.Second) != time.Second {
+		t.Errorf("barRefresh gave %s and %s", barRefresh(0), barRefresh(time.Second))
+	}
+}
@@ -0,0 +1,67 @@
+package index
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// How far ahead of the rate the readers can get after sitting idle, as a fraction of a second's worth of bytes.
+// A tenth means a pause in the walk doesn't leave a whole second of reading owed that then gets done all at once
+const rateBurstFraction = 10
+
+// A token bucket for Options.RateLimit, shared by every reader so it's the total that's held to the rate rather than
+// each reader on its own. Every byte read costs a token, a read that takes more than there are waits until they've come in
+type rateLimiter struct {
+	limiter *rate.Limiter
+}
+
+// nil for a rate of 0, a nil rateLimiter doesn't hold anything back
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := bytesPerSecond / rateBurstFraction
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), int(burst))}
+}
+
+// Pays for n bytes that have just been read, waiting for as long as that puts us over the rate
+func (l *rateLimiter) wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	// WaitN won't take more than the bucket holds in one go, and a read buffer can easily be bigger than that at a low rate
+	burst := l.limiter.Burst()
+	for n > 0 {
+		take := n
+		if take > burst {
+			take = burst
+		}
+		// With no deadline on the context this only fails for more than the burst, which we never ask for
+		l.limiter.WaitN(context.Background(), take)
+		n -= take
+	}
+}
+
+// Wraps r so every read goes through l, r comes back as it is without a limit
+func (l *rateLimiter) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &rateReader{r: r, limiter: l}
+}
+
+type rateReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (r *rateReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.limiter.wait(n)
+	return n, err
+}
@@ -0,0 +1,59 @@
+package index
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Three readers sharing one limiter are held to the rate between them, not each
+func TestRateLimitShared(t *testing.T) {
+	var rate, each int64 = 1 << 20, 200 << 10
+	l := newRateLimiter(rate)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			io.Copy(ioutil.Discard, l.reader(bytes.NewReader(make([]byte, each))))
+		}()
+	}
+	wg.Wait()
+	// The bucket starts full, so the first burst's worth is free
+	want := time.Duration(float64(3*each-rate/rateBurstFraction) / float64(rate) * float64(time.Second))
+	if elapsed := time.Since(start); elapsed < want {
+		t.Errorf("read %d bytes at %d a second in %s, want at least %s", 3*each, rate, elapsed, want)
+	}
+}
+
+func TestRateLimitIndex(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.bin": strings.Repeat("a", 300<<10), "b.bin": strings.Repeat("b", 300<<10)})
+	opts := testOptions(dir)
+	opts.RateLimit = 2 << 20
+	start := time.Now()
+	runIndex(t, opts)
+	want := time.Duration(float64(600<<10-opts.RateLimit/rateBurstFraction) / float64(opts.RateLimit) * float64(time.Second))
+	if elapsed := time.Since(start); elapsed < want {
+		t.Errorf("indexed 600 KiB at 2 MiB a second in %s, want at least %s", elapsed, want)
+	}
+}
+
+// A rate so low the bucket is smaller than a read still gets through, a bit at a time
+func TestRateLimitSmallBurst(t *testing.T) {
+	l := newRateLimiter(5)
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, l.reader(strings.NewReader("0123456789")))
+	if n != 10 || err != nil {
+		t.Fatalf("got %d bytes and %v", n, err)
+	}
+	// The burst is a single byte, so the other 9 take 1.8s
+	if elapsed, want := time.Since(start), 1800*time.Millisecond; elapsed < want {
+		t.Errorf("10 bytes at 5 a second took %s, want at least %s", elapsed, want)
+	}
+}
@@ -0,0 +1,49 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+// How long to wait before the first retry with Options.Retries, every retry after that waits twice as long as the last
+const retryDelay = 100 * time.Millisecond
+
+// Whether err looks like something that could go away if we just tried again, like a network drive timing out
+// A file that isn't there or that we aren't allowed to read is going to be the same next time, so those never count
+func isTransient(err error) bool {
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return false
+	}
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+	for _, transient := range transientErrors {
+		if errors.Is(err, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// Runs read until it works or a try fails for good, waiting longer before every retry
+// read only returns an error when it failed with something worth trying again and canRetry was set, anything else it deals with itself.
+// If ctx is cancelled while we're waiting you get back the last error so it can go in the error log
+func withRetries(ctx context.Context, retries int, logger *Logger, osPathname string, read func(canRetry bool) error) error {
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		err := read(attempt < retries)
+		if err == nil {
+			return nil
+		}
+		logger.Verbosef("Trying %s again in %s after %s", osPathname, delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
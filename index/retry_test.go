@@ -0,0 +1,114 @@
+package index
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Every reader it opens fails with err part way until it has been opened more than failures times, like a share that drops out and comes back
+type flakyOpener struct {
+	content  string
+	failures int
+	err      error
+	opens    int
+}
+
+func (f *flakyOpener) open() io.Reader {
+	f.opens++
+	if f.opens <= f.failures {
+		return io.MultiReader(strings.NewReader(f.content[:1]), errorReader{f.err})
+	}
+	return strings.NewReader(f.content)
+}
+
+type errorReader struct {
+	err error
+}
+
+func (e errorReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
+
+// What index.go's read does with the file, hashing it and only handing back an error worth another go
+func hashWithRetries(t *testing.T, opener *flakyOpener, retries int) (string, error, error) {
+	t.Helper()
+	var sum string
+	var reported error
+	err := withRetries(context.Background(), retries, NewLogger(ioutil.Discard, LogQuiet), "flaky", func(canRetry bool) error {
+		h := sha256.New()
+		if _, err := io.Copy(h, opener.open()); err != nil {
+			if canRetry && isTransient(err) {
+				return err
+			}
+			reported = err
+			return nil
+		}
+		sum = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	return sum, reported, err
+}
+
+// Two transient failures and then it works, with retries to spare that's a hashed file and nothing in the error log
+func TestRetryTransient(t *testing.T) {
+	transient := &os.PathError{Op: "read", Path: "flaky", Err: transientErrors[0]}
+	opener := &flakyOpener{content: "hello", failures: 2, err: transient}
+	start := time.Now()
+	sum, reported, err := hashWithRetries(t, opener, 3)
+	if err != nil || reported != nil || sum != sha256Hex("hello") {
+		t.Errorf("got %q, reported %v and %v", sum, reported, err)
+	}
+	if opener.opens != 3 {
+		t.Errorf("opened it %d times, want 3", opener.opens)
+	}
+	// Waiting retryDelay and then twice that
+	if elapsed := time.Since(start); elapsed < 3*retryDelay {
+		t.Errorf("only waited %s between the tries", elapsed)
+	}
+
+	// Run out of retries and the last failure is reported like any other
+	opener = &flakyOpener{content: "hello", failures: 2, err: transient}
+	if sum, reported, err := hashWithRetries(t, opener, 1); err != nil || !errors.Is(reported, transientErrors[0]) || sum != "" || opener.opens != 2 {
+		t.Errorf("with 1 retry got %q, reported %v and %v after %d opens", sum, reported, err, opener.opens)
+	}
+}
+
+// A file that isn't there or that we can't read is going to be the same next time, so there's only the one go at those
+func TestRetryPermanent(t *testing.T) {
+	for _, failure := range []error{os.ErrNotExist, os.ErrPermission, errors.New("something else")} {
+		opener := &flakyOpener{content: "hello", failures: 1, err: &os.PathError{Op: "open", Path: "flaky", Err: failure}}
+		if _, reported, _ := hashWithRetries(t, opener, 5); !errors.Is(reported, failure) || opener.opens != 1 {
+			t.Errorf("%v was tried %d times and reported as %v", failure, opener.opens, reported)
+		}
+	}
+	if !isTransient(timeoutError{}) {
+		t.Errorf("an error that says it's a timeout isn't transient")
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "timed out" }
+func (timeoutError) Timeout() bool { return true }
+
+// Cancelling while it's waiting to go again gives back the error it was going to retry
+func TestRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tries := 0
+	err := withRetries(ctx, 5, NewLogger(ioutil.Discard, LogQuiet), "flaky", func(canRetry bool) error {
+		tries++
+		return transientErrors[0]
+	})
+	if !errors.Is(err, transientErrors[0]) || tries != 1 {
+		t.Errorf("got %v after %d tries", err, tries)
+	}
+}
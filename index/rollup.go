@@ -0,0 +1,87 @@
+package index
+
+import (
+	"hash"
+	"io"
+	"strconv"
+)
+
+// A recordWriter for Options.Rollup, everything goes straight through to rw and a copy of the path and first hash
+// goes to a sortingWriter as well. Once it's all been sorted by path the pairs get hashed one after another into a single hash
+// for the whole scan, so two scans of the same tree give the same string whatever order the workers finished in.
+// The sorting spills to temporary files like Options.Sort does, so this doesn't need the whole tree in memory either
+type rollupWriter struct {
+	rw     recordWriter
+	sorter *sortingWriter
+	sum    *rollupSum
+	stats  *runStats
+}
+
+func newRollupWriter(rw recordWriter, hashNames []string, encoding string, tempDir string, stats *runStats) *rollupWriter {
+	h, _ := newHasher(hashNames[0])
+	sum := &rollupSum{h: h, encoding: encoding}
+	return &rollupWriter{rw: rw, sorter: newSortingWriter(sum, recordLess, tempDir), sum: sum, stats: stats}
+}
+
+func (r *rollupWriter) WriteHeader() error {
+	return r.rw.WriteHeader()
+}
+
+func (r *rollupWriter) WriteRecord(rec record) error {
+	if err := r.rw.WriteRecord(rec); err != nil {
+		return err
+	}
+	// An error row from Options.ErrorsInline isn't a file in the scan, a file that changed while it was hashed isn't one to trust
+	if rec.Error != "" {
+		return nil
+	}
+	// The rest of the record would only make the temporary files bigger
+	pair := record{Path: rec.Path, Offset: rec.Offset}
+	if len(rec.Hashes) > 0 {
+		pair.Hashes = rec.Hashes[:1]
+	}
+	return r.sorter.WriteRecord(pair)
+}
+
+// The rollup hash goes in Stats once everything has been through, even if rw fails to flush since the hash doesn't depend on it
+func (r *rollupWriter) Flush() error {
+	sortErr := r.sorter.Flush()
+	if sortErr == nil {
+		r.stats.setRollup(r.sum.value)
+	}
+	if err := r.rw.Flush(); err != nil {
+		return err
+	}
+	return sortErr
+}
+
+// Where the sorted pairs end up, every one of them is written into h
+type rollupSum struct {
+	h        hash.Hash
+	encoding string
+	value    string
+}
+
+func (s *rollupSum) WriteHeader() error {
+	return nil
+}
+
+// A NUL can't be in a path, so no two different lists of files can give the same input. The offset is only there with
+// Options.Chunks, where one path has a record for every chunk
+func (s *rollupSum) WriteRecord(r record) error {
+	hash := ""
+	if len(r.Hashes) > 0 {
+		hash = r.Hashes[0]
+	}
+	line := r.Path + "\x00" + hash
+	if r.Offset > 0 {
+		line += "\x00" + strconv.FormatInt(r.Offset, 10)
+	}
+	_, err := io.WriteString(s.h, line+"\n")
+	return err
+}
+
+func (s *rollupSum) Flush() error {
+	s.value = encodeSum(s.h.Sum(nil), s.encoding)
+	return nil
+}
@@ -0,0 +1,68 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func rollupOf(t *testing.T, dir string, workers int) string {
+	t.Helper()
+	opts := testOptions(dir)
+	opts.Workers = workers
+	opts.Rollup = true
+	_, stats := runIndex(t, opts)
+	if stats.Rollup == "" {
+		t.Fatalf("no rollup hash in %+v", stats)
+	}
+	return stats.Rollup
+}
+
+// The rollup only changes when a path or a hash does, whatever order the workers finished in and whatever the times say
+func TestRollup(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		files[filepath.ToSlash(filepath.Join("d"+string(rune('a'+i%5)), "f"+string(rune('a'+i/5))))] = string(rune('A' + i))
+	}
+	writeTree(t, dir, files)
+	original := rollupOf(t, dir, 1)
+	for _, workers := range []int{1, 4, 8} {
+		if got := rollupOf(t, dir, workers); got != original {
+			t.Errorf("%d workers got %s, one got %s", workers, got, original)
+		}
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "da", "fa"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := rollupOf(t, dir, 2); got != original {
+		t.Errorf("a new modified time changed the rollup")
+	}
+
+	changed := func(what string) {
+		t.Helper()
+		if got := rollupOf(t, dir, 2); got == original {
+			t.Errorf("%s didn't change the rollup", what)
+		}
+	}
+	writeTree(t, dir, map[string]string{"da/fa": "edited"})
+	changed("editing a file")
+	writeTree(t, dir, map[string]string{"da/fa": files["da/fa"]})
+	if got := rollupOf(t, dir, 2); got != original {
+		t.Errorf("putting the file back didn't give the original rollup")
+	}
+
+	// The same hashes under different paths
+	if err := os.Rename(filepath.Join(dir, "da", "fa"), filepath.Join(dir, "da", "renamed")); err != nil {
+		t.Fatal(err)
+	}
+	changed("renaming a file")
+	os.Rename(filepath.Join(dir, "da", "renamed"), filepath.Join(dir, "da", "fa"))
+	writeTree(t, dir, map[string]string{"da/fa": files["db/fa"], "db/fa": files["da/fa"]})
+	changed("swapping two files")
+	writeTree(t, dir, map[string]string{"da/fa": files["da/fa"], "db/fa": files["db/fa"], "new": ""})
+	changed("adding an empty file")
+}
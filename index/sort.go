@@ -0,0 +1,204 @@
+package index
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// How many records we hold in memory before sorting them and moving them out to a temporary file
+// A record is a couple of hundred bytes, so this keeps us to a few tens of MB however big the tree is
+const sortRunSize = 100000
+
+// A recordWriter for Options.Sort, it holds on to everything until Flush and then hands it all to the real writer sorted by path
+// Anything that doesn't fit in memory gets sorted in runs that go to temporary files, Flush merges those back together,
+// so sorting a whole drive doesn't need the whole index in memory
+// Options.DupesExternal uses it too, sorting by hash instead so every group of duplicates comes out together
+type sortingWriter struct {
+	rw      recordWriter
+	less    func(a, b record) bool
+	tempDir string
+	pending []record
+	// Every run we've written out so far, already sorted
+	runs []*os.File
+}
+
+// The runs go in tempDir, empty means the system's temporary directory
+func newSortingWriter(rw recordWriter, less func(a, b record) bool, tempDir string) *sortingWriter {
+	return &sortingWriter{rw: rw, less: less, tempDir: tempDir}
+}
+
+// The header doesn't need sorting, it goes straight through so it still comes first
+func (s *sortingWriter) WriteHeader() error {
+	return s.rw.WriteHeader()
+}
+
+func (s *sortingWriter) WriteRecord(r record) error {
+	s.pending = append(s.pending, r)
+	if len(s.pending) < sortRunSize {
+		return nil
+	}
+	return s.spill()
+}
+
+// Stable, so records that sort the same stay in the order they were written. Options.DedupePaths relies on that to keep the last one
+func sortRecords(records []record, less func(a, b record) bool) {
+	sort.SliceStable(records, func(i, j int) bool { return less(records[i], records[j]) })
+}
+
+// Path order, the chunks of a file with Options.Chunks all have the same path so those go by where they are in the file
+func recordLess(a, b record) bool {
+	if a.Path != b.Path {
+		return a.Path < b.Path
+	}
+	return a.Offset < b.Offset
+}
+
+// Hash order for Options.DupesExternal, with the paths in a group in order too so it comes out the same as the in memory dupes
+func hashLess(a, b record) bool {
+	if a.Hashes[0] != b.Hashes[0] {
+		return a.Hashes[0] < b.Hashes[0]
+	}
+	return a.Path < b.Path
+}
+
+// Sorts what we've got in memory and writes it out to a new run
+func (s *sortingWriter) spill() error {
+	sortRecords(s.pending, s.less)
+	f, err := ioutil.TempFile(s.tempDir, "goindex-sort-")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f)
+
+	// gob copes with time.Time and the hash slice without us having to pick a format for it
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	for _, r := range s.pending {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	s.pending = s.pending[:0]
+	return nil
+}
+
+// Writes everything out in order and then flushes the real writer
+func (s *sortingWriter) Flush() error {
+	defer s.removeRuns()
+
+	// If it all fit in memory there's nothing to merge
+	if len(s.runs) == 0 {
+		sortRecords(s.pending, s.less)
+		for _, r := range s.pending {
+			if err := s.rw.WriteRecord(r); err != nil {
+				return err
+			}
+		}
+		return s.rw.Flush()
+	}
+
+	// Whatever is left over becomes the last run, so every record comes out of the same merge
+	if len(s.pending) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	// Start every run from the top and keep taking whichever one has the record that sorts first next
+	merge := runHeap{less: s.less}
+	for i, f := range s.runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		run := &sortRun{dec: gob.NewDecoder(bufio.NewReader(f)), order: i}
+		ok, err := run.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			merge.runs = append(merge.runs, run)
+		}
+	}
+	heap.Init(&merge)
+	for merge.Len() > 0 {
+		run := merge.runs[0]
+		if err := s.rw.WriteRecord(run.head); err != nil {
+			return err
+		}
+		ok, err := run.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&merge, 0)
+		} else {
+			heap.Pop(&merge)
+		}
+	}
+	return s.rw.Flush()
+}
+
+// Temporary files are just that, they go whether the merge worked or not
+func (s *sortingWriter) removeRuns() {
+	for _, f := range s.runs {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	s.runs = nil
+}
+
+// One of the sorted runs being read back in, head is the next record it has for us
+// order is which run it was, so records that sort the same come out of the earlier run first
+type sortRun struct {
+	dec   *gob.Decoder
+	head  record
+	order int
+}
+
+// Moves on to the next record in the run, ok is false once it's empty
+func (r *sortRun) next() (bool, error) {
+	r.head = record{}
+	if err := r.dec.Decode(&r.head); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// container/heap wants one of these, the run whose head sorts first is always on top
+type runHeap struct {
+	runs []*sortRun
+	less func(a, b record) bool
+}
+
+func (h runHeap) Len() int            { return len(h.runs) }
+func (h runHeap) Swap(i, j int)       { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *runHeap) Push(x interface{}) { h.runs = append(h.runs, x.(*sortRun)) }
+
+func (h runHeap) Less(i, j int) bool {
+	a, b := h.runs[i], h.runs[j]
+	if h.less(a.head, b.head) {
+		return true
+	}
+	if h.less(b.head, a.head) {
+		return false
+	}
+	return a.order < b.order
+}
+
+func (h *runHeap) Pop() interface{} {
+	old := h.runs
+	run := old[len(old)-1]
+	h.runs = old[:len(old)-1]
+	return run
+}
@@ -0,0 +1,57 @@
+package index
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/karrick/godirwalk"
+)
+
+// How long we'll keep reading a FIFO, socket or device with Options.IncludeSpecial before giving up on it
+// Something like /dev/zero never ends and a FIFO nobody is writing to could block forever, this is what stops a worker getting stuck on one
+const specialReadTimeout = 30 * time.Second
+
+var errSpecialTimeout = errors.New("gave up reading a special file after " + specialReadTimeout.String())
+
+// Returns true for anything that isn't a regular file or a directory, FIFOs, sockets and devices
+// A symlink gets looked at through to whatever it points at, if that can't be stat'd we leave it to the worker to report
+func isSpecial(osPathname string, de *godirwalk.Dirent) bool {
+	if de.IsRegular() || de.IsDir() {
+		return false
+	}
+	if de.IsSymlink() {
+		info, err := os.Stat(longPath(osPathname))
+		if err != nil {
+			return false
+		}
+		return !info.Mode().IsRegular() && !info.IsDir()
+	}
+	return true
+}
+
+// Reads a special file until specialReadTimeout is up
+// FIFOs and sockets get a real read deadline so even a read that's waiting for data is cut off,
+// devices don't support one so for those we can only stop between reads
+type deadlineReader struct {
+	f        *os.File
+	deadline time.Time
+}
+
+func newDeadlineReader(f *os.File) *deadlineReader {
+	deadline := time.Now().Add(specialReadTimeout)
+	// This fails with os.ErrNoDeadline for anything the runtime can't poll, the check in Read still covers those
+	f.SetReadDeadline(deadline)
+	return &deadlineReader{f: f, deadline: deadline}
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	if time.Now().After(d.deadline) {
+		return 0, errSpecialTimeout
+	}
+	n, err := d.f.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = errSpecialTimeout
+	}
+	return n, err
+}
@@ -0,0 +1,62 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSpecialFilesSkippedByDefault(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "empty.txt": ""})
+	if err := syscall.Mkfifo(filepath.Join(dir, "pipe"), 0644); err != nil {
+		t.Skipf("can't make a FIFO here: %s", err)
+	}
+
+	byPath := indexByPath(t, testOptions(dir))
+	if _, ok := byPath[filepath.Join(dir, "pipe")]; ok {
+		t.Errorf("the FIFO was indexed without IncludeSpecial")
+	}
+	// An empty regular file isn't special, it gets the hash of nothing
+	if got := byPath[filepath.Join(dir, "empty.txt")]["SHA256 Hash"]; got != sha256Hex("") {
+		t.Errorf("empty file hashed to %s", got)
+	}
+}
+
+// The FIFO is opened before anybody writes to it, the record has to have what the writer sent rather than the hash of nothing
+func TestSpecialFIFOWaitsForWriter(t *testing.T) {
+	dir := t.TempDir()
+	pipe := filepath.Join(dir, "pipe")
+	if err := syscall.Mkfifo(pipe, 0644); err != nil {
+		t.Skipf("can't make a FIFO here: %s", err)
+	}
+	go func() {
+		time.Sleep(time.Second)
+		w, err := os.OpenFile(pipe, os.O_WRONLY, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		w.Write([]byte("data"))
+		w.Close()
+	}()
+
+	opts := testOptions(dir)
+	opts.IncludeSpecial = true
+	out, stats := runIndex(t, opts)
+	records := parseCSV(t, out)
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1\n%s", len(records), out)
+	}
+	if records[0]["SHA256 Hash"] != sha256Hex("data") || records[0]["Size"] != "4" {
+		t.Errorf("FIFO recorded as %s with size %s, want the hash of data", records[0]["SHA256 Hash"], records[0]["Size"])
+	}
+	if stats.Errors != 0 {
+		t.Errorf("got %d errors", stats.Errors)
+	}
+}
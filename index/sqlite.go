@@ -0,0 +1,193 @@
+package index
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+
+	// Registers the sqlite3 driver with database/sql, this one needs cgo so you'll want a C compiler around to build goindex
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// How many rows go into one transaction
+// sqlite syncs to disk on every commit, so committing each row on its own would take forever on a big tree
+const sqliteBatchSize = 1000
+
+// Writes records into a files table in an sqlite database, or whatever Options.Table says, so you can run queries like
+// SELECT hash, count(*) FROM files GROUP BY hash HAVING count(*) > 1 instead of using -dupes
+// Diffing works the same way, ATTACH 'old.sqlite' AS old and join old.files against files on path
+// The asyncWriter only ever calls this from one goroutine, so a single transaction and statement is all we need
+type sqliteWriter struct {
+	db         *sql.DB
+	tx         *sql.Tx
+	stmt       *sql.Stmt
+	table      string
+	hashNames  []string
+	timeFormat string
+	columns    optionalColumns
+	pending    int
+}
+
+// sqlite wants a file name rather than something to write to, so out has to be a file we can get the name of
+// RFC3339 is a format sqlite's own date functions understand, so unless you asked for something else that's what mod_time gets
+// That way you can do things like WHERE mod_time > date('now', '-7 days')
+func newSQLiteWriter(out io.Writer, opts Options) (*sqliteWriter, error) {
+	named, ok := out.(interface{ Name() string })
+	if !ok || named.Name() == "/dev/stdout" {
+		return nil, fmt.Errorf("the sqlite format has to be written to a file")
+	}
+	db, err := sql.Open("sqlite3", named.Name())
+	if err != nil {
+		return nil, err
+	}
+	timeFormat := opts.TimeFormat
+	if timeFormat == "" {
+		timeFormat = "rfc3339"
+	}
+	return &sqliteWriter{db: db, table: opts.table(), hashNames: opts.Hashes, timeFormat: timeFormat, columns: optionalColumnsFor(opts)}, nil
+}
+
+// The column names for the hashes, parquet uses the same ones so a query works on either
+// hash is always the first algorithm so queries don't need to know which one you picked
+// If you asked for more than one every algorithm gets a column of its own as well, like sha256_hash
+func tableHashColumns(hashNames []string) []string {
+	columns := []string{"hash"}
+	if len(hashNames) > 1 {
+		for _, name := range hashNames {
+			columns = append(columns, name+"_hash")
+		}
+	}
+	return columns
+}
+
+// The optional columns get the same names as in the CSV header, just lower case
+// Group and Offset are keywords in SQL, so those are group_name and chunk_offset to save you quoting them in every query
+// HashMs is hash_ms, that's easier to read once it's all lower case
+// Extra columns can be called anything, they keep the name they were given
+func tableOptionalColumns(columns optionalColumns) []string {
+	all := columns.names()
+	var names []string
+	for _, name := range all[:len(all)-len(columns.extra)] {
+		switch name {
+		case "Group":
+			name = "group_name"
+		case "Offset":
+			name = "chunk_offset"
+		case "HashMs":
+			name = "hash_ms"
+		}
+		names = append(names, strings.ToLower(name))
+	}
+	return append(names, columns.extra...)
+}
+
+func (s *sqliteWriter) hashColumns() []string {
+	return tableHashColumns(s.hashNames)
+}
+
+func (s *sqliteWriter) optionalColumns() []string {
+	return quotedOptionalColumns(s.columns)
+}
+
+// Extra columns get quoted so a space in one doesn't break the table, the sql format quotes them the same way
+func quotedOptionalColumns(columns optionalColumns) []string {
+	names := tableOptionalColumns(columns)
+	for i := len(names) - len(columns.extra); i < len(names); i++ {
+		names[i] = `"` + strings.ReplaceAll(names[i], `"`, `""`) + `"`
+	}
+	return names
+}
+
+// Creates the table, replacing the one from last time if you're writing to the same database again
+func (s *sqliteWriter) WriteHeader() error {
+	columns := []string{"path TEXT NOT NULL"}
+	for _, name := range s.hashColumns() {
+		columns = append(columns, name+" TEXT NOT NULL")
+	}
+	columns = append(columns, "size INTEGER NOT NULL", "mod_time TEXT NOT NULL")
+	// The offset is a number like size, sqlite turns the text we insert into one since the column says it's an INTEGER
+	// hash_ms is the same as a REAL, apart from the empty ones for directories and symlinks which stay as text
+	for _, name := range s.optionalColumns() {
+		columnType := "TEXT"
+		switch name {
+		case "chunk_offset":
+			columnType = "INTEGER"
+		case "hash_ms":
+			columnType = "REAL"
+		}
+		columns = append(columns, name+" "+columnType+" NOT NULL")
+	}
+
+	if _, err := s.db.Exec("DROP TABLE IF EXISTS " + s.table); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("CREATE TABLE " + s.table + " (" + strings.Join(columns, ", ") + ")"); err != nil {
+		return err
+	}
+	return s.begin()
+}
+
+// Starts a new transaction and prepares the insert inside it
+func (s *sqliteWriter) begin() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	columns := append([]string{"path"}, s.hashColumns()...)
+	columns = append(columns, "size", "mod_time")
+	columns = append(columns, s.optionalColumns()...)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	stmt, err := tx.Prepare("INSERT INTO " + s.table + " (" + strings.Join(columns, ", ") + ") VALUES (" + placeholders + ")")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	s.tx, s.stmt, s.pending = tx, stmt, 0
+	return nil
+}
+
+// Commits whatever has been inserted so far
+func (s *sqliteWriter) commit() error {
+	s.stmt.Close()
+	return s.tx.Commit()
+}
+
+func (s *sqliteWriter) WriteRecord(r record) error {
+	args := []interface{}{r.Path, r.Hashes[0]}
+	if len(r.Hashes) > 1 {
+		for _, h := range r.Hashes {
+			args = append(args, h)
+		}
+	}
+	args = append(args, r.Size, formatTime(r.ModTime, s.timeFormat))
+	for _, v := range s.columns.values(r) {
+		args = append(args, v)
+	}
+	if _, err := s.stmt.Exec(args...); err != nil {
+		return err
+	}
+
+	s.pending++
+	if s.pending < sqliteBatchSize {
+		return nil
+	}
+	if err := s.commit(); err != nil {
+		return err
+	}
+	return s.begin()
+}
+
+// Commits the last batch and indexes the hash column so looking up duplicates is quick
+// The index is created after all the inserts since building it once is a lot faster than keeping it up to date row by row
+func (s *sqliteWriter) Flush() error {
+	defer s.db.Close()
+	if s.tx == nil {
+		return nil
+	}
+	if err := s.commit(); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("CREATE INDEX " + s.table + "_hash ON " + s.table + " (hash)")
+	return err
+}
@@ -0,0 +1,120 @@
+package index
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+// How many rows go into one INSERT, enough that a big import isn't one statement per file
+// but not so many that a database with a limit on how long a statement can be turns it down
+const sqlInsertBatch = 500
+
+// A recordWriter for Options.Format sql, a script of INSERTs into Options.Table for loading into a database you already have
+// the table in, the columns are named the same as the sqlite ones. It's all inside one transaction so a script that fails
+// half way through doesn't leave half an index behind
+//
+//	BEGIN;
+//	INSERT INTO files (path, hash, size, mod_time) VALUES
+//	('/a.txt', '...', 3, '2021-05-01T10:00:00Z'),
+//	('/b.txt', '...', 5, '2021-05-01T10:00:00Z');
+//	COMMIT;
+//
+// Strings are standard SQL literals with every ' doubled and nothing else escaped, MySQL needs NO_BACKSLASH_ESCAPES
+// or a path with a \ in it comes out wrong. Rows are written as they come, the COMMIT goes on in Flush, which happens on an interrupt too
+type sqlScriptWriter struct {
+	w          io.Writer
+	table      string
+	hashNames  []string
+	timeFormat string
+	columns    optionalColumns
+	// How many rows are in the INSERT we're in the middle of, 0 means there isn't one
+	pending int
+}
+
+// rfc3339 is the same as sqlite gets, and it's a timestamp every database can read
+func newSQLScriptWriter(w io.Writer, table string, hashNames []string, timeFormat string, columns optionalColumns) *sqlScriptWriter {
+	if timeFormat == "" {
+		timeFormat = "rfc3339"
+	}
+	return &sqlScriptWriter{w: w, table: table, hashNames: hashNames, timeFormat: timeFormat, columns: columns}
+}
+
+func (s *sqlScriptWriter) WriteHeader() error {
+	_, err := io.WriteString(s.w, "BEGIN;\n")
+	return err
+}
+
+func (s *sqlScriptWriter) insert() string {
+	columns := append([]string{"path"}, tableHashColumns(s.hashNames)...)
+	columns = append(columns, "size", "mod_time")
+	columns = append(columns, quotedOptionalColumns(s.columns)...)
+	return "INSERT INTO " + s.table + " (" + strings.Join(columns, ", ") + ") VALUES\n"
+}
+
+func (s *sqlScriptWriter) WriteRecord(r record) error {
+	values := []string{sqlString(r.Path), sqlString(r.Hashes[0])}
+	if len(r.Hashes) > 1 {
+		for _, h := range r.Hashes {
+			values = append(values, sqlString(h))
+		}
+	}
+	values = append(values, strconv.FormatInt(r.Size, 10), sqlString(formatTime(r.ModTime, s.timeFormat)))
+	for _, v := range s.columns.values(r) {
+		values = append(values, sqlString(v))
+	}
+
+	prefix := ",\n"
+	if s.pending == 0 {
+		prefix = s.insert()
+	}
+	if _, err := io.WriteString(s.w, prefix+"("+strings.Join(values, ", ")+")"); err != nil {
+		return err
+	}
+	s.pending++
+	if s.pending < sqlInsertBatch {
+		return nil
+	}
+	return s.endInsert()
+}
+
+func (s *sqlScriptWriter) endInsert() error {
+	if s.pending == 0 {
+		return nil
+	}
+	s.pending = 0
+	_, err := io.WriteString(s.w, ";\n")
+	return err
+}
+
+func (s *sqlScriptWriter) Flush() error {
+	if err := s.endInsert(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(s.w, "COMMIT;\n")
+	return err
+}
+
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Table names go into the SQL as they are, so they're kept to letters, digits and underscores
+// with one dot allowed for a schema like public.files. Then they mean the same thing to every database without any quoting
+func isSQLName(name string) bool {
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" || (part[0] >= '0' && part[0] <= '9') {
+			return false
+		}
+		for _, c := range part {
+			if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+package index
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Splits a script into its statements the way a database would read it, a ; inside a string doesn't end one
+// and two quotes in a row inside a string are one quote. Anything left open at the end, a string or a bracket, is an error
+func sqlStatements(script string) ([]string, error) {
+	var statements []string
+	var current strings.Builder
+	inString := false
+	depth := 0
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		current.WriteByte(c)
+		switch {
+		case inString && c == '\'' && i+1 < len(script) && script[i+1] == '\'':
+			current.WriteByte('\'')
+			i++
+		case c == '\'':
+			inString = !inString
+		case inString:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("a ) with nothing to close at %d", i)
+			}
+		case c == ';':
+			if depth != 0 {
+				return nil, fmt.Errorf("a statement ended inside brackets at %d", i)
+			}
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if inString || depth != 0 {
+		return nil, fmt.Errorf("the script ends inside a string or brackets")
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		return nil, fmt.Errorf("%q at the end isn't ended with a ;", rest)
+	}
+	return statements, nil
+}
+
+func TestSQLString(t *testing.T) {
+	for in, want := range map[string]string{
+		"plain":        "'plain'",
+		"it's":         "'it''s'",
+		"''":           "''''''",
+		`back\slash`:   `'back\slash'`,
+		"new\nline; x": "'new\nline; x'",
+		"":             "''",
+	} {
+		if got := sqlString(in); got != want {
+			t.Errorf("%q got %s, want %s", in, got, want)
+		}
+	}
+}
+
+// The script lexes as BEGIN, the INSERTs and COMMIT, quotes in a path are doubled, and sqlite runs it and gets every file back as it was
+func TestSQLScript(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"it's a file.txt": "quoted", "semi;colon (1).txt": "awkward", "plain.txt": "plain"}
+	if runtime.GOOS != "windows" {
+		files[`back\slash.txt`] = "backslash"
+	}
+	writeTree(t, dir, files)
+	modTime := time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC)
+	quoted := filepath.Join(dir, "it's a file.txt")
+	if err := os.Chtimes(quoted, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions(dir)
+	opts.Format = "sql"
+	opts.Table = "indexed"
+	opts.Sort = true
+
+	out, _ := runIndex(t, opts)
+	statements, err := sqlStatements(out)
+	if err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+	if len(statements) != 3 || statements[0] != "BEGIN;" || statements[2] != "COMMIT;" || !strings.HasPrefix(statements[1], "INSERT INTO indexed (path, hash, size, mod_time) VALUES\n") {
+		t.Fatalf("got statements %q", statements)
+	}
+	want := "(" + sqlString(quoted) + ", '" + sha256Hex("quoted") + "', 6, '2021-05-01T10:30:00Z')"
+	if !strings.Contains(statements[1], want) || !strings.Contains(out, "it''s a file.txt") {
+		t.Errorf("the insert doesn't have the row\n%s\nit got\n%s", want, statements[1])
+	}
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE indexed (path TEXT, hash TEXT, size INTEGER, mod_time TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(out); err != nil {
+		t.Fatalf("sqlite couldn't run the script: %s\n%s", err, out)
+	}
+	rows, err := db.Query("SELECT path, hash, size FROM indexed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	got := 0
+	for rows.Next() {
+		var path, hash string
+		var size int64
+		if err := rows.Scan(&path, &hash, &size); err != nil {
+			t.Fatal(err)
+		}
+		name, _ := filepath.Rel(dir, path)
+		content, ok := files[name]
+		if !ok || hash != sha256Hex(content) || size != int64(len(content)) {
+			t.Errorf("sqlite got %q %s %d", path, hash, size)
+		}
+		got++
+	}
+	if got != len(files) {
+		t.Errorf("sqlite got %d rows, want %d", got, len(files))
+	}
+}
+
+// More rows than go in one INSERT get split over a few, each of them still a statement on its own
+func TestSQLScriptBatches(t *testing.T) {
+	var out bytes.Buffer
+	s := newSQLScriptWriter(&out, "files", []string{"sha256", "md5"}, "", optionalColumns{})
+	s.WriteHeader()
+	n := 2*sqlInsertBatch + 1
+	for i := 0; i < n; i++ {
+		s.WriteRecord(record{Path: fmt.Sprintf("/f%d's", i), Hashes: []string{"a", "b"}, ModTime: time.Unix(0, 0)})
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	statements, err := sqlStatements(out.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statements) != 5 {
+		t.Fatalf("got %d statements, want BEGIN, three INSERTs and COMMIT", len(statements))
+	}
+	for i, statement := range statements[1:4] {
+		if !strings.HasPrefix(statement, "INSERT INTO files (path, hash, sha256_hash, md5_hash, size, mod_time) VALUES\n") {
+			t.Errorf("statement %d is %.80q", i+1, statement)
+		}
+	}
+	if rows := strings.Count(out.String(), "'a', 'b'"); rows != n {
+		t.Errorf("got %d rows, want %d", rows, n)
+	}
+
+	// Nothing written still gives a script that does nothing
+	var empty bytes.Buffer
+	s = newSQLScriptWriter(&empty, "files", []string{"sha256"}, "", optionalColumns{})
+	s.WriteHeader()
+	s.Flush()
+	if empty.String() != "BEGIN;\nCOMMIT;\n" {
+		t.Errorf("an empty index got %q", empty.String())
+	}
+}
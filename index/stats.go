@@ -0,0 +1,170 @@
+package index
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// What Index did, handy for printing a summary at the end of a run
+type Stats struct {
+	// How many files made it into the output
+	Files int64
+	// The total size of those files
+	Bytes int64
+	// How many files or directories we couldn't read
+	Errors int64
+	// How many FIFOs, sockets and devices were skipped, they're never read unless Options.IncludeSpecial is set
+	Special int64
+	// How many files were left out because they're the same as in Options.SinceIndex
+	Unchanged int64
+	// How many symlinks were written with Options.RecordSymlinks, they aren't counted in Files
+	Symlinks int64
+	// Wall time from the start of Index until everything was written
+	Elapsed time.Duration
+
+	// These are only filled in when Options.Verify is set
+	// Files whose hash doesn't match the index
+	Mismatched int64
+	// Files that aren't the size the index says, they aren't counted in Mismatched as well
+	Resized int64
+	// Files in the index that weren't found
+	Missing int64
+	// Files that were found but aren't in the index
+	Added int64
+
+	// With Options.Rollup, one hash made from the path and hash of every file in the output. Two scans with the same
+	// rollup have the same files with the same contents, so comparing it answers whether anything changed in one string
+	Rollup string
+
+	// How many files of each extension made it into the output and how big they were all together,
+	// biggest first so the top of the list is whatever is taking up the most space
+	Extensions []ExtensionStats
+}
+
+// The totals for one file extension
+type ExtensionStats struct {
+	// Lowercased and with the dot, like .go. Files without an extension are under (none)
+	Extension string
+	Files     int64
+	Bytes     int64
+}
+
+// Counters for Stats while Index is running
+// The workers all bump these at the same time, so only ever touch them through the atomic functions
+type runStats struct {
+	files   int64
+	bytes   int64
+	errors  int64
+	special int64
+	// Files left out by Options.SinceIndex
+	unchanged int64
+	symlinks  int64
+	start     time.Time
+
+	mismatched int64
+	resized    int64
+	missing    int64
+	added      int64
+
+	// A map can't be updated atomically, so the extensions get a lock of their own
+	extMu      sync.Mutex
+	extensions map[string]*ExtensionStats
+	// Options.Rollup, set once at the end under extMu too
+	rollup string
+}
+
+func newRunStats() *runStats {
+	return &runStats{start: time.Now(), extensions: make(map[string]*ExtensionStats)}
+}
+
+func (s *runStats) addFile(osPathname string, size int64) {
+	atomic.AddInt64(&s.files, 1)
+	atomic.AddInt64(&s.bytes, size)
+
+	// A dotfile like .bashrc is all extension as far as filepath.Ext is concerned, but it doesn't really have one
+	ext := strings.ToLower(filepath.Ext(osPathname))
+	if ext == "" || ext == strings.ToLower(filepath.Base(osPathname)) {
+		ext = "(none)"
+	}
+	s.extMu.Lock()
+	defer s.extMu.Unlock()
+	e, ok := s.extensions[ext]
+	if !ok {
+		e = &ExtensionStats{Extension: ext}
+		s.extensions[ext] = e
+	}
+	e.Files++
+	e.Bytes += size
+}
+
+func (s *runStats) addError() {
+	atomic.AddInt64(&s.errors, 1)
+}
+
+func (s *runStats) addSpecial() {
+	atomic.AddInt64(&s.special, 1)
+}
+
+func (s *runStats) addUnchanged() {
+	atomic.AddInt64(&s.unchanged, 1)
+}
+
+// Just the count for Options.CountOnly, without a size there's nothing for the extensions
+func (s *runStats) countFile() {
+	atomic.AddInt64(&s.files, 1)
+}
+
+func (s *runStats) addSymlink() {
+	atomic.AddInt64(&s.symlinks, 1)
+}
+
+// Bumps one of the verify counters
+func (s *runStats) addVerify(counter *int64) {
+	atomic.AddInt64(counter, 1)
+}
+
+// Takes a copy of the counters as they are right now
+func (s *runStats) snapshot() Stats {
+	s.extMu.Lock()
+	extensions := make([]ExtensionStats, 0, len(s.extensions))
+	for _, e := range s.extensions {
+		extensions = append(extensions, *e)
+	}
+	rollup := s.rollup
+	s.extMu.Unlock()
+	// Ties go alphabetically so the order doesn't change from run to run
+	sort.Slice(extensions, func(i, j int) bool {
+		if extensions[i].Bytes != extensions[j].Bytes {
+			return extensions[i].Bytes > extensions[j].Bytes
+		}
+		return extensions[i].Extension < extensions[j].Extension
+	})
+
+	return Stats{
+		Files:     atomic.LoadInt64(&s.files),
+		Bytes:     atomic.LoadInt64(&s.bytes),
+		Errors:    atomic.LoadInt64(&s.errors),
+		Special:   atomic.LoadInt64(&s.special),
+		Unchanged: atomic.LoadInt64(&s.unchanged),
+		Symlinks:  atomic.LoadInt64(&s.symlinks),
+		Elapsed:   time.Since(s.start),
+
+		Mismatched: atomic.LoadInt64(&s.mismatched),
+		Resized:    atomic.LoadInt64(&s.resized),
+		Missing:    atomic.LoadInt64(&s.missing),
+		Added:      atomic.LoadInt64(&s.added),
+
+		Rollup:     rollup,
+		Extensions: extensions,
+	}
+}
+
+func (s *runStats) setRollup(rollup string) {
+	s.extMu.Lock()
+	defer s.extMu.Unlock()
+	s.rollup = rollup
+}
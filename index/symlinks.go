@@ -0,0 +1,72 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// What goes in the Type column for a symlink with Options.RecordSymlinks, a broken one points at something that isn't there
+const (
+	typeSymlink       = "symlink"
+	typeBrokenSymlink = "broken-symlink"
+)
+
+// The record for a symlink with Options.RecordSymlinks, made from the link itself rather than whatever it points at
+// Size and Time are the link's own and the hashes are left empty, there's nothing in a link to hash apart from where it goes
+func symlinkRecord(osPathname string) (record, os.FileInfo, error) {
+	osPathname = longPath(osPathname)
+	info, err := os.Lstat(osPathname)
+	if err != nil {
+		return record{}, nil, err
+	}
+	target, err := os.Readlink(osPathname)
+	if err != nil {
+		return record{}, nil, err
+	}
+	rec := record{Size: info.Size(), ModTime: info.ModTime(), Type: typeSymlink, Target: target}
+	// Stat goes through every link on the way, so it fails if any of them are broken, not just this one
+	if _, err := os.Stat(osPathname); err != nil {
+		rec.Type = typeBrokenSymlink
+	}
+	return rec, info, nil
+}
+
+// Fills in the Type and Target of a file's record when there's a Type column, linkTarget is where the symlink
+// it was found through goes with Options.HashLinkTargets and empty for anything that isn't a link
+func (opts Options) setType(rec *record, linkTarget string) {
+	switch {
+	case linkTarget != "":
+		rec.Type, rec.Target = typeSymlink, linkTarget
+	case opts.RecordSymlinks || opts.HashLinkTargets:
+		rec.Type = typeFile
+	}
+}
+
+// The directories we've already been into when following symlinks, keyed by their real path
+// Without this a link pointing back up the tree would have us walking in circles forever
+type visitedSet struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func newVisitedSet() *visitedSet {
+	return &visitedSet{seen: make(map[string]bool)}
+}
+
+// Returns true the first time it sees a directory and false every time after that
+// The path is resolved first so /a/link and /b, where link points at /b, count as the same directory
+func (v *visitedSet) visit(osPathname string) bool {
+	real, err := filepath.EvalSymlinks(osPathname)
+	if err != nil {
+		// If we can't work out where it goes we let it through, godirwalk will report the error when it tries to read it
+		return true
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.seen[real] {
+		return false
+	}
+	v.seen[real] = true
+	return true
+}
@@ -0,0 +1,160 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A link back up the tree would go round forever without the visited set
+func TestFollowSymlinksLoop(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a/file.txt": "file", "target/real.txt": "real"})
+	if err := os.Symlink(dir, filepath.Join(dir, "a", "loop")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "a", "self"), filepath.Join(dir, "a", "self")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "target"), filepath.Join(dir, "linked")); err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions(dir)
+	opts.LogOutput = ioutil.Discard
+	opts.FollowSymlinks = true
+
+	done := make(chan []string, 1)
+	go func() { done <- indexedPaths(t, opts) }()
+	select {
+	case got := <-done:
+		// Every file only once however many ways there are into it, which way gets there first is up to the walk order
+		viaLink, direct := []string{"a/file.txt", "linked/real.txt"}, []string{"a/file.txt", "target/real.txt"}
+		if !equalStrings(got, viaLink) && !equalStrings(got, direct) {
+			t.Errorf("got %v, want %v or %v", got, viaLink, direct)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("the walk never finished")
+	}
+}
+
+// With ResolveLinks the record has the path the link goes to instead of the link's own
+func TestResolveLinks(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"target/real.txt": "real"})
+	if err := os.Symlink(filepath.Join(dir, "target", "real.txt"), filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions(dir)
+	opts.FollowSymlinks = true
+	opts.Include = []string{"link.txt"}
+	real, _ := filepath.EvalSymlinks(filepath.Join(dir, "target", "real.txt"))
+	for _, resolve := range []bool{false, true} {
+		opts.ResolveLinks = resolve
+		want := filepath.Join(dir, "link.txt")
+		if resolve {
+			want = real
+		}
+		byPath := indexByPath(t, opts)
+		if rec, ok := byPath[want]; !ok || rec["SHA256 Hash"] != sha256Hex("real") {
+			t.Errorf("resolve %v got %v, want a record for %s", resolve, byPath, want)
+		}
+	}
+}
+
+// A link is a row of its own with where it points and no hash, a dangling one the same but marked broken
+func TestRecordSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"real.txt": "real", "sub/inner.txt": "inner"})
+	links := map[string]string{"to-file": "real.txt", "to-dir": "sub", "dangling": filepath.Join(dir, "gone.txt")}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := testOptions(dir)
+	opts.RecordSymlinks = true
+	var errLog bytes.Buffer
+	opts.ErrorLog = &errLog
+	out, stats := runIndex(t, opts)
+	byPath := make(map[string]map[string]string)
+	for _, rec := range parseCSV(t, out) {
+		byPath[rec["Path"]] = rec
+	}
+
+	for name, target := range links {
+		rec := byPath[filepath.Join(dir, name)]
+		want := typeSymlink
+		if name == "dangling" {
+			want = typeBrokenSymlink
+		}
+		if rec["Type"] != want || rec["Target"] != target || rec["SHA256 Hash"] != "" {
+			t.Errorf("%s got %v, want a %s to %s with no hash", name, rec, want, target)
+		}
+	}
+	if rec := byPath[filepath.Join(dir, "real.txt")]; rec["Type"] != typeFile || rec["Target"] != "" || rec["SHA256 Hash"] != sha256Hex("real") {
+		t.Errorf("real.txt got %v", rec)
+	}
+	// The link to sub isn't followed, what's in there is only found the once
+	if _, ok := byPath[filepath.Join(dir, "to-dir", "inner.txt")]; ok || len(byPath) != 5 {
+		t.Errorf("got %d rows, want the two files and three links: %v", len(byPath), byPath)
+	}
+	if stats.Errors != 0 {
+		t.Errorf("got %d errors, a broken link isn't one when it's being recorded:\n%s", stats.Errors, errLog.String())
+	}
+}
+
+// A link is hashed as what it points at but written down under its own path, a dangling one or a loop is an error
+func TestHashLinkTargets(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"real.txt": "real content", "sub/inner.txt": "inner"})
+	real := filepath.Join(dir, "real.txt")
+	for link, target := range map[string]string{"link": real, "chain": "link", "to-dir": "sub", "dangling": "gone", "loop1": "loop2", "loop2": "loop1"} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := testOptions(dir)
+	opts.HashLinkTargets = true
+	var errLog bytes.Buffer
+	opts.ErrorLog = &errLog
+	opts.LogOutput = ioutil.Discard
+	out, stats := runIndex(t, opts)
+	byPath := make(map[string]map[string]string)
+	for _, rec := range parseCSV(t, out) {
+		byPath[rec["Path"]] = rec
+	}
+
+	// EvalSymlinks gives the real path, on a Mac the temp directory is itself behind a link
+	want, err := filepath.EvalSymlinks(real)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []string{"link", "chain"} {
+		rec := byPath[filepath.Join(dir, link)]
+		if rec["SHA256 Hash"] != sha256Hex("real content") || rec["Size"] != "12" || rec["Type"] != typeSymlink || rec["Target"] != want {
+			t.Errorf("%s got %v, want the hash of real.txt and a target of %s", link, rec, want)
+		}
+	}
+	if rec := byPath[real]; rec["Type"] != typeFile || rec["Target"] != "" || rec["SHA256 Hash"] != sha256Hex("real content") {
+		t.Errorf("real.txt got %v", rec)
+	}
+	// The link to a directory isn't walked into
+	if len(byPath) != 4 {
+		t.Errorf("got %d rows, want real.txt, sub/inner.txt and the two links to real.txt: %v", len(byPath), byPath)
+	}
+	if stats.Errors != 3 {
+		t.Errorf("got %d errors, want one each for the dangling link and the two in a loop:\n%s", stats.Errors, errLog.String())
+	}
+	for _, link := range []string{"dangling", "loop1", "loop2"} {
+		if !strings.Contains(errLog.String(), filepath.Join(dir, link)) {
+			t.Errorf("%s isn't in the error log:\n%s", link, errLog.String())
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package index
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The named formats Options.TimeFormat can be, anything else is treated as a Go layout like 2006-01-02
+// go is what time.Time.String() gives you, which is what the Time column has always had in it
+var KnownTimeFormats = []string{"go", "rfc3339", "unix", "unixnano"}
+
+// Any time that isn't Go's own reference time will do here, formatting that one with a layout just gives you the layout back
+var layoutCheckTime = time.Date(2001, time.February, 3, 4, 5, 6, 0, time.UTC)
+
+// Makes sure a time format is either one we know by name or an actual layout
+// Every string is technically a valid layout, but one without a single layout element in it just prints itself
+func checkTimeFormat(format string) error {
+	if format == "" || isNamedTimeFormat(format) {
+		return nil
+	}
+	if layoutCheckTime.Format(format) == format {
+		return fmt.Errorf("unknown time format %q, expected one of %s or a Go layout like 2006-01-02", format, strings.Join(KnownTimeFormats, ", "))
+	}
+	return nil
+}
+
+func isNamedTimeFormat(format string) bool {
+	for _, known := range KnownTimeFormats {
+		if strings.EqualFold(format, known) {
+			return true
+		}
+	}
+	return false
+}
+
+// Turns a modified time into a string using one of KnownTimeFormats or a layout
+// Everything is in UTC so indexes from machines in different timezones line up
+func formatTime(t time.Time, format string) string {
+	t = t.UTC()
+	switch strings.ToLower(format) {
+	case "go":
+		return t.String()
+	case "rfc3339":
+		// The nano version is still RFC 3339, it just doesn't throw away the fraction of a second
+		return t.Format(time.RFC3339Nano)
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "unixnano":
+		return strconv.FormatInt(t.UnixNano(), 10)
+	}
+	return t.Format(format)
+}
+
+// Reads a time from the Time column of an index, whichever of the named formats it was written in
+// Custom layouts can't be read back since we'd have to guess what the layout was
+func parseRecordTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(recordTimeLayout, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		// Seconds won't get anywhere near this until the year 33658, nanoseconds passed it in 1970
+		if n > 1e12 || n < -1e12 {
+			return time.Unix(0, n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("%q isn't a time in any of the %s formats", s, strings.Join(KnownTimeFormats, ", "))
+}
@@ -0,0 +1,50 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Every named format reads back as the same time, to the second for unix since that's all it keeps
+func TestTimeFormatsRoundTrip(t *testing.T) {
+	modTime := time.Date(2021, 4, 1, 10, 30, 15, 123456789, time.FixedZone("CEST", 2*60*60))
+	for _, format := range KnownTimeFormats {
+		want := modTime
+		if format == "unix" {
+			want = modTime.Truncate(time.Second)
+		}
+		s := formatTime(modTime, format)
+		got, err := parseRecordTime(s)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("%s wrote %q and read back %s and %v, want %s", format, s, got, err, want)
+		}
+	}
+	if got := formatTime(modTime, "2006-01-02 15:04"); got != "2021-04-01 08:30" {
+		t.Errorf("a layout got %q, want it in UTC", got)
+	}
+}
+
+// The Time column in the output reads back as the file's modified time, whichever format it was written in
+func TestTimeFormatColumn(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a"})
+	modTime := time.Date(2021, 4, 1, 10, 30, 15, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "a.txt"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range append(KnownTimeFormats, "2006-01-02T15:04:05") {
+		opts := testOptions(dir)
+		opts.TimeFormat = format
+		got := indexByPath(t, opts)[filepath.Join(dir, "a.txt")]["Time"]
+		if want := formatTime(modTime, format); got != want {
+			t.Errorf("%s got %q, want %q", format, got, want)
+		}
+	}
+	opts := testOptions(dir)
+	opts.TimeFormat = "not a layout"
+	if err := opts.Validate(); err == nil {
+		t.Errorf("a time format with nothing to fill in was allowed")
+	}
+}
@@ -0,0 +1,61 @@
+package index
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Reads r until a deadline for Options.FileTimeout, then gives up on it even if a read is stuck part way
+// A read from a regular file can't be interrupted, so every read happens on a goroutine of its own that we stop waiting for
+// once the time is up. That goroutine keeps the buffer it was reading into, ours goes back to the pool untouched,
+// and it finishes whenever the read does, which on a mount that's gone away could be never
+type timeoutReader struct {
+	r        io.Reader
+	deadline time.Time
+	err      error
+	// What the goroutine reads into, it becomes the goroutine's if we give up on it
+	buf     []byte
+	results chan readResult
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+func newTimeoutReader(r io.Reader, timeout time.Duration) *timeoutReader {
+	return &timeoutReader{
+		r:        r,
+		deadline: time.Now().Add(timeout),
+		err:      fmt.Errorf("gave up reading the file after %s", timeout),
+		// Buffered so a read we've stopped waiting for can still finish and let its goroutine go
+		results: make(chan readResult, 1),
+	}
+}
+
+func (t *timeoutReader) Read(p []byte) (int, error) {
+	// Once we've given up on one read we're past the deadline, so there's never two going at once
+	remaining := time.Until(t.deadline)
+	if remaining <= 0 {
+		return 0, t.err
+	}
+	if len(t.buf) < len(p) {
+		t.buf = make([]byte, len(p))
+	}
+	buf := t.buf[:len(p)]
+	go func() {
+		n, err := t.r.Read(buf)
+		t.results <- readResult{n: n, err: err}
+	}()
+
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+	select {
+	case res := <-t.results:
+		return copy(p, buf[:res.n]), res.err
+	case <-timer.C:
+		t.buf = nil
+		return 0, t.err
+	}
+}
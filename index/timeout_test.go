@@ -0,0 +1,75 @@
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A reader slower than the timeout gets given up on with an error, one that's quick enough reads like it normally would
+func TestTimeoutReader(t *testing.T) {
+	r := newTimeoutReader(&slowReader{data: []byte("abcd"), delay: time.Second}, 100*time.Millisecond)
+	start := time.Now()
+	_, err := ioutil.ReadAll(r)
+	if err == nil || !strings.Contains(err.Error(), "gave up") {
+		t.Errorf("got %v, want it to give up", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("took %s to give up on a 100ms timeout", elapsed)
+	}
+
+	r = newTimeoutReader(&slowReader{data: []byte("abcd"), delay: time.Millisecond}, time.Second)
+	if data, err := ioutil.ReadAll(r); err != nil || string(data) != "abcd" {
+		t.Errorf("got %q and %v from a reader inside the timeout", data, err)
+	}
+}
+
+// A file that never gives us anything is reported as failed after the timeout, and the hasher goes on to the next file
+// The read end of a pipe nobody writes to is an *os.File that blocks forever, like a read from a mount that's gone away
+func TestFileTimeout(t *testing.T) {
+	stuck, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	defer stuck.Close()
+	fine := filepath.Join(t.TempDir(), "fine.txt")
+	if err := ioutil.WriteFile(fine, []byte("fine"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	results := make(map[string]error)
+	hashed := make(map[string]record)
+	// One hasher, so the second file can only be done once the first has been given up on
+	hs := newHashStage(1, 4096, []string{"sha256"}, "", false, 0, 100*time.Millisecond, 0, 0, 0, func(osPathname string, rec record, elapsed time.Duration, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[osPathname] = err
+		hashed[osPathname] = rec
+	})
+	start := time.Now()
+	hs.submit("stuck", stuck, record{}, false, false)
+	f, err := os.Open(fine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	hs.submit(fine, f, record{Size: info.Size(), ModTime: info.ModTime()}, false, false)
+	hs.close()
+
+	if err := results["stuck"]; err == nil || !strings.Contains(err.Error(), "gave up") {
+		t.Errorf("the stuck file got %v, want it to time out", err)
+	}
+	if err, ok := results[fine]; !ok || err != nil || hashed[fine].Hashes[0] != sha256Hex("fine") {
+		t.Errorf("the file after it got %v and %v", hashed[fine], err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %s for a 100ms timeout", elapsed)
+	}
+}
@@ -0,0 +1,67 @@
+package index
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Hands out a few bytes at a time with a pause before each, like a disk that's struggling
+type slowReader struct {
+	data  []byte
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	n := copy(p[:1], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestTimingCountsSlowReads(t *testing.T) {
+	var got time.Duration
+	hs := newHashStage(1, 4096, []string{"sha256"}, "", false, 0, 0, 0, 0, 0, func(osPathname string, rec record, elapsed time.Duration, err error) {
+		if err != nil {
+			t.Errorf("got %s", err)
+		}
+		got = elapsed
+	})
+	hs.submitSpecial("slow", &slowReader{data: []byte("abcd"), delay: 50 * time.Millisecond}, record{})
+	hs.close()
+	if got < 200*time.Millisecond {
+		t.Errorf("four reads 50ms apart took %s, want at least 200ms", got)
+	}
+}
+
+func TestTimingColumn(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "hello"})
+	opts := testOptions(dir)
+	opts.Timing = true
+
+	rec := indexByPath(t, opts)[filepath.Join(dir, "a.txt")]
+	ms, err := strconv.ParseFloat(rec["HashMs"], 64)
+	if err != nil || ms < 0 {
+		t.Errorf("got HashMs %q, want a number of milliseconds", rec["HashMs"])
+	}
+
+	opts.Format = "json"
+	out, _ := runIndex(t, opts)
+	var records []map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &records); err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+	// It's left out when the file took under a microsecond, but when it's there it's a number rather than a string
+	if v, ok := records[0]["hashMs"]; ok {
+		if _, number := v.(float64); !number {
+			t.Errorf("got hashMs %#v, want a number", v)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import "syscall"
+
+// The errors a network filesystem like NFS or SMB gives you when it's having a bad moment
+// ESTALE is NFS saying its handle for the file went away, opening it again gets a new one
+var transientErrors = []error{
+	syscall.EIO,
+	syscall.ETIMEDOUT,
+	syscall.EAGAIN,
+	syscall.EINTR,
+	syscall.ECONNRESET,
+	syscall.ECONNABORTED,
+	syscall.EHOSTUNREACH,
+	syscall.ENETUNREACH,
+	syscall.ESTALE,
+}
@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package index
+
+import "syscall"
+
+// The errors Windows gives you when a share drops out for a moment, syscall doesn't have names for most of these
+var transientErrors = []error{
+	syscall.Errno(59),   // ERROR_UNEXP_NET_ERR
+	syscall.Errno(64),   // ERROR_NETNAME_DELETED
+	syscall.Errno(121),  // ERROR_SEM_TIMEOUT
+	syscall.Errno(1231), // ERROR_NETWORK_UNREACHABLE
+}
@@ -0,0 +1,68 @@
+package index
+
+import "sync"
+
+// A recordWriter for Options.Update, the records for the listed files go straight through and every record
+// in the index that none of them replaced goes in after them in Flush, so the output is the whole index with just those rows new.
+// It writes into a sortingWriter so everything comes out in path order, the old rows and new ones all mixed in together
+type updateWriter struct {
+	rw        recordWriter
+	old       *IndexFile
+	hashNames []string
+	encoding  string
+	// The record path of every file that got a new row
+	updated map[string]bool
+	// The record path of every listed file that's been deleted, it's filled in from the path list while the records come in
+	// on the writer goroutine so it has its own lock
+	mu      sync.Mutex
+	removed map[string]bool
+}
+
+func newUpdateWriter(rw recordWriter, old *IndexFile, hashNames []string, encoding string, tempDir string) *updateWriter {
+	return &updateWriter{
+		rw:        newSortingWriter(rw, recordLess, tempDir),
+		old:       old,
+		hashNames: hashNames,
+		encoding:  encoding,
+		updated:   make(map[string]bool),
+		removed:   make(map[string]bool),
+	}
+}
+
+func (u *updateWriter) WriteHeader() error {
+	return u.rw.WriteHeader()
+}
+
+// A path that's in the list twice gets hashed twice, only the first one is kept so it's still one row per file
+func (u *updateWriter) WriteRecord(r record) error {
+	if u.updated[r.Path] {
+		return nil
+	}
+	u.updated[r.Path] = true
+	return u.rw.WriteRecord(r)
+}
+
+// Drops the old row for a listed file that isn't there any more
+func (u *updateWriter) remove(path string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.removed[path] = true
+}
+
+// A listed file that couldn't be read never got a new row, so it keeps its old one and it's in the error log.
+// One that was deleted is left out, that's logged too so nothing is taken out of the index without you seeing why
+func (u *updateWriter) Flush() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for path, rec := range u.old.records {
+		if u.updated[path] || u.removed[path] {
+			continue
+		}
+		// Validate made sure the index has every hash we'd write, they come back in our encoding
+		rec.Hashes, _ = u.old.hashesFor(rec, u.hashNames, u.encoding)
+		if err := u.rw.WriteRecord(rec); err != nil {
+			return err
+		}
+	}
+	return u.rw.Flush()
+}
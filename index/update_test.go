@@ -0,0 +1,58 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpdateListedPaths(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+	idx := saveIndex(t, dir)
+	before := indexByPath(t, testOptions(dir))
+	writeTree(t, dir, map[string]string{"a.txt": "new a", "b.txt": "new b", "c.txt": "new c"})
+
+	// c.txt changed as well but it isn't on the list, so it keeps its old row
+	opts := testOptions(dir)
+	opts.Update = idx
+	opts.PathList = strings.NewReader(filepath.Join(dir, "a.txt") + "\n" + filepath.Join(dir, "b.txt") + "\n")
+	after := indexByPath(t, opts)
+	if len(after) != 3 {
+		t.Fatalf("got %d rows, want 3", len(after))
+	}
+	for name, want := range map[string]string{"a.txt": sha256Hex("new a"), "b.txt": sha256Hex("new b"), "c.txt": sha256Hex("c")} {
+		if got := after[filepath.Join(dir, name)]["SHA256 Hash"]; got != want {
+			t.Errorf("%s got %s, want %s", name, got, want)
+		}
+	}
+	if c := filepath.Join(dir, "c.txt"); after[c]["Time"] != before[c]["Time"] || after[c]["Size"] != before[c]["Size"] {
+		t.Errorf("c.txt's row changed from %v to %v", before[c], after[c])
+	}
+}
+
+// A listed file that's been deleted takes its row with it, one that's listed and still there is as good as new
+func TestUpdateDeletedPath(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+	idx := saveIndex(t, dir)
+	if err := os.Remove(filepath.Join(dir, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := testOptions(dir)
+	opts.Update = idx
+	opts.PathList = strings.NewReader(filepath.Join(dir, "a.txt") + "\n" + filepath.Join(dir, "b.txt") + "\n")
+	out, stats := runIndex(t, opts)
+	var paths []string
+	for _, rec := range parseCSV(t, out) {
+		paths = append(paths, filepath.Base(rec["Path"]))
+	}
+	if !equalStrings(paths, []string{"a.txt", "c.txt"}) {
+		t.Errorf("got %v, want b.txt gone", paths)
+	}
+	if stats.Errors != 0 {
+		t.Errorf("a deleted file counted as %d errors", stats.Errors)
+	}
+}
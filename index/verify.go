@@ -0,0 +1,129 @@
+package index
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// A recordWriter for Options.Verify, instead of writing the records out it checks each one against the index
+// Mismatches and new files get reported as they come in, whatever is left in the index once the walk is done is missing.
+// Index hands it an error record for everything that couldn't be read, so a file that's there but won't open or a directory
+// we couldn't get into comes out as unreadable instead of missing
+type verifyWriter struct {
+	w         io.Writer
+	expected  *IndexFile
+	hashNames []string
+	encoding  string
+	seen      map[string]bool
+	// Record paths that failed to read, files or directories
+	unreadable map[string]bool
+	stats      *runStats
+	// Options.VerifyLive, nil if nobody's watching
+	live io.Writer
+}
+
+func newVerifyWriter(w io.Writer, expected *IndexFile, hashNames []string, encoding string, stats *runStats, live io.Writer) *verifyWriter {
+	return &verifyWriter{
+		w:          w,
+		expected:   expected,
+		hashNames:  hashNames,
+		encoding:   encoding,
+		seen:       make(map[string]bool),
+		unreadable: make(map[string]bool),
+		stats:      stats,
+		live:       live,
+	}
+}
+
+// Every line of the report goes through here so it can go to VerifyLive as well, straight away rather than when
+// the report gets flushed at the end. Only the writer goroutine calls this, so the lines never end up mixed together
+func (v *verifyWriter) report(format string, args ...interface{}) error {
+	line := fmt.Sprintf(format, args...)
+	if v.live != nil {
+		io.WriteString(v.live, line)
+	}
+	_, err := io.WriteString(v.w, line)
+	return err
+}
+
+// There's no header for the report, every line says what it is
+func (v *verifyWriter) WriteHeader() error {
+	return nil
+}
+
+func (v *verifyWriter) WriteRecord(r record) error {
+	// It's already in the error log, all that matters here is not calling it missing
+	if r.Error != "" {
+		v.unreadable[r.Path] = true
+		return nil
+	}
+	v.seen[r.Path] = true
+	old, ok := v.expected.records[r.Path]
+	if !ok {
+		v.stats.addVerify(&v.stats.added)
+		return v.report("NEW       %s\n", r.Path)
+	}
+	// A different size is bound to be a different hash, but it's worth knowing it's not just the contents that changed
+	// An index without a Size column has -1 for every file and only gets the hashes checked
+	if old.Size >= 0 && old.Size != r.Size {
+		v.stats.addVerify(&v.stats.resized)
+		return v.report("SIZE      %s (expected %d bytes, got %d)\n", r.Path, old.Size, r.Size)
+	}
+	// Validate already made sure the index has every hash we're computing
+	// They come back in the same encoding as ours, so the index we're checking against can have been written with a different one
+	want, _ := v.expected.hashesFor(old, v.hashNames, v.encoding)
+	for i, name := range v.hashNames {
+		if want[i] != r.Hashes[i] {
+			v.stats.addVerify(&v.stats.mismatched)
+			return v.report("MISMATCH  %s (%s expected %s, got %s)\n", r.Path, name, want[i], r.Hashes[i])
+		}
+	}
+	return nil
+}
+
+// Anything in the index that the walk never came across is missing, unless it couldn't be read. They're sorted so the report is easy to read
+// VerifyLive gets the totals at the end, missing files can't be known about until now so they all come at once.
+// Unreadable files aren't counted as missing, the error log and Stats.Errors already have them
+func (v *verifyWriter) Flush() error {
+	var missing []string
+	for path := range v.expected.records {
+		if !v.seen[path] {
+			missing = append(missing, path)
+		}
+	}
+	sort.Strings(missing)
+	for _, path := range missing {
+		if v.isUnreadable(path) {
+			if err := v.report("UNREADABLE %s\n", path); err != nil {
+				return err
+			}
+			continue
+		}
+		v.stats.addVerify(&v.stats.missing)
+		if err := v.report("MISSING   %s\n", path); err != nil {
+			return err
+		}
+	}
+	if v.live != nil {
+		stats := v.stats.snapshot()
+		fmt.Fprintf(v.live, "Verified: %d mismatched, %d a different size, %d missing, %d new\n", stats.Mismatched, stats.Resized, stats.Missing, stats.Added)
+	}
+	return nil
+}
+
+// A file under a directory the walk couldn't get into is just as unreadable as one that wouldn't open
+func (v *verifyWriter) isUnreadable(path string) bool {
+	for len(v.unreadable) > 0 {
+		if v.unreadable[path] {
+			return true
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return false
+		}
+		path = parent
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package index
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestVerifyLiveReport(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"same.txt": "same", "changed.txt": "aaaa", "resized.txt": "short", "gone.txt": "gone"})
+	idx := saveIndex(t, dir)
+	writeTree(t, dir, map[string]string{"changed.txt": "bbbb", "resized.txt": "a good deal longer", "new.txt": "new"})
+	if err := os.Remove(filepath.Join(dir, "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := testOptions(dir)
+	opts.Verify = idx
+	var live bytes.Buffer
+	opts.VerifyLive = &live
+	report, stats := runIndex(t, opts)
+	if stats.Mismatched != 1 || stats.Resized != 1 || stats.Missing != 1 || stats.Added != 1 {
+		t.Errorf("got %d mismatched, %d resized, %d missing and %d new, want one of each", stats.Mismatched, stats.Resized, stats.Missing, stats.Added)
+	}
+	lines := strings.Split(strings.TrimSpace(live.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("got %q, want four problems and the totals", live.String())
+	}
+	for _, want := range []string{"MISMATCH  " + filepath.Join(dir, "changed.txt"), "SIZE      " + filepath.Join(dir, "resized.txt"), "MISSING   " + filepath.Join(dir, "gone.txt"), "NEW       " + filepath.Join(dir, "new.txt")} {
+		if !strings.Contains(live.String(), want) || !strings.Contains(report, want) {
+			t.Errorf("no %q in the live report or the report", want)
+		}
+	}
+	if lines[4] != "Verified: 1 mismatched, 1 a different size, 1 missing, 1 new" {
+		t.Errorf("the report ended with %q", lines[4])
+	}
+}
+
+// The line goes out as soon as the record is checked, not when the report is flushed at the end
+func TestVerifyLiveAsFound(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "aaaa"})
+	idx := saveIndex(t, dir)
+
+	var live bytes.Buffer
+	v := newVerifyWriter(ioutil.Discard, idx, []string{"sha256"}, "", newRunStats(), &live)
+	if err := v.WriteRecord(record{Path: filepath.Join(dir, "a.txt"), Size: 4, Hashes: []string{sha256Hex("bbbb")}}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(live.String(), "MISMATCH  "+filepath.Join(dir, "a.txt")) {
+		t.Errorf("got %q before the flush, want the mismatch", live.String())
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "original", "b.txt": "untouched"})
+	idx := saveIndex(t, dir)
+
+	opts := testOptions(dir)
+	opts.Verify = idx
+	if report, stats := runIndex(t, opts); report != "" || stats.Mismatched+stats.Resized+stats.Missing+stats.Added != 0 {
+		t.Errorf("an untouched tree got %q", report)
+	}
+
+	// The same size, so only the hash gives it away
+	writeTree(t, dir, map[string]string{"a.txt": "0riginal"})
+	report, stats := runIndex(t, opts)
+	if stats.Mismatched != 1 || !strings.HasPrefix(report, "MISMATCH  "+filepath.Join(dir, "a.txt")+" (sha256 expected "+sha256Hex("original")) {
+		t.Errorf("got %d mismatched and %q", stats.Mismatched, report)
+	}
+}
+
+// A file that's still there but won't open isn't missing, and nor is anything in a directory we can't get into
+func TestVerifyUnreadable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions don't stop a file being read on Windows")
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("root can read a file whatever its permissions")
+	}
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "locked.txt": "locked", "shut/in.txt": "in", "shut/deeper/too.txt": "too", "gone.txt": "gone"})
+	idx := saveIndex(t, dir)
+	os.Remove(filepath.Join(dir, "gone.txt"))
+	for _, name := range []string{"locked.txt", "shut"} {
+		locked := filepath.Join(dir, name)
+		if err := os.Chmod(locked, 0); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(locked, 0755)
+	}
+
+	opts := testOptions(dir)
+	opts.Verify = idx
+	opts.LogOutput = ioutil.Discard
+	report, stats := runIndex(t, opts)
+	if stats.Missing != 1 || stats.Mismatched != 0 || stats.Added != 0 || stats.Errors != 2 {
+		t.Errorf("got %d missing, %d mismatched, %d new and %d errors, want gone.txt missing and two errors", stats.Missing, stats.Mismatched, stats.Added, stats.Errors)
+	}
+	want := []string{
+		"MISSING   " + filepath.Join(dir, "gone.txt"),
+		"UNREADABLE " + filepath.Join(dir, "locked.txt"),
+		"UNREADABLE " + filepath.Join(dir, "shut", "deeper", "too.txt"),
+		"UNREADABLE " + filepath.Join(dir, "shut", "in.txt"),
+	}
+	if got := strings.Split(strings.TrimSpace(report), "\n"); !equalStrings(got, want) {
+		t.Errorf("got the report\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,449 @@
+package index
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/karrick/godirwalk"
+)
+
+// How long a file has to be left alone before we hash it again
+// Saving a file in most editors is a handful of writes and renames, this lets all of them land before we look at it
+const watchSettle = 500 * time.Millisecond
+
+// Something that never stops writing would keep pushing watchSettle back forever, so we never wait longer than this
+const watchMaxWait = 5 * time.Second
+
+// A recordWriter that just keeps everything in memory, keyed by the path in the record
+// Watch uses it to hold the index it keeps rewriting
+type memoryWriter struct {
+	records map[string]record
+}
+
+func newMemoryWriter() *memoryWriter {
+	return &memoryWriter{records: make(map[string]record)}
+}
+
+func (m *memoryWriter) WriteHeader() error {
+	return nil
+}
+
+func (m *memoryWriter) WriteRecord(r record) error {
+	m.records[r.Path] = r
+	return nil
+}
+
+func (m *memoryWriter) Flush() error {
+	return nil
+}
+
+// The formats Watch can write out again after every batch of changes, everything but sqlite, which Index writes
+// straight into the database instead of through a recordWriter, and parquet, which isn't supported yet
+func watchFormats() []string {
+	var formats []string
+	for _, format := range KnownFormats {
+		if format != "sqlite" && format != "parquet" {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+func isWatchFormat(format string) bool {
+	for _, known := range watchFormats() {
+		if strings.EqualFold(format, known) {
+			return true
+		}
+	}
+	return false
+}
+
+// Indexes opts.WalkDir like Index and then keeps running, re-hashing files as they're created or changed and dropping them
+// from the index when they're deleted. After every batch of changes the whole index is written to output again,
+// through a temporary file that gets renamed over it so anything reading output never sees half an index.
+// Every change gets a line in changes, one of ADDED, CHANGED or REMOVED followed by the path.
+// It runs until ctx is cancelled, then writes the index one last time and returns nil.
+// Every format in watchFormats can be watched, and the options that don't make sense for a changing tree are turned down
+func Watch(ctx context.Context, opts Options, output string, changes io.Writer) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	switch {
+	case !isWatchFormat(opts.Format):
+		return fmt.Errorf("watch can only write %s", strings.Join(watchFormats(), ", "))
+	case opts.Dupes || opts.Verify != nil || opts.DirHashes || opts.Chunks || opts.SinceIndex != nil || opts.Rollup:
+		return fmt.Errorf("watch can't be used with dupes, verify, dir hashes, chunks, since index or rollup")
+	case opts.FollowSymlinks || opts.ResolveLinks:
+		return fmt.Errorf("watch can't follow symlinks, the file we'd need to watch isn't in the tree")
+	case opts.RecordSymlinks:
+		return fmt.Errorf("watch doesn't support recording symlinks yet")
+	case opts.HashLinkTargets:
+		return fmt.Errorf("watch doesn't support hashing link targets yet")
+	case opts.ErrorsInline:
+		return fmt.Errorf("watch doesn't support errors inline yet")
+	case opts.GitIgnore:
+		return fmt.Errorf("watch doesn't support gitignore yet")
+	case len(opts.Roots) > 0 || opts.PathList != nil:
+		return fmt.Errorf("watch only works with one root and no path list")
+	case opts.Checkpoint > 0:
+		return fmt.Errorf("watch rewrites the whole output every time, there's nothing to checkpoint")
+	case opts.BytesLimit > 0 || opts.FileLimit > 0:
+		return fmt.Errorf("watch needs the whole tree indexed, it can't stop at a bytes or file limit")
+	}
+
+	stats := newRunStats()
+	logger := opts.logger()
+	errs := newErrorLog(opts.ErrorLog, stats, logger, opts.OnError)
+	defer errs.flush()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	filter, _ := newFileFilter(opts)
+	w := &watchState{
+		opts:    opts,
+		root:    filepath.Clean(opts.WalkDir),
+		output:  output,
+		filter:  filter,
+		watcher: watcher,
+		mem:     newMemoryWriter(),
+		errs:    errs,
+		changes: changes,
+		buf:     make([]byte, opts.BufferSize),
+		limiter: newRateLimiter(opts.RateLimit),
+	}
+
+	// Start watching before the first walk, anything that changes while it's running then gets picked up afterwards
+	w.scan(w.root, false)
+	if err := walk(ctx, opts, w.mem, nil, stats, errs, logger); err != nil && ctx.Err() == nil {
+		return err
+	}
+	if err := w.writeOut(); err != nil {
+		return err
+	}
+
+	pending := make(map[string]bool)
+	var deadline time.Time
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Whatever changed just before we were stopped still gets looked at, then the index is written one last time
+			w.apply(pending)
+			return w.writeOut()
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return w.writeOut()
+			}
+			// A change of permissions doesn't change what's in the file, and our own writes don't count either
+			if event.Op == fsnotify.Chmod || w.isOutput(event.Name) {
+				continue
+			}
+			if len(pending) == 0 {
+				deadline = time.Now().Add(watchMaxWait)
+			}
+			pending[event.Name] = true
+
+			wait := watchSettle
+			if untilDeadline := time.Until(deadline); untilDeadline < wait {
+				wait = untilDeadline
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+
+		case err, ok := <-watcher.Errors:
+			if ok {
+				errs.add(w.root, err)
+			}
+
+		case <-timer.C:
+			if w.apply(pending) {
+				if err := w.writeOut(); err != nil {
+					return err
+				}
+			}
+			pending = make(map[string]bool)
+		}
+	}
+}
+
+// Everything Watch needs to keep track of between changes
+// It's all only ever used from the one goroutine running Watch, so there's no locking
+type watchState struct {
+	opts    Options
+	root    string
+	output  string
+	filter  *fileFilter
+	watcher *fsnotify.Watcher
+	mem     *memoryWriter
+	errs    *errorLog
+	changes io.Writer
+	// Files get hashed one at a time here, so one buffer is all we need
+	buf []byte
+	// Options.RateLimit for the files hashed after the first walk, that one has its own and it's done before these start
+	limiter *rateLimiter
+}
+
+// Options.HeadBytes, the same as the first walk does it
+func (w *watchState) head(f io.Reader) io.Reader {
+	if w.opts.HeadBytes <= 0 {
+		return f
+	}
+	return io.LimitReader(f, w.opts.HeadBytes)
+}
+
+// Our own output is probably somewhere in the tree being watched, writing it mustn't look like a change
+// Both sides get made absolute first since WalkDir and output don't have to have been given the same way
+func (w *watchState) isOutput(osPathname string) bool {
+	abs, err := filepath.Abs(osPathname)
+	if err != nil {
+		return false
+	}
+	output, err := filepath.Abs(w.output)
+	if err != nil {
+		return false
+	}
+	return abs == output || (filepath.Dir(abs) == filepath.Dir(output) && strings.HasPrefix(filepath.Base(abs), "."+filepath.Base(output)+".tmp"))
+}
+
+// Same checks the walk makes on a directory before going into it
+func (w *watchState) wantDir(osPathname string, de *godirwalk.Dirent) bool {
+	if osPathname == w.root {
+		return true
+	}
+	if w.opts.SkipHidden && isHidden(osPathname, de) {
+		return false
+	}
+	if w.filter.skipDir(osPathname) {
+		return false
+	}
+	return w.opts.MaxDepth < 0 || pathDepth(w.root, osPathname) < w.opts.MaxDepth
+}
+
+// Same checks the walk makes on a file before hashing it
+func (w *watchState) wantFile(osPathname string, de *godirwalk.Dirent, info os.FileInfo) bool {
+	if w.opts.SkipHidden && osPathname != w.root && isHidden(osPathname, de) {
+		return false
+	}
+	if !w.filter.match(osPathname) {
+		return false
+	}
+	if info.Size() < w.opts.MinSize || (w.opts.MaxSize >= 0 && info.Size() > w.opts.MaxSize) {
+		return false
+	}
+	return !info.ModTime().Before(w.opts.ModifiedSince)
+}
+
+// Watches dir and every directory under it that the walk would have gone into
+// fsnotify only tells us about the directory we give it and not what's below, so every one needs adding separately
+// When hashFiles is set the files we come across get hashed too, that's for directories that show up after the first walk
+func (w *watchState) scan(dir string, hashFiles bool) bool {
+	changed := false
+	godirwalk.Walk(dir, &godirwalk.Options{
+		Callback: func(osPathname string, de *godirwalk.Dirent) error {
+			if de.IsDir() {
+				if !w.wantDir(osPathname, de) {
+					return godirwalk.SkipThis
+				}
+				if err := w.watcher.Add(osPathname); err != nil {
+					w.errs.add(osPathname, err)
+				}
+				return nil
+			}
+			if hashFiles && de.IsRegular() && w.update(osPathname, de) {
+				changed = true
+			}
+			return nil
+		},
+		ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
+			w.errs.add(osPathname, err)
+			return godirwalk.SkipNode
+		},
+		Unsorted:          true,
+		AllowNonDirectory: true,
+	})
+	return changed
+}
+
+// Looks at every path that had something happen to it and brings the index up to date
+// Returns true if anything in the index actually changed
+func (w *watchState) apply(pending map[string]bool) bool {
+	paths := make([]string, 0, len(pending))
+	for p := range pending {
+		paths = append(paths, p)
+	}
+	// Sorted so the changes come out in a sensible order, and a directory is looked at before anything in it
+	sort.Strings(paths)
+
+	changed := false
+	for _, osPathname := range paths {
+		de, err := godirwalk.NewDirent(osPathname)
+		if err != nil {
+			if os.IsNotExist(err) {
+				if w.remove(osPathname) {
+					changed = true
+				}
+				continue
+			}
+			w.errs.add(osPathname, err)
+			continue
+		}
+		if de.IsDir() {
+			// A new directory, or one that was moved in from somewhere else, needs watching and everything in it hashed
+			if w.wantDir(osPathname, de) && w.scan(osPathname, true) {
+				changed = true
+			}
+			continue
+		}
+		if de.IsRegular() && w.update(osPathname, de) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Hashes a file again if it's new or its size or modified time changed, returns true if the index changed
+func (w *watchState) update(osPathname string, de *godirwalk.Dirent) bool {
+	f, err := os.Open(longPath(osPathname))
+	if err != nil {
+		// It was probably deleted again before we got to it, in which case there'll be another event for that
+		if !os.IsNotExist(err) {
+			w.errs.add(osPathname, err)
+		}
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		w.errs.add(osPathname, err)
+		return false
+	}
+	if !w.wantFile(osPathname, de, info) {
+		return false
+	}
+
+	recordPath := w.opts.recordPath(w.root, osPathname)
+	old, known := w.mem.records[recordPath]
+	if known && old.Size == info.Size() && old.ModTime.Equal(info.ModTime()) {
+		return false
+	}
+
+	rec := record{Path: recordPath, Hashes: make([]string, len(w.opts.Hashes)), Size: info.Size(), ModTime: info.ModTime()}
+	if w.opts.Permissions {
+		fillPermissions(&rec, info)
+	}
+	rec.Extra = w.opts.extraValues(osPathname, info)
+	if !w.opts.StatOnly {
+		h := newMultiHasher(w.opts.Hashes, w.opts.HashEncoding)
+		sniffer := &typeSniffer{}
+		start := time.Now()
+		if _, err := io.CopyBuffer(io.MultiWriter(h, sniffer), w.limiter.reader(w.head(f)), w.buf); err != nil {
+			w.errs.add(osPathname, err)
+			return false
+		}
+		if w.opts.Timing {
+			rec.HashTime = time.Since(start)
+		}
+		rec.Hashes = h.sums()
+		if w.opts.DetectType {
+			rec.Mime = sniffer.contentType()
+		}
+	}
+	w.mem.records[recordPath] = rec
+	if w.opts.OnFileHashed != nil {
+		w.opts.OnFileHashed(osPathname, rec.Size)
+	}
+
+	// Touching a file without changing it still gets the new time written, it just isn't worth telling anybody about
+	switch {
+	case !known:
+		fmt.Fprintf(w.changes, "ADDED     %s\n", recordPath)
+	case strings.Join(old.Hashes, ",") != strings.Join(rec.Hashes, ",") || old.Size != rec.Size:
+		fmt.Fprintf(w.changes, "CHANGED   %s\n", recordPath)
+	}
+	return true
+}
+
+// Drops a deleted file from the index, or everything that was under a deleted directory
+func (w *watchState) remove(osPathname string) bool {
+	recordPath := w.opts.recordPath(w.root, osPathname)
+	var removed []string
+	for p := range w.mem.records {
+		if p == recordPath || strings.HasPrefix(p, recordPath+string(filepath.Separator)) {
+			removed = append(removed, p)
+		}
+	}
+	sort.Strings(removed)
+	for _, p := range removed {
+		delete(w.mem.records, p)
+		fmt.Fprintf(w.changes, "REMOVED   %s\n", p)
+	}
+	return len(removed) > 0
+}
+
+// Writes the whole index out again, sorted by path so the file only changes where the tree did
+func (w *watchState) writeOut() error {
+	dir, base := filepath.Split(w.output)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	// Does nothing once the rename has happened, but cleans up after us if something goes wrong before then
+	defer os.Remove(tmp.Name())
+	// TempFile makes files only we can read, the index should end up like any other file we write
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	buffered := bufio.NewWriter(tmp)
+	rw, _ := newRecordWriter(w.opts, buffered)
+	paths := make([]string, 0, len(w.mem.records))
+	for p := range w.mem.records {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	err = rw.WriteHeader()
+	for _, p := range paths {
+		if err != nil {
+			break
+		}
+		err = rw.WriteRecord(w.mem.records[p])
+	}
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), w.output)
+}
@@ -0,0 +1,77 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Runs Watch until output has been written once, then stops it and hands back what the last write left there
+func watchOnce(t *testing.T, opts Options, output string) string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- Watch(ctx, opts, output, ioutil.Discard) }()
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(output); err == nil {
+			break
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("Watch stopped before writing anything: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Watch didn't write %s", output)
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Watch got %s", err)
+	}
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Every format it says it can write comes out whole, the ones it can't are turned down with the list of the ones it can
+func TestWatchFormats(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "hello"})
+	if got := strings.Join(watchFormats(), ","); got != "csv,tsv,ndjson,json,xml,sql" {
+		t.Errorf("got watch formats %s", got)
+	}
+	for _, format := range watchFormats() {
+		opts := testOptions(dir)
+		opts.Format = format
+		opts.LogOutput = ioutil.Discard
+		output := filepath.Join(t.TempDir(), "index."+format)
+		out := watchOnce(t, opts, output)
+
+		// The same as a one off run of the same format
+		want, _ := runIndex(t, opts)
+		if out != want {
+			t.Errorf("%s: watch wrote\n%s\nIndex wrote\n%s", format, out, want)
+		}
+		if !strings.Contains(out, sha256Hex("hello")) {
+			t.Errorf("%s: no hash for a.txt in\n%s", format, out)
+		}
+	}
+
+	for _, format := range []string{"sqlite", "parquet", "SQLite"} {
+		opts := testOptions(dir)
+		opts.Format = format
+		err := Watch(context.Background(), opts, filepath.Join(t.TempDir(), "index"), &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), "csv, tsv, ndjson, json, xml, sql") {
+			t.Errorf("%s got %v, want it turned down with the formats that can be watched", format, err)
+		}
+	}
+}
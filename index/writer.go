@@ -0,0 +1,569 @@
+package index
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Everything we found out about a single file
+type record struct {
+	Path string
+	// Which root Path is relative to, only filled in with Options.Relative and more than one root
+	Root string
+	// One entry per algorithm, in the same order as Options.Hashes
+	Hashes  []string
+	Size    int64
+	ModTime time.Time
+	// Where in the file this record starts, only set on the per chunk records Options.Chunks writes
+	Offset int64
+	// Every chunk of the file with Options.Chunks, it gets written as one record per chunk instead of one for the whole file
+	Chunks []fileChunk
+	// Only filled in with Options.DetectType
+	Mime string
+	// file or dir with Options.DirHashes, file, symlink or broken-symlink with Options.RecordSymlinks,
+	// file or symlink with Options.HashLinkTargets
+	Type string
+	// Where a symlink points, exactly as the link has it with Options.RecordSymlinks and resolved all the way down
+	// to the file with Options.HashLinkTargets
+	Target string
+	// Who owns the file and its permissions like -rw-r--r--, only filled in with Options.Permissions
+	Owner string
+	Group string
+	Mode  string
+	// added or changed, only filled in with Options.SinceIndex
+	Change string
+	// How long reading and hashing the file took, only filled in with Options.Timing
+	HashTime time.Duration
+	// Why the file couldn't be hashed, only filled in with Options.ErrorsInline
+	Error string
+	// One value per Options.ExtraColumns, in the same order
+	Extra []string
+}
+
+// Whether the hash stage got as far as hashing the file, a chunked file gets its hashes in Chunks instead
+func (r record) hashed() bool {
+	return r.Hashes != nil || r.Chunks != nil
+}
+
+// Anything that knows how to turn our results into some output format
+// Adding a new format is just a matter of writing another one of these
+type recordWriter interface {
+	// Written once before any records, formats without a header can just do nothing
+	WriteHeader() error
+	WriteRecord(r record) error
+	// Pushes out anything the writer is holding on to, call it once you're done writing
+	Flush() error
+}
+
+// A recordWriter that can push out everything written so far part way through a run, for Options.Checkpoint
+// Unlike Flush it doesn't finish anything off, more records can come after it. Only the formats written as they go can do this
+type checkpointer interface {
+	checkpoint() error
+}
+
+// Wraps the writer Index picked for Options.Checkpoint, once the writer has pushed everything out sync gets it onto the disk
+type syncWriter struct {
+	recordWriter
+	sync func() error
+}
+
+func (s syncWriter) checkpoint() error {
+	if cp, ok := s.recordWriter.(checkpointer); ok {
+		if err := cp.checkpoint(); err != nil {
+			return err
+		}
+	}
+	return s.sync()
+}
+
+// Every output format Options.Format can be
+// sqlite is written by Index itself since it needs the output to be a file, see sqliteWriter
+// json is the same objects as ndjson but all in one array, for things that want to read one JSON document
+// parquet is for loading into DuckDB, Spark and the like, see parquetWriter
+// tsv is the same columns as csv with tabs between them and nothing ever quoted, see tsvWriter
+var KnownFormats = []string{"csv", "tsv", "ndjson", "json", "xml", "sql", "sqlite", "parquet"}
+
+func isKnownFormat(format string) bool {
+	for _, known := range KnownFormats {
+		if strings.EqualFold(format, known) {
+			return true
+		}
+	}
+	return false
+}
+
+// Picks the recordWriter for Options.Format
+// These aren't safe to use from more than one goroutine, wrap them in an asyncWriter for that
+// If Options.TimeFormat is empty each format uses whatever it has always used
+func newRecordWriter(opts Options, w io.Writer) (recordWriter, error) {
+	switch strings.ToLower(opts.Format) {
+	case "csv":
+		timeFormat := opts.TimeFormat
+		if timeFormat == "" {
+			timeFormat = "go"
+		}
+		return &csvWriter{w: csv.NewWriter(w), hashNames: opts.Hashes, timeFormat: timeFormat, columns: optionalColumnsFor(opts)}, nil
+	case "tsv":
+		timeFormat := opts.TimeFormat
+		if timeFormat == "" {
+			timeFormat = "go"
+		}
+		return &tsvWriter{w: w, hashNames: opts.Hashes, timeFormat: timeFormat, columns: optionalColumnsFor(opts)}, nil
+	case "ndjson":
+		return &ndjsonWriter{enc: json.NewEncoder(w), hashNames: opts.Hashes, timeFormat: opts.TimeFormat, columns: optionalColumnsFor(opts)}, nil
+	case "json":
+		return &jsonArrayWriter{w: w, hashNames: opts.Hashes, timeFormat: opts.TimeFormat, columns: optionalColumnsFor(opts)}, nil
+	case "xml":
+		return newXMLWriter(w, opts.Hashes, opts.TimeFormat, optionalColumnsFor(opts)), nil
+	case "sql":
+		return newSQLScriptWriter(w, opts.table(), opts.Hashes, opts.TimeFormat, optionalColumnsFor(opts)), nil
+	case "parquet":
+		return newParquetWriter(w, opts.Hashes, optionalColumnsFor(opts)), nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, expected one of %s", opts.Format, strings.Join(KnownFormats, ", "))
+}
+
+// Lets all of the workers write records without fighting over a lock
+// Workers drop their records into a channel and a single goroutine does the actual writing,
+// so the only time a worker waits is when the channel is full
+type asyncWriter struct {
+	records chan record
+	done    chan error
+}
+
+// Starts the goroutine that writes everything sent to the asyncWriter into rw
+// queueSize is how many records can be waiting before the workers have to wait for the writer
+// If rw is a checkpointer and every is above 0 it gets a checkpoint that often, from the same goroutine so it never lands in the middle of a record
+func newAsyncWriter(rw recordWriter, queueSize int, every time.Duration) *asyncWriter {
+	aw := &asyncWriter{
+		records: make(chan record, queueSize),
+		done:    make(chan error, 1),
+	}
+	go func() {
+		// A nil channel never fires, so without checkpoints the select only ever gets records
+		var tick <-chan time.Time
+		cp, canCheckpoint := rw.(checkpointer)
+		if canCheckpoint && every > 0 {
+			ticker := time.NewTicker(every)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		var err error
+	loop:
+		for {
+			select {
+			case r, ok := <-aw.records:
+				if !ok {
+					break loop
+				}
+				// Once something has gone wrong we keep draining the channel so the workers don't get stuck,
+				// we just stop writing and report the first error when we're closed
+				if err == nil {
+					err = rw.WriteRecord(r)
+				}
+			case <-tick:
+				if err == nil {
+					err = cp.checkpoint()
+				}
+			}
+		}
+		if flushErr := rw.Flush(); err == nil {
+			err = flushErr
+		}
+		aw.done <- err
+	}()
+	return aw
+}
+
+func (aw *asyncWriter) WriteRecord(r record) {
+	aw.records <- r
+}
+
+// Waits for every record to be written and flushed, don't call WriteRecord after this
+func (aw *asyncWriter) Close() error {
+	close(aw.records)
+	return <-aw.done
+}
+
+// Which of the columns that only show up with some option a writer has to include
+type optionalColumns struct {
+	root bool
+	// The same on every record, so it comes from here rather than the record
+	host        string
+	offset      bool
+	mime        bool
+	recordType  bool
+	target      bool
+	permissions bool
+	change      bool
+	hashTime    bool
+	errors      bool
+	// The names of Options.ExtraColumns, these always go last
+	extra []string
+}
+
+func optionalColumnsFor(opts Options) optionalColumns {
+	return optionalColumns{root: opts.rootColumn(), host: opts.Host, offset: opts.Chunks, mime: opts.DetectType, recordType: opts.DirHashes || opts.RecordSymlinks || opts.HashLinkTargets, target: opts.RecordSymlinks || opts.HashLinkTargets, permissions: opts.Permissions, change: opts.SinceIndex != nil, hashTime: opts.Timing, errors: opts.ErrorsInline, extra: columnNames(opts.ExtraColumns)}
+}
+
+// The names of the optional columns, in the order they come after Size
+func (c optionalColumns) names() []string {
+	var names []string
+	if c.root {
+		names = append(names, "Root")
+	}
+	if c.host != "" {
+		names = append(names, "Host")
+	}
+	if c.offset {
+		names = append(names, "Offset")
+	}
+	if c.mime {
+		names = append(names, "Mime")
+	}
+	if c.recordType {
+		names = append(names, "Type")
+	}
+	if c.target {
+		names = append(names, "Target")
+	}
+	if c.permissions {
+		names = append(names, "Owner", "Group", "Mode")
+	}
+	if c.change {
+		names = append(names, "Change")
+	}
+	if c.hashTime {
+		names = append(names, "HashMs")
+	}
+	if c.errors {
+		names = append(names, "Error")
+	}
+	return append(names, c.extra...)
+}
+
+// What goes in each of the optional columns for a record, lines up with names
+func (c optionalColumns) values(r record) []string {
+	var values []string
+	if c.root {
+		values = append(values, r.Root)
+	}
+	if c.host != "" {
+		values = append(values, c.host)
+	}
+	if c.offset {
+		values = append(values, strconv.FormatInt(r.Offset, 10))
+	}
+	if c.mime {
+		values = append(values, r.Mime)
+	}
+	if c.recordType {
+		values = append(values, r.Type)
+	}
+	if c.target {
+		values = append(values, r.Target)
+	}
+	if c.permissions {
+		values = append(values, r.Owner, r.Group, r.Mode)
+	}
+	if c.change {
+		values = append(values, r.Change)
+	}
+	if c.hashTime {
+		values = append(values, formatHashTime(r.HashTime))
+	}
+	if c.errors {
+		values = append(values, r.Error)
+	}
+	// A directory record doesn't have any, but it still needs the empty columns so the rest of the row lines up
+	for i := range c.extra {
+		var value string
+		if i < len(r.Extra) {
+			value = r.Extra[i]
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// Milliseconds to the microsecond, most small files take well under one so a whole number would make them all 0
+// Empty for anything that wasn't read, like a directory or a symlink
+func formatHashTime(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(hashMillis(d), 'f', 3, 64)
+}
+
+func hashMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// Builds the header row, one hash column per algorithm in the order they were asked for
+// Size goes on the end so the columns that were already there don't move around, and anything optional goes after that
+func headerRow(hashNames []string, optional optionalColumns) []string {
+	columns := []string{"Path"}
+	for _, name := range hashNames {
+		columns = append(columns, strings.ToUpper(name)+" Hash")
+	}
+	columns = append(columns, "Time", "Size")
+	return append(columns, optional.names()...)
+}
+
+// The original format, one comma separated line per file
+// encoding/csv takes care of quoting paths that have commas, quotes or newlines in them
+type csvWriter struct {
+	w          *csv.Writer
+	hashNames  []string
+	timeFormat string
+	columns    optionalColumns
+}
+
+func (c *csvWriter) WriteHeader() error {
+	return c.w.Write(headerRow(c.hashNames, c.columns))
+}
+
+func (c *csvWriter) WriteRecord(r record) error {
+	// One column per hash, in the same order as the header
+	columns := append([]string{r.Path}, r.Hashes...)
+	columns = append(columns, formatTime(r.ModTime, c.timeFormat), strconv.FormatInt(r.Size, 10))
+	columns = append(columns, c.columns.values(r)...)
+
+	// This will append to our log file something like...
+	// C:\code\goindex\main.go,23f3fa53025c860edf6f8e7d81b74973b4000dba388f74a5b93d52dafdc8077e,2021-04-27 22:33:47.982338 +0000 UTC,2573
+	return c.w.Write(columns)
+}
+
+// csv.Writer buffers everything, so nothing actually hits the file until we flush
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// Flushing a csv.Writer doesn't stop you writing more to it, so a checkpoint is the same thing
+func (c *csvWriter) checkpoint() error {
+	return c.Flush()
+}
+
+// Tab separated, one line per file with the same columns as csvWriter
+// Nothing gets quoted, so cut and awk -F'\t' can pull columns out without knowing about quotes. A tab, newline
+// or carriage return in a path is written as \t, \n or \r instead, the same escapes linear TSV and postgres use
+type tsvWriter struct {
+	w          io.Writer
+	hashNames  []string
+	timeFormat string
+	columns    optionalColumns
+}
+
+func (t *tsvWriter) WriteHeader() error {
+	return writeTSVRow(t.w, headerRow(t.hashNames, t.columns))
+}
+
+func (t *tsvWriter) WriteRecord(r record) error {
+	columns := append([]string{r.Path}, r.Hashes...)
+	columns = append(columns, formatTime(r.ModTime, t.timeFormat), strconv.FormatInt(r.Size, 10))
+	return writeTSVRow(t.w, append(columns, t.columns.values(r)...))
+}
+
+// Every line is written whole, so whatever Index has it buffered by does all of the holding on
+func (t *tsvWriter) Flush() error {
+	return nil
+}
+
+func (t *tsvWriter) checkpoint() error {
+	return nil
+}
+
+func writeTSVRow(w io.Writer, fields []string) error {
+	escaped := make([]string, len(fields))
+	for i, field := range fields {
+		escaped[i] = escapeTSV(field)
+	}
+	_, err := io.WriteString(w, strings.Join(escaped, "\t")+"\n")
+	return err
+}
+
+// A backslash only gets doubled when what comes after it would make it look like one of the escapes,
+// that way a Windows path like C:\data comes out as it is and you can still always get back what the path was
+func escapeTSV(s string) string {
+	if !strings.ContainsAny(s, "\\\t\n\r") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\\':
+			b.WriteByte(c)
+			if i+1 < len(s) && strings.IndexByte("\\tnr\t\n\r", s[i+1]) >= 0 {
+				b.WriteByte(c)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Newline delimited JSON, one object per line
+// Nice if you want to feed the results into something like jq
+type ndjsonWriter struct {
+	enc        *json.Encoder
+	hashNames  []string
+	timeFormat string
+	columns    optionalColumns
+}
+
+// What each line of ndjson looks like
+// Hash is always the first algorithm you asked for, Hashes is only filled in when you asked for more than one
+// Extra has Options.ExtraColumns in it keyed by their names, they're in an object of their own so they can't clash with ours
+// ModTime is a time.Time unless you picked a time format, then it's a number for unix and unixnano and a string for everything else
+// Offset is a pointer so the first chunk still gets an offset of 0 instead of it being left out
+type jsonRecord struct {
+	Path    string            `json:"path"`
+	Hash    string            `json:"hash"`
+	Hashes  map[string]string `json:"hashes,omitempty"`
+	ModTime interface{}       `json:"modTime"`
+	Size    int64             `json:"size"`
+	Root    string            `json:"root,omitempty"`
+	Host    string            `json:"host,omitempty"`
+	Offset  *int64            `json:"offset,omitempty"`
+	Mime    string            `json:"mime,omitempty"`
+	Type    string            `json:"type,omitempty"`
+	Target  string            `json:"target,omitempty"`
+	Owner   string            `json:"owner,omitempty"`
+	Group   string            `json:"group,omitempty"`
+	Mode    string            `json:"mode,omitempty"`
+	Change  string            `json:"change,omitempty"`
+	HashMs  *float64          `json:"hashMs,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Extra   map[string]string `json:"extra,omitempty"`
+}
+
+func (n *ndjsonWriter) WriteHeader() error {
+	return nil
+}
+
+func (n *ndjsonWriter) WriteRecord(r record) error {
+	// Encode adds the newline for us
+	return n.enc.Encode(newJSONRecord(r, n.hashNames, n.timeFormat, n.columns))
+}
+
+// Turns a record into what both of the JSON formats write for it
+// Most of the optional fields are just left out when they're empty, columns is for the ones where empty means something
+func newJSONRecord(r record, hashNames []string, timeFormat string, columns optionalColumns) jsonRecord {
+	rec := jsonRecord{Path: r.Path, Hash: r.Hashes[0], ModTime: r.ModTime.UTC(), Size: r.Size, Root: r.Root, Host: columns.host, Mime: r.Mime, Type: r.Type, Target: r.Target, Owner: r.Owner, Group: r.Group, Mode: r.Mode, Change: r.Change, Error: r.Error}
+	if len(r.Extra) > 0 {
+		rec.Extra = make(map[string]string, len(r.Extra))
+		for i, name := range columns.extra {
+			rec.Extra[name] = r.Extra[i]
+		}
+	}
+	if columns.offset {
+		offset := r.Offset
+		rec.Offset = &offset
+	}
+	if columns.hashTime && r.HashTime > 0 {
+		ms := hashMillis(r.HashTime)
+		rec.HashMs = &ms
+	}
+	switch strings.ToLower(timeFormat) {
+	case "":
+	case "unix":
+		rec.ModTime = r.ModTime.Unix()
+	case "unixnano":
+		rec.ModTime = r.ModTime.UnixNano()
+	default:
+		rec.ModTime = formatTime(r.ModTime, timeFormat)
+	}
+	if len(r.Hashes) > 1 {
+		rec.Hashes = make(map[string]string, len(r.Hashes))
+		for i, name := range hashNames {
+			rec.Hashes[name] = r.Hashes[i]
+		}
+	}
+	return rec
+}
+
+// The encoder writes straight through, so there's nothing to flush
+func (n *ndjsonWriter) Flush() error {
+	return nil
+}
+
+func (n *ndjsonWriter) checkpoint() error {
+	return nil
+}
+
+// One JSON array with every record in it, one object to a line so it's still readable
+// The [ goes out with the first record rather than as a header, and Flush always closes the array,
+// so you get a valid document even if the run was cancelled part way or nothing matched at all
+type jsonArrayWriter struct {
+	w          io.Writer
+	hashNames  []string
+	timeFormat string
+	columns    optionalColumns
+	// Set once the [ has been written, every record after that needs a comma in front of it
+	opened bool
+}
+
+func (j *jsonArrayWriter) WriteHeader() error {
+	return nil
+}
+
+func (j *jsonArrayWriter) WriteRecord(r record) error {
+	b, err := json.Marshal(newJSONRecord(r, j.hashNames, j.timeFormat, j.columns))
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if !j.opened {
+		sep = "[\n"
+		j.opened = true
+	}
+	if _, err := io.WriteString(j.w, sep); err != nil {
+		return err
+	}
+	_, err = j.w.Write(b)
+	return err
+}
+
+func (j *jsonArrayWriter) Flush() error {
+	end := "\n]\n"
+	if !j.opened {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+// Throws everything away, for Options.DryRun
+type discardWriter struct{}
+
+func (discardWriter) WriteHeader() error         { return nil }
+func (discardWriter) WriteRecord(r record) error { return nil }
+func (discardWriter) Flush() error               { return nil }
+
+// Passes everything through to another recordWriter except the header
+// For appending to a file that already has one
+type noHeaderWriter struct {
+	recordWriter
+}
+
+func (noHeaderWriter) WriteHeader() error { return nil }
+
+func (n noHeaderWriter) checkpoint() error {
+	if cp, ok := n.recordWriter.(checkpointer); ok {
+		return cp.checkpoint()
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A comma or a quote in the name has to come back out of a CSV reader as the same path
+func TestCSVQuotesAwkwardPaths(t *testing.T) {
+	dir := t.TempDir()
+	name := `a,b"c.txt`
+	writeTree(t, dir, map[string]string{name: "awkward", "plain.txt": "plain"})
+
+	out, _ := runIndex(t, testOptions(dir))
+	byPath := make(map[string]map[string]string)
+	for _, rec := range parseCSV(t, out) {
+		byPath[rec["Path"]] = rec
+	}
+	if rec, ok := byPath[filepath.Join(dir, name)]; !ok || rec["SHA256 Hash"] != sha256Hex("awkward") {
+		t.Errorf("%s didn't come back out, got %v", name, byPath)
+	}
+	// No space after the commas either, that isn't part of the next field in standard CSV
+	if header := strings.SplitN(out, "\n", 2)[0]; header != "Path,SHA256 Hash,Time,Size" {
+		t.Errorf("got header %q", header)
+	}
+}
+
+// The whole of the json format is one array that unmarshals, with every field the ndjson lines have
+func TestJSONArray(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "hello", "b/c.txt": "goodbye"})
+	opts := testOptions(dir)
+	opts.Format = "json"
+	opts.DetectType = true
+
+	out, _ := runIndex(t, opts)
+	var records []jsonRecord
+	if err := json.Unmarshal([]byte(out), &records); err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	byPath := make(map[string]jsonRecord)
+	for _, rec := range records {
+		byPath[rec.Path] = rec
+	}
+	a := byPath[filepath.Join(dir, "a.txt")]
+	if a.Hash != sha256Hex("hello") || a.Size != 5 || a.ModTime == nil || a.Mime == "" {
+		t.Errorf("got %+v for a.txt", a)
+	}
+
+	// Nothing to write is still an array
+	opts = testOptions(t.TempDir())
+	opts.Format = "json"
+	if out, _ := runIndex(t, opts); json.Unmarshal([]byte(out), &records) != nil || len(records) != 0 {
+		t.Errorf("got %q for an empty directory, want an empty array", out)
+	}
+}
+
+// The closing bracket goes on in Flush, so a run that's cancelled part way still unmarshals
+func TestJSONArrayCancelled(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		files[fmt.Sprintf("d%d/f%d.txt", i%10, i)] = fmt.Sprint(i)
+	}
+	writeTree(t, dir, files)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := testOptions(dir)
+	opts.Format = "json"
+	var hashed int32
+	opts.OnFileHashed = func(path string, size int64) {
+		if atomic.AddInt32(&hashed, 1) == 20 {
+			cancel()
+		}
+	}
+
+	var out bytes.Buffer
+	if _, err := Index(ctx, opts, &out); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want it cancelled", err)
+	}
+	var records []jsonRecord
+	if err := json.Unmarshal(out.Bytes(), &records); err != nil {
+		t.Fatalf("%s\n%s", err, out.String())
+	}
+	if len(records) < 20 || len(records) == len(files) {
+		t.Errorf("got %d records, want the 20 or so hashed before the cancel", len(records))
+	}
+}
+
+// With NoHeader there's nothing but records, in both the formats that have a header to leave off
+func TestNoHeader(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "b.txt": "b"})
+	for _, format := range []string{"csv", "tsv"} {
+		opts := testOptions(dir)
+		opts.Format = format
+		opts.NoHeader = true
+		out, _ := runIndex(t, opts)
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if len(lines) != 2 || strings.Contains(out, "Path") {
+			t.Errorf("%s without a header got\n%s", format, out)
+		}
+		for _, line := range lines {
+			if !strings.HasPrefix(line, dir) {
+				t.Errorf("%s got a line that isn't a record: %q", format, line)
+			}
+		}
+
+		// The header is still there by default
+		opts.NoHeader = false
+		if out, _ := runIndex(t, opts); !strings.HasPrefix(out, "Path") {
+			t.Errorf("%s with a header got\n%s", format, out)
+		}
+	}
+}
+
+// Whatever has been checkpointed is in the file while the run is still going, so a crash at that point would leave it there
+// Twenty records fit in the output buffer, without a checkpoint none of them would have reached the file yet
+func TestCheckpointSurvivesCrash(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		files[fmt.Sprintf("f%03d", i)] = fmt.Sprint(i)
+	}
+	writeTree(t, dir, files)
+	name := filepath.Join(t.TempDir(), "files.csv")
+
+	for _, every := range []time.Duration{0, 20 * time.Millisecond} {
+		f, err := os.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := testOptions(dir)
+		opts.Workers = 1
+		opts.Checkpoint = every
+		var onDisk string
+		var hashed int32
+		opts.OnFileHashed = func(path string, size int64) {
+			if atomic.AddInt32(&hashed, 1) != 20 {
+				return
+			}
+			// This is where we crash, everything after it never happens as far as the file is concerned
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				data, _ := ioutil.ReadFile(name)
+				onDisk = string(data)
+				if every == 0 || strings.Count(onDisk, "\n") >= 21 || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			cancel()
+		}
+		Index(ctx, opts, f)
+		f.Close()
+		cancel()
+
+		if every == 0 {
+			if onDisk != "" {
+				t.Errorf("without checkpoints the file already had %q in it, the buffer's too small for this test", onDisk)
+			}
+			continue
+		}
+		records := parseCSV(t, onDisk)
+		if len(records) < 20 || !strings.HasSuffix(onDisk, "\n") {
+			t.Fatalf("only %d records had been checkpointed at the crash:\n%s", len(records), onDisk)
+		}
+		for _, rec := range records {
+			if content, ok := files[filepath.Base(rec["Path"])]; !ok || rec["SHA256 Hash"] != sha256Hex(content) {
+				t.Errorf("got a bad record %v", rec)
+			}
+		}
+	}
+}
+
+// Undoes escapeTSV, a backslash only means something in front of another backslash or t, n or r
+func unescapeTSV(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '\\':
+			b.WriteByte('\\')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte('\\')
+			continue
+		}
+		i++
+	}
+	return b.String()
+}
+
+// Every path comes back out of the TSV as it went in once the escapes are undone, and nothing else needs undoing
+func TestTSVRoundTrip(t *testing.T) {
+	paths := []string{"/plain/a.txt", "/tab\there", "/new\nline\r", `C:\data\file.txt`, `\t literally`, "\\\ttab after a backslash", `ends in \`, `a,b"c`}
+	var out bytes.Buffer
+	w := &tsvWriter{w: &out, hashNames: []string{"sha256"}}
+	w.WriteHeader()
+	modTime := time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC)
+	for i, path := range paths {
+		w.WriteRecord(record{Path: path, Hashes: []string{sha256Hex(path)}, ModTime: modTime, Size: int64(i)})
+	}
+	w.Flush()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(paths)+1 || lines[0] != "Path\tSHA256 Hash\tTime\tSize" {
+		t.Fatalf("got\n%s", out.String())
+	}
+	for i, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			t.Errorf("line %d has %d fields: %q", i, len(fields), line)
+			continue
+		}
+		if got := unescapeTSV(fields[0]); got != paths[i] || fields[1] != sha256Hex(paths[i]) || fields[2] != formatTime(modTime, "") || fields[3] != fmt.Sprint(i) {
+			t.Errorf("got %q back for %q", fields, paths[i])
+		}
+	}
+}
+
+// The same through Index, with a real file that has a tab in its name where the OS allows one
+func TestTSVIndex(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"plain.txt": "plain"}
+	if runtime.GOOS != "windows" {
+		files["tab\tname.txt"] = "tab"
+	}
+	writeTree(t, dir, files)
+	opts := testOptions(dir)
+	opts.Format = "tsv"
+	out, _ := runIndex(t, opts)
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != len(files)+1 {
+		t.Fatalf("got\n%s", out)
+	}
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		name, _ := filepath.Rel(dir, unescapeTSV(fields[0]))
+		if content, ok := files[name]; !ok || fields[1] != sha256Hex(content) {
+			t.Errorf("got %q", fields)
+		}
+	}
+}
+
+// Every record carries the host it was given, in csv and json, and without one there's no column at all
+func TestHostColumn(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+	opts := testOptions(dir)
+	opts.Host = "scanner-01"
+	out, _ := runIndex(t, opts)
+	if header := strings.SplitN(out, "\n", 2)[0]; header != "Path,SHA256 Hash,Time,Size,Host" {
+		t.Errorf("got header %q", header)
+	}
+	records := parseCSV(t, out)
+	for _, rec := range records {
+		if rec["Host"] != "scanner-01" {
+			t.Errorf("%s got host %q", rec["Path"], rec["Host"])
+		}
+	}
+	if len(records) != 2 {
+		t.Errorf("got %d records", len(records))
+	}
+
+	opts.Format = "json"
+	out, _ = runIndex(t, opts)
+	var objects []jsonRecord
+	if err := json.Unmarshal([]byte(out), &objects); err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objects {
+		if obj.Host != "scanner-01" {
+			t.Errorf("%s got host %q in json", obj.Path, obj.Host)
+		}
+	}
+
+	out, _ = runIndex(t, testOptions(dir))
+	if header := strings.SplitN(out, "\n", 2)[0]; header != "Path,SHA256 Hash,Time,Size" || strings.Contains(out, "scanner-01") {
+		t.Errorf("got a host without one being set:\n%s", out)
+	}
+}
+
+// Eight workers writing records at once, through one lock like it used to be done and through the asyncWriter's channel
+// Run with -bench RecordWriter -cpu 1,4,8 to see how the lock does as the workers get more cores to fight over it with
+func BenchmarkRecordWriter(b *testing.B) {
+	const workers = 8
+	rec := record{Path: "/some/fairly/ordinary/path/to/a/file.txt", Hashes: []string{sha256Hex("x")}, ModTime: time.Now(), Size: 1234}
+	newWriter := func() recordWriter {
+		rw, err := newRecordWriter(DefaultOptions(), bufio.NewWriter(ioutil.Discard))
+		if err != nil {
+			b.Fatal(err)
+		}
+		return rw
+	}
+	each := func(b *testing.B, write func()) {
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < b.N/workers; i++ {
+					write()
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	b.Run("mutex", func(b *testing.B) {
+		rw := newWriter()
+		var mu sync.Mutex
+		each(b, func() {
+			mu.Lock()
+			rw.WriteRecord(rec)
+			mu.Unlock()
+		})
+		rw.Flush()
+	})
+	b.Run("channel", func(b *testing.B) {
+		aw := newAsyncWriter(newWriter(), DefaultOptions().WriteQueue, 0)
+		each(b, func() { aw.WriteRecord(rec) })
+		aw.Close()
+	})
+}
+
+// A whole run over a lot of small files, where it's the writing rather than the reading that there's the most of
+func BenchmarkManySmallFiles(b *testing.B) {
+	dir := b.TempDir()
+	files := make(map[string]string)
+	for i := 0; i < 5000; i++ {
+		files[fmt.Sprintf("d%d/f%d.txt", i%50, i)] = fmt.Sprint(i)
+	}
+	writeTree(b, dir, files)
+	for _, queue := range []int{1, 1024} {
+		b.Run(fmt.Sprintf("queue-%d", queue), func(b *testing.B) {
+			opts := testOptions(dir)
+			opts.WriteQueue = queue
+			for i := 0; i < b.N; i++ {
+				runIndex(b, opts)
+			}
+		})
+	}
+}
+
+// What a reader of the xml format would unmarshal it into
+type xmlIndex struct {
+	Files []struct {
+		Path    string `xml:"path,attr"`
+		Size    int64  `xml:"size,attr"`
+		ModTime string `xml:"modTime,attr"`
+		Mime    string `xml:"mime,attr"`
+		Hashes  []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"hash"`
+		Extra []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"extra"`
+	} `xml:"file"`
+}
+
+// Every file comes back out of encoding/xml with its path, however awkward, and all its hashes and columns
+func TestXMLUnmarshal(t *testing.T) {
+	dir := t.TempDir()
+	awkward := `a&b 'c'.txt`
+	if runtime.GOOS != "windows" {
+		awkward = `<a&b> "c".txt`
+	}
+	writeTree(t, dir, map[string]string{awkward: "awkward", "plain.txt": "plain"})
+	modTime := time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "plain.txt"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions(dir)
+	opts.Format = "xml"
+	opts.Hashes = []string{"sha256", "md5"}
+	opts.DetectType = true
+	opts.ExtraColumns = []Column{lengthColumn()}
+
+	out, _ := runIndex(t, opts)
+	if !strings.HasPrefix(out, "<?xml") || !strings.HasSuffix(out, "</index>\n") {
+		t.Errorf("got\n%s\nwant an XML declaration and the index closed", out)
+	}
+	var index xmlIndex
+	if err := xml.Unmarshal([]byte(out), &index); err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+	if len(index.Files) != 2 {
+		t.Fatalf("got %d files, want 2:\n%s", len(index.Files), out)
+	}
+	byPath := make(map[string]int)
+	for i, f := range index.Files {
+		byPath[f.Path] = i
+	}
+	for name, content := range map[string]string{awkward: "awkward", "plain.txt": "plain"} {
+		i, ok := byPath[filepath.Join(dir, name)]
+		if !ok {
+			t.Errorf("%s isn't in\n%s", name, out)
+			continue
+		}
+		f := index.Files[i]
+		if f.Size != int64(len(content)) || len(f.Hashes) != 2 || f.Hashes[0].Name != "sha256" || f.Hashes[0].Value != sha256Hex(content) || f.Hashes[1].Name != "md5" {
+			t.Errorf("%s got %+v", name, f)
+		}
+		if f.Mime != "text/plain; charset=utf-8" || len(f.Extra) != 1 || f.Extra[0].Name != "NameLength" || f.Extra[0].Value != fmt.Sprint(len(name)) {
+			t.Errorf("%s got mime %q and extra %+v", name, f.Mime, f.Extra)
+		}
+	}
+	if f := index.Files[byPath[filepath.Join(dir, "plain.txt")]]; f.ModTime != "2021-05-01T10:30:00Z" {
+		t.Errorf("got modTime %q, want it rfc3339", f.ModTime)
+	}
+}
+
+// A cancelled run still ends with </index>, so what's there can be read
+func TestXMLCancelled(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		files[fmt.Sprintf("d%d/f%d.txt", i%10, i)] = fmt.Sprint(i)
+	}
+	writeTree(t, dir, files)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := testOptions(dir)
+	opts.Format = "xml"
+	var hashed int32
+	opts.OnFileHashed = func(path string, size int64) {
+		if atomic.AddInt32(&hashed, 1) == 20 {
+			cancel()
+		}
+	}
+
+	var out bytes.Buffer
+	if _, err := Index(ctx, opts, &out); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want it cancelled", err)
+	}
+	var index xmlIndex
+	if err := xml.Unmarshal(out.Bytes(), &index); err != nil {
+		t.Fatalf("%s\n%s", err, out.String())
+	}
+	if len(index.Files) < 20 || len(index.Files) == len(files) {
+		t.Errorf("got %d files, want the 20 or so hashed before the cancel", len(index.Files))
+	}
+}
@@ -0,0 +1,89 @@
+package index
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// A recordWriter for Options.Format xml, an <index> with a <file> in it for every record, one to a line
+//
+//	<index>
+//	  <file path="/a.txt" size="3" modTime="2021-05-01T10:00:00Z"><hash name="sha256">...</hash></file>
+//	</index>
+//
+// The path, size and time are attributes and so are the optional columns, named like the json fields.
+// Every hash gets its own <hash> so more than one algorithm doesn't need more than one attribute name,
+// and the extra columns are <extra> elements since their names can have anything in them.
+// encoding/xml does the escaping, anything that can't be in XML at all like a control character in a name comes out as U+FFFD
+// Records are written as they come, the </index> goes on in Flush, which happens on an interrupt too
+type xmlWriter struct {
+	w          io.Writer
+	enc        *xml.Encoder
+	hashNames  []string
+	timeFormat string
+	columns    optionalColumns
+}
+
+// What every <file> looks like
+type xmlFile struct {
+	XMLName xml.Name   `xml:"file"`
+	Path    string     `xml:"path,attr"`
+	Size    int64      `xml:"size,attr"`
+	ModTime string     `xml:"modTime,attr"`
+	Columns []xml.Attr `xml:",any,attr"`
+	Hashes  []xmlValue `xml:"hash"`
+	Extra   []xmlValue `xml:"extra"`
+}
+
+type xmlValue struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+func newXMLWriter(w io.Writer, hashNames []string, timeFormat string, columns optionalColumns) *xmlWriter {
+	// rfc3339 is what XML schemas call a dateTime, so it's what anything reading this is most likely to understand
+	if timeFormat == "" {
+		timeFormat = "rfc3339"
+	}
+	return &xmlWriter{w: w, enc: xml.NewEncoder(w), hashNames: hashNames, timeFormat: timeFormat, columns: columns}
+}
+
+func (x *xmlWriter) WriteHeader() error {
+	_, err := io.WriteString(x.w, xml.Header+"<index>")
+	return err
+}
+
+func (x *xmlWriter) WriteRecord(r record) error {
+	file := xmlFile{Path: r.Path, Size: r.Size, ModTime: formatTime(r.ModTime, x.timeFormat)}
+	for i, name := range x.hashNames {
+		file.Hashes = append(file.Hashes, xmlValue{Name: name, Value: r.Hashes[i]})
+	}
+	names := x.columns.names()
+	values := x.columns.values(r)
+	builtIn := len(names) - len(x.columns.extra)
+	for i := 0; i < builtIn; i++ {
+		// Empty is the same as not there, like json leaves them out
+		if values[i] == "" {
+			continue
+		}
+		file.Columns = append(file.Columns, xml.Attr{Name: xml.Name{Local: xmlAttrName(names[i])}, Value: values[i]})
+	}
+	for i, name := range x.columns.extra {
+		file.Extra = append(file.Extra, xmlValue{Name: name, Value: values[builtIn+i]})
+	}
+	if _, err := io.WriteString(x.w, "\n  "); err != nil {
+		return err
+	}
+	return x.enc.Encode(file)
+}
+
+// HashMs is hashMs and Root is root, the same as the json fields
+func xmlAttrName(column string) string {
+	return strings.ToLower(column[:1]) + column[1:]
+}
+
+func (x *xmlWriter) Flush() error {
+	_, err := io.WriteString(x.w, "\n</index>\n")
+	return err
+}
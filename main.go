@@ -1,20 +1,24 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
-	"sync"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/gammazero/workerpool"
-	"github.com/karrick/godirwalk"
-	"github.com/schollz/progressbar/v3"
+	"goindex/index"
+
+	"golang.org/x/term"
 )
 
 // Simple function to return the system root
@@ -26,124 +30,872 @@ func getSysRoot() string {
 	}
 }
 
-var mu sync.Mutex
+// Opens the file we write our results to
+// "-" is the usual convention for stdout so you can pipe the CSV into another program,
+// the progress bars already go to stderr so they won't end up mixed in with it
+// Whatever was in the file from last time is thrown away, unless appendTo is set in which case everything gets written after it
+func openOutput(name string, appendTo bool) (*os.File, error) {
+	if name == "-" {
+		return os.Stdout, nil
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendTo {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	// It's a data file, nobody needs to execute it
+	f, err := os.OpenFile(name, flags, 0644)
+	if err != nil {
+		// The raw error from OpenFile doesn't make it obvious that it's the folder that's missing
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("can't create %s, the directory %s does not exist", name, filepath.Dir(name))
+		}
+		return nil, err
+	}
+	return f, nil
+}
 
-// Thread safe function to write to a file
-// If we didn't have a mutex then runtime.NumCPU() threads would be trying to write in a file at the same time
-func writeToFile(text string, f *os.File) {
-	mu.Lock()
-	f.WriteString(text)
-	mu.Unlock()
+// The name -gzip writes to, .gz goes on the end unless it's already there. Stdout stays stdout
+func gzipName(output string) string {
+	if output == "-" || strings.HasSuffix(output, ".gz") {
+		return output
+	}
+	return output + ".gz"
 }
 
-func main() {
-	// Open a file that we can write to
-	handle, err := os.OpenFile("files.csv", os.O_WRONLY|os.O_CREATE, 0755)
+// Creates the file an -update is written to before it replaces index, in the same directory so the rename can't cross filesystems
+// It gets the index's permissions rather than the 0600 a temporary file starts with
+func createUpdateTemp(index string) (*os.File, error) {
+	f, err := ioutil.TempFile(filepath.Dir(index), filepath.Base(index)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(index); err == nil {
+		if err := f.Chmod(info.Mode().Perm()); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Puts the finished update in place of index, or throws it away if the run didn't finish since it'd be missing rows
+// Returns whatever went wrong, runErr if there was nothing else
+func finishUpdate(f *os.File, temp string, index string, runErr error) error {
+	closeErr := f.Close()
+	if runErr == nil {
+		runErr = closeErr
+	}
+	if runErr == nil {
+		runErr = os.Rename(temp, index)
+	}
+	if runErr != nil {
+		os.Remove(temp)
+	}
+	return runErr
+}
+
+// Opens wherever -progress-output says the bars should go, stderr and stdout by name, a file descriptor
+// that's already open like 3 from a shell's 3>progress.log, or anything else is a file that gets replaced
+func openProgressOutput(name string) (*os.File, error) {
+	switch name {
+	case "stderr":
+		return os.Stderr, nil
+	case "stdout":
+		return os.Stdout, nil
+	}
+	if fd, err := strconv.Atoi(name); err == nil {
+		if fd < 0 {
+			return nil, fmt.Errorf("-progress-output: %d isn't a file descriptor", fd)
+		}
+		f := os.NewFile(uintptr(fd), "fd "+name)
+		// Writing to one that isn't open would just fail quietly every time the bar redraws
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("-progress-output: file descriptor %d isn't open", fd)
+		}
+		return f, nil
+	}
+	return openOutput(name, false)
+}
+
+// Turns a comma separated flag like "*.go,*.md" into a list, ignoring any empty entries
+func splitList(flagValue string) []string {
+	var items []string
+	for _, item := range strings.Split(flagValue, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Reads patterns for -include-from and -exclude-from, one to a line
+// Blank lines and lines starting with # are skipped so the file can have comments in it, same as a .gitignore
+func readPatternFile(name string) ([]string, error) {
+	data, err := ioutil.ReadFile(name)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
 	}
-	// Defer closing of the file until the end of main
-	defer handle.Close()
+	return patterns, nil
+}
 
-	// Optional, start a workerpool with the amount of threads we have
-	wp := workerpool.New(runtime.NumCPU())
+// A flag you can give more than once, every value gets added to the list
+// Used for things like paths where a comma separated list would get in the way
+type listFlag []string
 
-	// Only needed if we setup a workerpool, you could do this on a single thread
-	// I'm only using this so I can queue up a bunch of tasks and then execute them all at once
-	ctx, cancel := context.WithCancel(context.Background())
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
 
-	// Pause our workerpool so it won't immediately start executing items submitted to it
-	wp.Pause(ctx)
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
 
-	// Progress bar for indexing files, -1 sets this to indeterminate
-	// We don't know how many files we'll be parsing, could be a single file or an entire drive
-	indexBar := progressbar.Default(-1)
+// Prints one stat per line, this goes to the log so it never ends up mixed in with -output - and -quiet hides it
+// The algorithm and worker count are in there so you know how to reproduce the run
+// top is how many extensions to list at the end, biggest first
+func printSummary(stats index.Stats, opts index.Options, top int) {
+	// We use 1 MB = 1,000,000 bytes here, same as the drive manufacturers
+	throughput := float64(stats.Bytes) / 1e6 / stats.Elapsed.Seconds()
 
-	// Progress bar for when we're hashing the files, this is a pointer so I can reference it before I actually initialize it.
-	// Don't do this unless you know you'll initialize it before you use it or you'll panic
-	var hashBar *progressbar.ProgressBar
+	logger.Infof("Files hashed: %d", stats.Files)
+	logger.Infof("Total bytes:  %d", stats.Bytes)
+	logger.Infof("Errors:       %d", stats.Errors)
+	if stats.Special > 0 {
+		logger.Infof("Special:      %d skipped, pass -include-special to read them", stats.Special)
+	}
+	if opts.SinceIndex != nil {
+		logger.Infof("Unchanged:    %d", stats.Unchanged)
+	}
+	if opts.RecordSymlinks {
+		logger.Infof("Symlinks:     %d", stats.Symlinks)
+	}
+	logger.Infof("Elapsed:      %s", stats.Elapsed.Round(time.Millisecond))
+	// The bytes bar uses 1 MiB = 1,048,576 bytes and there's no changing that, so you get both to compare against it
+	if opts.ProgressBytes {
+		logger.Infof("Throughput:   %.2f MB/s (%.2f MiB/s)", throughput, float64(stats.Bytes)/(1<<20)/stats.Elapsed.Seconds())
+	} else {
+		logger.Infof("Throughput:   %.2f MB/s", throughput)
+	}
+	logger.Infof("Hash:         %s", strings.Join(opts.Hashes, ", "))
+	if opts.ReadWorkersMax > 0 {
+		logger.Infof("Workers:      %d to %d read, %d hash", opts.ReadWorkersMin, opts.ReadWorkersMax, opts.HashWorkers)
+	} else {
+		logger.Infof("Workers:      %d read, %d hash", opts.ReadWorkers, opts.HashWorkers)
+	}
+	if opts.Rollup {
+		logger.Infof("Rollup:       %s (%s)", stats.Rollup, opts.Hashes[0])
+	}
+
+	printExtensions(stats, top, logger.Infof)
+}
+
+// Lists the top extensions by total size, biggest first, every line goes through printf
+func printExtensions(stats index.Stats, top int, printf func(format string, args ...interface{})) {
+	if top > 0 && len(stats.Extensions) > 0 {
+		if top > len(stats.Extensions) {
+			top = len(stats.Extensions)
+		}
+		printf("Top %d extensions by size:\n", top)
+		for _, e := range stats.Extensions[:top] {
+			printf("  %-12s %8d files %16d bytes\n", e.Extension, e.Files, e.Bytes)
+		}
+	}
+}
+
+// What -dry-run prints instead of the summary, there's no throughput or anything like that since nothing was read
+func printDryRun(stats index.Stats, top int) {
+	fmt.Fprintf(os.Stderr, "Files to hash: %d\n", stats.Files)
+	fmt.Fprintf(os.Stderr, "Total bytes:   %d\n", stats.Bytes)
+	fmt.Fprintf(os.Stderr, "Errors:        %d\n", stats.Errors)
+	// Only -record-symlinks counts them
+	if stats.Symlinks > 0 {
+		fmt.Fprintf(os.Stderr, "Symlinks:      %d\n", stats.Symlinks)
+	}
+	printExtensions(stats, top, func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, format, args...)
+	})
+}
+
+// Returns true if the flag was actually given on the command line rather than left at its default
+func flagWasSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Everything main prints apart from the dry run counts goes through here, on stderr
+// It starts out at the normal level since errors in the flags need reporting before we know what level you asked for
+var logger = index.NewLogger(os.Stderr, index.LogNormal)
+
+// Prints the error and quits, everything that goes wrong before the walk starts ends up here
+func exitWithError(err error) {
+	logger.Errorf("%s", err)
+	os.Exit(exitFailed)
+}
+
+func main() {
+	defaults := index.DefaultOptions()
 
 	// Simple way to get command line flags in Go, there are other libraries that do this better but this is alright
-	// A good exercise would be to allow me to pass a filename to the program using a flag
+	configFile := flag.String("config", "", "A .toml or .yaml file of options to use, the keys are the flag names like walkDir, hash or exclude and a list works for hash, include, exclude, exclude-dir and root. Anything you also give on the command line wins over the file")
 	walkDir := flag.String("walkDir", getSysRoot(), "The directory to walk, defaults to top most level directory")
+	var roots listFlag
+	flag.Var(&roots, "root", "Another directory to walk into the same output, give it once for every directory like -root /home -root /data. They're walked after walkDir if you set that too. With -relative a Root column is added so you can tell which one a path is from")
+	fromStdin := flag.Bool("from-stdin", false, "Hash the files listed on stdin, one path per line, instead of walking walkDir. None of the filters apply. Paths that don't exist go in the error log")
+	fromStdin0 := flag.Bool("from-stdin0", false, "Same as -from-stdin but the paths end in a NUL byte, for find -print0 or paths with newlines in them")
+	pathsFile := flag.String("paths", "", "Hash the files listed in this file, one path per line, instead of walking walkDir. The same as -from-stdin but from a file")
+	output := flag.String("output", "files.csv", "The file to write the index to, use - to write to stdout")
+	format := flag.String("format", defaults.Format, "The output format, one of csv, tsv, ndjson, json, xml, sql, sqlite or parquet. tsv is the csv columns with tabs between them and nothing quoted. json is a single array of the same objects ndjson writes. xml is an index element with a file element for every file. sql is a script of INSERTs in one transaction for a table you already have, see -table. sqlite writes a files table you can query, it has to go to a file rather than stdout. parquet has typed columns named like the sqlite ones, for DuckDB or Spark")
+	hashCase := flag.String("hash-case", "lower", "Write the hex hashes in lower or upper case")
+	hashEncoding := flag.String("hash-encoding", "hex", "How hashes are written, hex or base64. base64 is a third shorter, which adds up on a big index")
+	hashFlag := flag.String("hash", "sha256", "The hash algorithm to use, one of md5, sha1, sha256, sha512, blake3, xxhash or crc32. blake3 gives the same sums as b3sum. xxhash and crc32 are much faster but only good for spotting changes, not tampering. Pass a comma separated list like md5,sha256 to get more than one")
+	timeFormat := flag.String("time-format", "", "How to write the modified time, one of go, rfc3339, unix or unixnano, or a Go layout like 2006-01-02. Defaults to go for csv, which is what goindex has always written. Only the named formats can be read back by -base, -verify and -diff")
+	workers := flag.Int("workers", defaults.Workers, "How many files to read and hash at the same time, defaults to the number of CPUs. Lower it for spinning or network disks")
+	walkWorkers := flag.Int("walk-workers", 1, "How many directories to read at the same time while walking. Raising this helps on trees with millions of directories or on network drives, 1 walks one directory at a time")
+	readWorkers := flag.Int("read-workers", 0, "How many files to read at the same time, defaults to -workers. Use 1 or 2 for spinning disks")
+	readWorkersMin := flag.Int("read-workers-min", 0, "With -read-workers-max, the fewest readers to drop to when files are slow to open. Defaults to 1, which is also what the pool starts at")
+	readWorkersMax := flag.Int("read-workers-max", 0, "Let the number of readers change as the run goes, adding them while files open quickly and halving them when opening slows down, up to this many. For storage you don't know the speed of, or a tree that spans a local disk and a network mount")
+	readLatencyTarget := flag.Duration("read-latency-target", 0, "With -read-workers-max, how long opening a file should take. Readers are added while it's quicker and taken away when it's more than twice this. Defaults to 5ms")
+	hashWorkers := flag.Int("hash-workers", 0, "How many files to hash at the same time, defaults to -workers. Raise it when the disk is faster than the CPU")
+	bufferSize := flag.String("buffer-size", "256k", "How much of a file to read at a time, like 64k or 1MB. Every hash worker keeps a handful of these around")
+	writeQueue := flag.Int("write-queue", defaults.WriteQueue, "How many finished records can be waiting to be written before the workers have to wait for the writer")
+
+	include := flag.String("include", "", "Comma separated glob patterns, only files whose name matches one of them are hashed, e.g. *.go,*.md")
+	exclude := flag.String("exclude", "", "Comma separated glob patterns, files whose name matches one of them are skipped. Beats -include if a file matches both")
+	ignoreCase := flag.Bool("ignore-case", false, "Match -include and -exclude without caring about case, so *.JPG matches photo.jpg too")
+	includeFrom := flag.String("include-from", "", "A file of glob patterns to add to -include, one per line. Blank lines and lines starting with # are ignored")
+	excludeFrom := flag.String("exclude-from", "", "A file of glob patterns to add to -exclude, one per line. Blank lines and lines starting with # are ignored")
+	includeRegex := flag.String("include-regex", "", "A regular expression matched against the whole path, only matching files are hashed. Files that match this or -include get in. On Windows / in the pattern matches the backslashes in the path too")
+	excludeRegex := flag.String("exclude-regex", "", "A regular expression matched against the whole path, matching files are skipped. Beats -include and -include-regex, same as -exclude")
+	excludeDir := flag.String("exclude-dir", "", "Comma separated directories to skip entirely. A bare name like node_modules is skipped anywhere, a path like /proc skips just that directory. Paths are compared the same way walkDir was given")
+	skipHidden := flag.Bool("skip-hidden", false, "Skip hidden files and don't descend into hidden directories. Hidden means the name starts with a dot, on Windows files with the hidden attribute are skipped as well")
+	gitIgnore := flag.Bool("gitignore", false, "Skip everything git would ignore, using the .gitignore files inside walkDir. The .git directory is skipped too")
+	minSize := flag.String("min-size", "", "Only hash files at least this big, like 4k or 100MB. Sizes are in powers of 1024 so 1KB is 1024 bytes")
+	maxSize := flag.String("max-size", "", "Only hash files at most this big, like 4k or 100MB. Sizes are in powers of 1024 so 1KB is 1024 bytes")
+	excludeEmpty := flag.Bool("exclude-empty", false, "Leave out files with nothing in them, they'd all be duplicates of each other with -dupes. The same as -min-size 1")
+	onlyEmpty := flag.Bool("only-empty", false, "Only index files with nothing in them, for finding stray empty files. The same as -max-size 0")
+	modifiedSince := flag.String("modified-since", "", "Only hash files modified since this time, either a timestamp like 2021-04-01T00:00:00Z or how long ago like 24h or 7d")
+	maxDepth := flag.Int("max-depth", defaults.MaxDepth, "How many directories deep to go below walkDir, 0 means only the files directly inside walkDir and -1 means no limit")
+	noRecurse := flag.Bool("no-recurse", false, "Only index the files directly inside walkDir and don't go into any directory under it, the same as -max-depth 0")
+
+	oneFilesystem := flag.Bool("one-filesystem", false, "Don't walk into directories on a different filesystem from walkDir, same as find -xdev. Keeps a walk of / out of /proc, /sys, network mounts and external drives. Does nothing on Windows")
+	hardlinkAware := flag.Bool("hardlink-aware", false, "Only read a file once however many hard links there are to it, every other path to it gets the same hash. Every path is still written. Does nothing on Windows")
+	followSymlinks := flag.Bool("follow-symlinks", false, "Walk into directories that symlinks point at. Every real directory is only walked once so symlink loops are fine")
+	resolveLinks := flag.Bool("resolve-links", false, "Record the path a symlink points at instead of the path of the link itself")
+	hashLinkTargets := flag.Bool("hash-link-targets", false, "Hash the file a symlink points at but write it down under the link's path, with a Type of symlink and a Target column with where it resolves to. Unlike -follow-symlinks it never walks into a directory. Broken links go in the error log")
+	recordSymlinks := flag.Bool("record-symlinks", false, "Write every symlink as a row of its own with a Type of symlink and a Target column saying where it points, instead of hashing what it points at. Broken links get a Type of broken-symlink and every other row a Type of file. Can't be used with -follow-symlinks or -resolve-links")
+	stripPrefix := flag.String("strip-prefix", "", "Cut this off the front of every recorded path, like /mnt/backup or D:\\backup. Either kind of slash works and rewritten paths always use /, so indexes from different machines compare cleanly. Paths that don't start with it are left alone")
+	appendHost := flag.Bool("append-host", false, "Add a Host column with this machine's name on every row, so indexes from a lot of machines can be merged and still queried per host")
+	hostname := flag.String("hostname", "", "What goes in the Host column instead of this machine's name, setting it turns the column on without -append-host")
+	addPrefix := flag.String("add-prefix", "", "Put this in front of every recorded path, after -strip-prefix has cut its part off. Use the same one on every machine to give them a shared logical root like backup")
+	relative := flag.Bool("relative", false, "Record paths relative to walkDir, or whichever -root they were found in, instead of absolute. Handy for comparing indexes taken on different machines")
+	base := flag.String("base", "", "A CSV index from an earlier run. Files whose size and modified time haven't changed since then get their hash copied from it instead of being hashed again")
+	sinceIndex := flag.String("since-index", "", "A CSV index from an earlier run, only files that are new or whose size or modified time changed since then are hashed and written, with a Change column of added or changed. Use it with -append to keep a journal of changes in one file")
+	noHeader := flag.Bool("no-header", false, "Don't write the header row at the top of a csv or tsv, for piping into tools that don't want one or adding to a dataset that already has one. -append and -resume already leave it out when the file has one")
+	table := flag.String("table", "", "The table sqlite creates or sql inserts into, defaults to files. sql can have a schema in front like public.files")
+	appendFlag := flag.Bool("append", false, "Add to the end of -output instead of replacing it. The header is only written if the file is empty")
+	update := flag.String("update", "", "A CSV index to bring up to date, the files in -paths get hashed again and the index is rewritten with their new rows in place of the old ones, sorted by path. Rows for anything not listed are kept as they are, and a listed file that has been deleted loses its row. Writes over the index itself unless you give -output, and only once the run has finished. Uses the hashes in the index unless you pass -hash")
+	resume := flag.Bool("resume", false, "Carry on from a run that didn't finish. Every file already in -output is skipped and the rest are added to the end of it. Use the same flags as the run you're resuming")
+	dupes := flag.Bool("dupes", false, "Only write out files that have the same hash as another file, grouped by hash. Groups on the first -hash if you gave more than one. Every record is kept in memory until the end, use -dupes-external on a huge tree")
+	verify := flag.String("verify", "", "A CSV index to check the files in walkDir against. Prints every file whose hash or size doesn't match, that's missing or that's new. Uses the hashes in the index unless you pass -hash")
+	verifyLive := flag.Bool("verify-parallel-report", false, "With -verify, print every problem to stderr as soon as it's found and the totals at the end, instead of only in the report once it's done")
+	diffMode := flag.Bool("diff", false, "Compare two CSV indexes instead of walking, run it like goindex -diff old.csv new.csv. Prints + for new files, - for removed files and ~ for changed files")
+	dupesExternal := flag.Bool("dupes-external", false, "Same as -dupes but the hashes are sorted through temporary files instead of every record being kept in memory, for trees with hundreds of millions of files. Gives you the same output, just slower")
+	tempDir := flag.String("temp-dir", "", "Where -sort and -dupes-external put their temporary files, defaults to the system's temporary directory. They add up to about the size of the index")
+	dedupePaths := flag.Bool("dedupe-paths", false, "Make sure no path is in the output more than once, keeping the last row for it, like when one -root is inside another. The output comes out sorted by path, big trees are sorted using temporary files")
+	sortFlag := flag.Bool("sort", false, "Write the files sorted by path, so indexing the same tree twice gives you an identical file. Nothing is written until the walk is done, big trees are sorted using temporary files")
+	checkpoint := flag.Duration("checkpoint", 0, "How often to flush everything written so far and sync it to disk, like 10s. A crash then loses at most that much work. Only for csv, tsv and ndjson without -sort, -dupes, -dir-hashes or -verify")
+	mmap := flag.Bool("mmap", false, "Hash big files straight out of a memory mapping instead of reading them, which is quicker for files that are already cached. Files under -mmap-threshold are read like normal")
+	headHash := flag.String("head-hash", "", "Only hash the first this much of every file, like 64KB, for a quick first pass at finding duplicates or changes. Files only match if they're the same size too. Can't be used with -verify, -base, -since-index or -chunks")
+	rateLimit := flag.String("rate-limit", "", "The most all of the workers together get to read a second, like 50MB/s, so a scan doesn't starve everything else on the server of disk. Sizes are in powers of 1024. Can't be used with -mmap")
+	mmapThreshold := flag.String("mmap-threshold", "16MB", "How big a file has to be to get memory mapped with -mmap, like 64MB")
+	fileTimeout := flag.Duration("file-timeout", 0, "Give up on any one file that takes longer than this to read, like 30s or 5m, and put it in the error log. Stops one huge file or a flaky mount from holding up a worker. 0 means no limit")
+	bytesLimit := flag.String("bytes-limit", "", "Stop starting on new files once this much has been indexed, like 10GB. A file counts once it has been started and the ones already started get finished, so it usually goes a little over. Good for sampling a huge tree or a benchmark that does the same amount of work every time")
+	retries := flag.Int("retries", 0, "How many more times to try a file that fails with an error that looks temporary, like EIO or a timeout on an NFS or SMB mount. Waits 100ms before the first retry and twice as long before every one after that. Missing files and permission errors are never retried")
+	retryChanged := flag.Bool("retry-changed", false, "Hash a file again if it changed while it was being hashed. Without this it still gets written but also goes in the error log, since the hash might not match its size")
+	includeSpecial := flag.Bool("include-special", false, "Read FIFOs, sockets and devices instead of skipping them. Each one gets 30 seconds before it's given up on and put in the error log")
+	timing := flag.Bool("timing", false, "Add a HashMs column with how many milliseconds every file took to read and hash, handy for finding files on a bad sector or a slow network share. It's hashMs in json and xml and hash_ms in sqlite, sql and parquet")
+	permissions := flag.Bool("permissions", false, "Add Owner, Group and Mode columns with who owns every file and its permissions like -rw-r--r--. Owner and group are left empty on Windows")
+	rollup := flag.Bool("rollup", false, "Print one hash for the whole scan in the summary, made from the sorted paths and first hashes of every file. Two scans with the same rollup have the same files with the same contents")
+	dirHashes := flag.Bool("dir-hashes", false, "Add a row for every directory with a hash of everything inside it, so a directory's hash only changes if something below it did. Adds a Type column of file or dir and sorts the output by path")
+	detectType := flag.Bool("detect-type", false, "Add a Mime column with the type of every file, like image/png or text/plain. It's worked out from the first 512 bytes while the file is being hashed")
+	chunks := flag.Bool("chunks", false, "Cut every file into content defined chunks and write a row per chunk with its hash, length and an Offset column, instead of one row per file. An edit in the middle of a file only changes the chunks around it, so matching chunk hashes show you what can be deduplicated")
+	chunkSize := flag.String("chunk-size", "1MB", "The average chunk size for -chunks, like 64k or 4MB. Chunks can be anywhere from a quarter to 8 times this")
+	statOnly := flag.Bool("stat-only", false, "Don't hash anything, just list the path, size and modified time of every file. The hash columns are still there, just empty")
+	watch := flag.Bool("watch", false, "Keep running after the index is written and update it whenever a file is created, changed or deleted. Every change is printed to stdout and the whole -output file is rewritten after each batch of changes. Stop it with Ctrl-C")
+	gzipFlag := flag.Bool("gzip", false, "Compress the output with gzip as it's written, .gz is added to the end of -output if it isn't there already. Can't be used with sqlite, -resume or -watch")
+	walkErrorsFatal := flag.Bool("walk-errors-fatal", false, "Stop with a failure at the first directory that can't be read, like one you don't have permission for, instead of skipping it and carrying on")
+	errorsInline := flag.Bool("errors-inline", false, "Put every file or directory that couldn't be read in the output too, with empty hashes and an Error column saying why. The column is on every row, empty for the files that worked")
+	errorLog := flag.String("error-log", "", "Write every file or directory that couldn't be read to this CSV file, along with the error")
+	limitFiles := flag.Int("limit-files", 0, "Stop once this many files have been indexed, after every filter like -exclude or -min-size. A file that can't be read doesn't count and another takes its place. Good for a quick smoke test or a sample of a huge tree. 0 means no limit")
+	timeout := flag.Duration("timeout", 0, "Stop after this long, like 30m or 2h. Files already being hashed get finished and the output is valid for everything done up to then. 0 means no limit")
+	cpuProfile := flag.String("cpuprofile", "", "Write a CPU profile of the run to this file, for go tool pprof. Shows whether the hashing, the walk or the writing is where the time goes")
+	memProfile := flag.String("memprofile", "", "Write a heap profile to this file at the end of the run, for go tool pprof")
+	showProgress := flag.Bool("progress", true, "Show progress bars on stderr. They're turned off automatically when stderr isn't a terminal unless you pass -progress=true")
+	progressOutput := flag.String("progress-output", "stderr", "Where to draw the progress bars, stderr, stdout, an open file descriptor like 3 or a file to write them to. Setting it turns the bars on even if it isn't a terminal, so the log stays clean when stderr is being captured")
+	progressRefresh := flag.Duration("progress-refresh", 0, "The least time between redraws of the progress bars, like 1s, for a slow terminal or SSH where redrawing all the time eats bandwidth. The count they end on is still exact. 0 is about 15 times a second")
+	indexDesc := flag.String("index-desc", "", "A label for the walking progress bar, like indexing")
+	hashDesc := flag.String("hash-desc", "", "A label for the hashing progress bar, like hashing")
+	progressFile := flag.Bool("progress-file", false, "Show the name of the file that was last started on after the hashing bar's label, handy for seeing which huge file it's stuck on")
+	progressBytes := flag.Bool("progress-bytes", false, "Have the hashing bar count bytes instead of files, which gives a much better ETA when file sizes vary a lot. Its rate is in MiB/s, the summary prints the same unit next to MB/s")
+	top := flag.Int("top", 10, "How many file extensions to list in the summary, biggest total size first. 0 turns the list off")
+	dryRun := flag.Bool("dry-run", false, "Walk and filter like a real run, then print how many files and bytes would have been hashed. Nothing is hashed and no output file is created")
+	countOnly := flag.Bool("count-only", false, "Walk and filter like a real run and print nothing but how many files matched, on stdout. Quicker than -dry-run since only symlinks get a stat unless -min-size, -max-size or -modified-since needs one")
+	quiet := flag.Bool("quiet", false, "Only print errors, no startup message or summary at the end of the run")
+	verbose := flag.Bool("verbose", false, "Print a line for every file as it's hashed with how long it took, handy for finding out why a run is slow. It all goes to stderr so it's fine with -output -")
 
 	// Allows you to run .\goindex.exe -h
 	flag.Usage = func() {
 		flag.PrintDefaults()
+		printExitCodes(flag.CommandLine.Output())
 	}
 
 	// Parse any passed flags into the respective variables
 	flag.Parse()
 
-	// Write the CSV header into our file
-	// You could change this to support more headers if you need them
-	writeToFile("Path, Hash, Time\n", handle)
-
-	err = godirwalk.Walk(*walkDir, &godirwalk.Options{
-		// A callback function similar to the go stdlib filepath.WalkDir
-		Callback: func(osPathname string, de *godirwalk.Dirent) error {
-			// Ignore directories since we're only looking for files
-			if !de.IsDir() {
-				// Increment our index progress bar so we know the program is working and we know how far along we are
-				indexBar.Add(1)
-				// Submit a function to our workgroup that we'll execute later
-				wp.Submit(func() {
-					// I literally googled `go sha256 hash file` and clicked the first stackoverflow link
-
-					// Get a hasher
-					h := sha256.New()
-
-					// Open the file
-					f, err := os.Open(osPathname)
-					if err != nil {
-						log.Panic(err)
-					}
-
-					// Defer closing of the file until the end of the function
-					defer f.Close()
-
-					// Copy file in to our hasher
-					if _, err := io.Copy(h, f); err != nil {
-						log.Fatal(err)
-					}
-
-					// Get file info
-					finfo, err := f.Stat()
-					if err != nil {
-						log.Fatal(err)
-					}
-
-					// Write the data we collected to the log file.
-					// This will append to our log file something like...
-					// C:\code\goindex\main.go, 23f3fa53025c860edf6f8e7d81b74973b4000dba388f74a5b93d52dafdc8077e, 2021-04-27 22:33:47.982338 +0000 UTC
-					writeToFile(fmt.Sprintf("%s, %s, %s\n", osPathname, hex.EncodeToString(h.Sum(nil)), finfo.ModTime().UTC().String()), handle)
-
-					// Increment the hashing progress bar
-					hashBar.Add(1)
-				})
+	// The config file only fills in the flags you didn't give, so you can keep your usual scan in a file and change one thing on the command line
+	// Everything after this treats a flag set by the file the same as one you typed
+	if *configFile != "" {
+		values, err := loadConfig(*configFile)
+		if err != nil {
+			exitWithError(fmt.Errorf("-config: %s", err))
+		}
+		onCommandLine := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) {
+			onCommandLine[f.Name] = true
+		})
+		if err := applyConfig(flag.CommandLine, values, onCommandLine); err != nil {
+			exitWithError(fmt.Errorf("-config %s: %s", *configFile, err))
+		}
+	}
+
+	if *quiet && *verbose {
+		exitWithError(errors.New("-quiet and -verbose can't be used together"))
+	}
+	logLevel := index.LogNormal
+	if *quiet {
+		logLevel = index.LogQuiet
+	} else if *verbose {
+		logLevel = index.LogVerbose
+	}
+	logger.SetLevel(logLevel)
+
+	// Diffing doesn't walk anything so it doesn't need any of the setup below
+	if *diffMode {
+		if flag.NArg() != 2 {
+			exitWithError(errors.New("-diff needs exactly two files, goindex -diff old.csv new.csv"))
+		}
+		oldIndex, err := index.LoadIndex(flag.Arg(0))
+		if err != nil {
+			exitWithError(err)
+		}
+		newIndex, err := index.LoadIndex(flag.Arg(1))
+		if err != nil {
+			exitWithError(err)
+		}
+		if err := index.Diff(oldIndex, newIndex, os.Stdout); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	hashNames, err := index.ParseHashNames(*hashFlag)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	opts := defaults
+	opts.WalkDir = *walkDir
+	// The default walkDir only applies when you didn't give any roots at all
+	if len(roots) > 0 {
+		if !flagWasSet("walkDir") {
+			opts.WalkDir, roots = roots[0], roots[1:]
+		}
+		opts.Roots = roots
+	}
+	opts.Hashes = hashNames
+	opts.Workers = *workers
+	// Fill these in here rather than leaving them at 0 so the summary shows what we actually ran with
+	opts.WalkWorkers = *walkWorkers
+	opts.ReadWorkers = *readWorkers
+	opts.ReadWorkersMin = *readWorkersMin
+	opts.ReadWorkersMax = *readWorkersMax
+	opts.ReadLatencyTarget = *readLatencyTarget
+	// An adaptive pool doesn't have one number of readers to show, it has the two ends
+	if opts.ReadWorkersMax > 0 && opts.ReadWorkersMin == 0 {
+		opts.ReadWorkersMin = 1
+	}
+	if opts.ReadWorkers == 0 && opts.ReadWorkersMax == 0 {
+		opts.ReadWorkers = *workers
+	}
+	opts.HashWorkers = *hashWorkers
+	if opts.HashWorkers == 0 {
+		opts.HashWorkers = *workers
+		if opts.ReadWorkersMax > opts.HashWorkers {
+			opts.HashWorkers = opts.ReadWorkersMax
+		}
+	}
+	opts.Format = *format
+	opts.Table = *table
+	opts.TimeFormat = *timeFormat
+	opts.WriteQueue = *writeQueue
+	opts.Include = splitList(*include)
+	opts.Exclude = splitList(*exclude)
+	opts.IgnoreCase = *ignoreCase
+	opts.ExcludeDirs = splitList(*excludeDir)
+	if *includeFrom != "" {
+		patterns, err := readPatternFile(*includeFrom)
+		if err != nil {
+			exitWithError(fmt.Errorf("-include-from: %s", err))
+		}
+		opts.Include = append(opts.Include, patterns...)
+	}
+	if *excludeFrom != "" {
+		patterns, err := readPatternFile(*excludeFrom)
+		if err != nil {
+			exitWithError(fmt.Errorf("-exclude-from: %s", err))
+		}
+		opts.Exclude = append(opts.Exclude, patterns...)
+	}
+	// Regexes can have commas in them, so these are one pattern each rather than a list
+	if *includeRegex != "" {
+		opts.IncludeRegex = []string{*includeRegex}
+	}
+	if *excludeRegex != "" {
+		opts.ExcludeRegex = []string{*excludeRegex}
+	}
+	opts.SkipHidden = *skipHidden
+	opts.GitIgnore = *gitIgnore
+	opts.MaxDepth = *maxDepth
+	if *noRecurse {
+		if flagWasSet("max-depth") && *maxDepth != 0 {
+			exitWithError(errors.New("-no-recurse can't be used with -max-depth, it's the same as -max-depth 0"))
+		}
+		opts.MaxDepth = 0
+	}
+	opts.FollowSymlinks = *followSymlinks
+	opts.HardlinkAware = *hardlinkAware
+	opts.OneFilesystem = *oneFilesystem
+	opts.ResolveLinks = *resolveLinks
+	opts.RecordSymlinks = *recordSymlinks
+	opts.HashLinkTargets = *hashLinkTargets
+	opts.StripPrefix = *stripPrefix
+	opts.AddPrefix = *addPrefix
+	opts.Relative = *relative
+	opts.Dupes = *dupes || *dupesExternal
+	opts.DupesExternal = *dupesExternal
+	opts.TempDir = *tempDir
+	opts.StatOnly = *statOnly
+	opts.DetectType = *detectType
+	opts.DirHashes = *dirHashes
+	opts.Rollup = *rollup
+	opts.ErrorsInline = *errorsInline
+	opts.WalkErrorsFatal = *walkErrorsFatal
+	opts.Chunks = *chunks
+	opts.Permissions = *permissions
+	opts.Timing = *timing
+	opts.IncludeSpecial = *includeSpecial
+	opts.RetryChanged = *retryChanged
+	opts.Retries = *retries
+	opts.FileTimeout = *fileTimeout
+	opts.FileLimit = *limitFiles
+	opts.Checkpoint = *checkpoint
+	opts.Sort = *sortFlag
+	opts.DedupePaths = *dedupePaths
+	// A count is a dry run that doesn't bother with the sizes
+	if *countOnly {
+		*dryRun = true
+		opts.CountOnly = true
+	}
+	opts.DryRun = *dryRun
+	// The bars only get drawn on a terminal unless you asked for them by saying where they should go
+	progressHandle, err := openProgressOutput(*progressOutput)
+	if err != nil {
+		exitWithError(err)
+	}
+	defer progressHandle.Close()
+	opts.ProgressOutput = progressHandle
+	opts.ShowProgress = *showProgress && (flagWasSet("progress") || flagWasSet("progress-output") || term.IsTerminal(int(progressHandle.Fd())))
+	opts.ProgressBytes = *progressBytes
+	opts.ProgressRefresh = *progressRefresh
+	opts.IndexDescription = *indexDesc
+	opts.HashDescription = *hashDesc
+	opts.ProgressCurrentFile = *progressFile
+	opts.LogLevel = logLevel
+
+	bufferBytes, err := parseSize(*bufferSize)
+	if err != nil {
+		exitWithError(fmt.Errorf("-buffer-size: %s", err))
+	}
+	opts.BufferSize = int(bufferBytes)
+	chunkBytes, err := parseSize(*chunkSize)
+	if err != nil {
+		exitWithError(fmt.Errorf("-chunk-size: %s", err))
+	}
+	opts.ChunkSize = int(chunkBytes)
+	if *minSize != "" {
+		if opts.MinSize, err = parseSize(*minSize); err != nil {
+			exitWithError(fmt.Errorf("-min-size: %s", err))
+		}
+	}
+	if *maxSize != "" {
+		if opts.MaxSize, err = parseSize(*maxSize); err != nil {
+			exitWithError(fmt.Errorf("-max-size: %s", err))
+		}
+	}
+	if *excludeEmpty && opts.MinSize < 1 {
+		opts.MinSize = 1
+	}
+	if *onlyEmpty {
+		if *excludeEmpty || opts.MinSize > 0 {
+			exitWithError(errors.New("-only-empty can't be used with -exclude-empty or -min-size, there'd be nothing left"))
+		}
+		opts.MaxSize = 0
+	}
+	if *headHash != "" {
+		if opts.HeadBytes, err = parseSize(*headHash); err != nil {
+			exitWithError(fmt.Errorf("-head-hash: %s", err))
+		}
+	}
+	if *rateLimit != "" {
+		// The /s is optional, it's always a second
+		perSecond := strings.TrimSuffix(strings.TrimSuffix(*rateLimit, "/s"), "/S")
+		if opts.RateLimit, err = parseSize(perSecond); err != nil {
+			exitWithError(fmt.Errorf("-rate-limit: %s", err))
+		}
+	}
+	if *mmap {
+		if opts.MmapThreshold, err = parseSize(*mmapThreshold); err != nil {
+			exitWithError(fmt.Errorf("-mmap-threshold: %s", err))
+		}
+		// 0 would turn it back off, the smallest a file can be and still get mapped is a byte
+		if opts.MmapThreshold == 0 {
+			opts.MmapThreshold = 1
+		}
+	}
+	if *bytesLimit != "" {
+		if opts.BytesLimit, err = parseSize(*bytesLimit); err != nil {
+			exitWithError(fmt.Errorf("-bytes-limit: %s", err))
+		}
+	}
+
+	// Giving the name is enough to want the column, -append-host is for when the machine's own name will do
+	switch {
+	case *hostname != "":
+		opts.Host = *hostname
+	case *appendHost:
+		if opts.Host, err = os.Hostname(); err != nil {
+			exitWithError(fmt.Errorf("-append-host: %s", err))
+		}
+	}
+
+	if *modifiedSince != "" {
+		if opts.ModifiedSince, err = parseSince(*modifiedSince, time.Now()); err != nil {
+			exitWithError(fmt.Errorf("-modified-since: %s", err))
+		}
+	}
+
+	// Load the index from last time up front, if it's broken or doesn't have the hashes we need there's no point starting
+	if *base != "" {
+		if opts.Base, err = index.LoadIndex(*base); err != nil {
+			exitWithError(err)
+		}
+	}
+
+	// The list is read as we go, so a huge one never has to fit in memory
+	switch {
+	case *fromStdin && *fromStdin0:
+		exitWithError(errors.New("-from-stdin and -from-stdin0 can't be used together"))
+	case *pathsFile != "" && (*fromStdin || *fromStdin0):
+		exitWithError(errors.New("-paths can't be used with -from-stdin or -from-stdin0, there's only the one list"))
+	case *pathsFile != "":
+		list, err := os.Open(*pathsFile)
+		if err != nil {
+			exitWithError(err)
+		}
+		defer list.Close()
+		opts.PathList = list
+	case *fromStdin:
+		opts.PathList = os.Stdin
+	case *fromStdin0:
+		opts.PathList, opts.PathListNul = os.Stdin, true
+	}
+	// The list decides what gets hashed, so walkDir is only there for -relative
+	if opts.PathList != nil && len(roots) > 0 {
+		exitWithError(errors.New("-root can't be used with a list of paths from stdin or -paths"))
+	}
+
+	// Same as verify, the hashes to write are the ones the index already has
+	if *update != "" {
+		if opts.Update, err = index.LoadIndex(*update); err != nil {
+			exitWithError(err)
+		}
+		if !flagWasSet("hash") {
+			opts.Hashes = opts.Update.HashNames()
+		}
+		switch {
+		case *appendFlag || *resume:
+			exitWithError(errors.New("-update rewrites the whole index, it can't be used with -append or -resume"))
+		case *gzipFlag:
+			exitWithError(errors.New("-gzip can't be used with -update, the index being updated is a plain csv"))
+		case *watch:
+			exitWithError(errors.New("-update can't be used with -watch, watching keeps the whole index up to date already"))
+		}
+	}
+
+	// Everything below that looks at the output by name needs to be looking at the file we're actually going to write
+	if *gzipFlag {
+		switch {
+		case strings.EqualFold(opts.Format, "sqlite"):
+			exitWithError(errors.New("-gzip can't be used with sqlite, the database has to be a file sqlite can open"))
+		case *resume:
+			exitWithError(errors.New("-gzip can't be used with -resume, the half written index can't be read back"))
+		case *watch:
+			exitWithError(errors.New("-gzip can't be used with -watch"))
+		}
+		*output = gzipName(*output)
+	}
+
+	if *sinceIndex != "" {
+		if opts.SinceIndex, err = index.LoadIndex(*sinceIndex); err != nil {
+			exitWithError(err)
+		}
+	}
+
+	// Whatever the last run managed to write is what we skip, if it never got as far as creating the file we just start from scratch
+	// A half written last line would get glued to the first new record, so that gets cut off first
+	if *resume {
+		if *output == "-" {
+			exitWithError(errors.New("-resume needs -output to be a file"))
+		}
+		if info, err := os.Stat(*output); err == nil && info.Size() > 0 {
+			if err := index.TrimPartialLine(*output); err != nil {
+				exitWithError(err)
 			}
+		}
+		if info, err := os.Stat(*output); err == nil && info.Size() > 0 {
+			if opts.Resume, err = index.LoadIndex(*output); err != nil {
+				exitWithError(err)
+			}
+		}
+	}
+
+	// csv and tsv are the only formats with a header row you'd want to leave out, sqlite needs its table created
+	if *noHeader {
+		if !strings.EqualFold(opts.Format, "csv") && !strings.EqualFold(opts.Format, "tsv") {
+			exitWithError(fmt.Errorf("-no-header only applies to csv and tsv, %s doesn't have a header row", opts.Format))
+		}
+		opts.NoHeader = true
+	}
+
+	// Adding on to a file that already has a header in it mustn't give it a second one
+	// A sql script is the exception, the next one goes on the end of it with a BEGIN and COMMIT of its own
+	if *appendFlag && *output != "-" && !strings.EqualFold(opts.Format, "sql") {
+		if info, err := os.Stat(*output); err == nil && info.Size() > 0 {
+			opts.NoHeader = true
+		}
+	}
+
+	// Verifying re-hashes everything with whatever the index was made with, unless you want to check just one of its hashes
+	if *verify != "" {
+		if opts.Verify, err = index.LoadIndex(*verify); err != nil {
+			exitWithError(err)
+		}
+		if !flagWasSet("hash") {
+			opts.Hashes = opts.Verify.HashNames()
+		}
+		if *verifyLive {
+			opts.VerifyLive = os.Stderr
+		}
+	}
+
+	// The library only has the one setting for both of these
+	switch {
+	case strings.EqualFold(*hashEncoding, "base64") && !strings.EqualFold(*hashCase, "lower"):
+		exitWithError(errors.New("-hash-case only applies to hex, base64 hashes are case sensitive"))
+	case !strings.EqualFold(*hashEncoding, "hex"):
+		opts.HashEncoding = *hashEncoding
+	case strings.EqualFold(*hashCase, "upper"):
+		opts.HashEncoding = "upper"
+	case !strings.EqualFold(*hashCase, "lower"):
+		exitWithError(fmt.Errorf("-hash-case has to be lower or upper, got %q", *hashCase))
+	}
+
+	// Make sure everything makes sense before we do anything else
+	// Otherwise we'd create the output file just to find out we can't write it, or every single worker would fail halfway through the walk
+	if err := opts.Validate(); err != nil {
+		exitWithError(err)
+	}
+
+	if *dryRun && *watch {
+		exitWithError(errors.New("-dry-run and -watch can't be used together"))
+	}
+
+	if opts.CountOnly {
+		logger.Infof("Counting files without hashing or writing anything")
+	} else if opts.DryRun {
+		logger.Infof("Dry run, counting files without hashing or writing anything")
+	} else if opts.StatOnly {
+		logger.Infof("Listing files without hashing them")
+	} else if opts.ReadWorkersMax > 0 {
+		logger.Infof("Hashing files with %s using %d to %d read and %d hash workers", strings.Join(opts.Hashes, ", "), opts.ReadWorkersMin, opts.ReadWorkersMax, opts.HashWorkers)
+	} else {
+		logger.Infof("Hashing files with %s using %d read and %d hash workers", strings.Join(opts.Hashes, ", "), opts.ReadWorkers, opts.HashWorkers)
+	}
+
+	// Watching keeps rewriting the output by name, so it doesn't want it opened and it can't be stdout
+	if *watch && *output == "-" {
+		exitWithError(errors.New("-watch needs -output to be a file"))
+	}
+
+	// Open a file that we can write to, or use stdout if we were given -output -
+	// The verify report is for you to read, so it goes to stdout unless you ask for it somewhere else
+	if *verify != "" && !flagWasSet("output") {
+		*output = "-"
+	}
+	if opts.ShowProgress && *progressOutput == "stdout" && *output == "-" && !*dryRun && !*watch {
+		exitWithError(errors.New("-progress-output stdout can't be used when the output is going to stdout too, the bars would end up in it"))
+	}
+	var handle *os.File
+	// Without -output an update goes to a file next to the index that's only created right before Index runs, see below
+	updateInPlace := opts.Update != nil && !flagWasSet("output")
+	if !updateInPlace && !*watch && !*dryRun {
+		if handle, err = openOutput(*output, *appendFlag || opts.Resume != nil); err != nil {
+			exitWithError(err)
+		}
+		// Defer closing of the file until the end of main
+		// Closing stdout is harmless here since we're done with it by then
+		defer handle.Close()
+	}
+
+	// Index only ever sees the gzip writer, it flushes what it wrote into it but closing it is up to us
+	// Until it's closed the end of the gzip stream isn't written and the file can't be decompressed, see finishOutput
+	var out io.Writer = handle
+	var gz *gzip.Writer
+	if *gzipFlag && handle != nil {
+		gz = gzip.NewWriter(handle)
+		out = gz
+	}
+	// Called once Index is done, whether it finished or was interrupted, so the file is always complete
+	finishOutput := func() error {
+		if gz == nil {
 			return nil
-		},
-		// Callback for any errors we recieve when we're indexing, you could log these to a different file you if you wanted to
-		ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
-			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
-			return godirwalk.SkipNode
-		},
-		Unsorted: true,
-	})
+		}
+		return gz.Close()
+	}
 
-	// Check to see if the walk function itself returned any errors
+	// Same treatment for the error log, it goes next to the output so it's the same kind of file
+	if *errorLog != "" {
+		errorHandle, err := openOutput(*errorLog, false)
+		if err != nil {
+			exitWithError(err)
+		}
+		defer errorHandle.Close()
+		opts.ErrorLog = errorHandle
+	}
+
+	// Everything from here on is the run itself, that's what the profiles are of
+	stopProfiling, err := startProfiling(*cpuProfile, *memProfile)
 	if err != nil {
-		panic(err)
+		exitWithError(err)
+	}
+	defer stopProfiling()
+
+	// This context gets cancelled if you hit Ctrl-C or the timeout runs out, the library checks it so we can stop early but still leave a usable file behind
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	ctx, interrupt := context.WithCancel(ctx)
+	defer interrupt()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		<-sigs
+		logger.Infof("\nInterrupted, finishing the files already being hashed. Press Ctrl-C again to quit immediately")
+		// Stop listening so a second Ctrl-C gets the default behaviour and kills us straight away
+		signal.Stop(sigs)
+		interrupt()
+	}()
+
+	// Ctrl-C is the normal way to stop watching, so that's not a failure, and neither is the timeout running out
+	if *watch {
+		if err := index.Watch(ctx, opts, *output, os.Stdout); err != nil {
+			stopProfiling()
+			logger.Errorf("%s", err)
+			os.Exit(exitCode(err, index.Stats{}, false))
+		}
+		return
+	}
+
+	// The update is written next to the index and renamed over it once it's done, so an interrupted or failed run
+	// leaves the old index exactly as it was. It's made last so nothing that can still exitWithError leaves it lying around,
+	// from here on finishUpdate always either renames it or removes it
+	updateTemp := ""
+	if updateInPlace {
+		if handle, err = createUpdateTemp(*update); err != nil {
+			stopProfiling()
+			exitWithError(err)
+		}
+		updateTemp = handle.Name()
+		out = handle
+		defer handle.Close()
 	}
 
-	// Now that we know how many functions we have queued to run we can
-	// initialize our hashing progress bar with the amount waiting in the queue
-	hashBar = progressbar.Default(int64(wp.WaitingQueueSize()))
+	// This is where all of the actual work happens
+	stats, err := index.Index(ctx, opts, out)
+	if closeErr := finishOutput(); err == nil {
+		err = closeErr
+	}
+	if updateTemp != "" {
+		if err = finishUpdate(handle, updateTemp, *update, err); err != nil {
+			logger.Errorf("%s was left as it was", *update)
+		}
+	}
+	// An interrupted run still gets its profiles, that's often the one you want them for
+	stopProfiling()
 
-	// Cancel our context which will cause our workerpool to start working
-	cancel()
+	// A dry run is all summary, so even -quiet doesn't hide it
+	// The count goes to stdout on its own so a script can use it as it is
+	if opts.CountOnly {
+		fmt.Println(stats.Files + stats.Symlinks)
+	} else if *dryRun {
+		printDryRun(stats, *top)
+	} else {
+		printSummary(stats, opts, *top)
+	}
 
-	// Stop our workerpool and wait for all queued functions to complete
-	wp.StopWait()
+	// A verify run that found something wrong has failed, even if every file was read just fine
+	// With -verify-parallel-report the totals already went to stderr at the end of the report, once is enough
+	if opts.Verify != nil && opts.VerifyLive == nil {
+		logger.Infof("Mismatched:   %d\nResized:      %d\nMissing:      %d\nNew:          %d", stats.Mismatched, stats.Resized, stats.Missing, stats.Added)
+	}
+
+	// After an interrupt the file is valid but it's missing whatever we didn't get to, so let whoever ran us know
+	// Same goes for any file we couldn't read, it's in the error log but not in the output
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		logger.Errorf("timed out after %s, the output only has the files hashed before then", *timeout)
+	case err != nil && !errors.Is(err, context.Canceled):
+		logger.Errorf("%s", err)
+	}
+	if code := exitCode(err, stats, opts.Verify != nil); code != exitOK {
+		// os.Exit skips the deferred closes, so the output has to be closed here
+		handle.Close()
+		os.Exit(code)
+	}
 }
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"goindex/index"
+)
+
+// Indexes dir into name the way main does, through openOutput
+func indexInto(t *testing.T, dir, name string, appendTo bool) {
+	t.Helper()
+	f, err := openOutput(name, appendTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	opts := index.DefaultOptions()
+	opts.WalkDir = dir
+	opts.NoHeader = appendTo
+	if _, err := index.Index(context.Background(), opts, f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A second run starts the file over, nothing from the first one is left in it
+func TestOutputTruncated(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output := filepath.Join(t.TempDir(), "files.csv")
+	indexInto(t, dir, output, false)
+	os.Remove(filepath.Join(dir, "b.txt"))
+	os.Remove(filepath.Join(dir, "c.txt"))
+	indexInto(t, dir, output, false)
+
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "Path,") || !strings.HasPrefix(lines[1], filepath.Join(dir, "a.txt")+",") {
+		t.Errorf("got\n%s\nwant the header and a.txt", data)
+	}
+	info, err := os.Stat(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0111 != 0 {
+		t.Errorf("the output is executable, it's %s", info.Mode().Perm())
+	}
+
+	// -append is the one way to keep what was there
+	indexInto(t, dir, output, true)
+	data, _ = ioutil.ReadFile(output)
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 3 || strings.Count(string(data), "Path,") != 1 {
+		t.Errorf("appending got\n%s", data)
+	}
+}
+
+func TestOutputMissingDirectory(t *testing.T) {
+	_, err := openOutput(filepath.Join(t.TempDir(), "nowhere", "files.csv"), false)
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("got %v, want it to say the directory is missing", err)
+	}
+}
+
+func TestGzipName(t *testing.T) {
+	for name, want := range map[string]string{"files.csv": "files.csv.gz", "files.csv.gz": "files.csv.gz", "-": "-", "index": "index.gz"} {
+		if got := gzipName(name); got != want {
+			t.Errorf("gzipName(%q) is %q, want %q", name, got, want)
+		}
+	}
+}
+
+// What -gzip writes decompresses back to the same rows, and closing the gzip writer after a cancelled run still finishes the stream
+func TestGzipOutput(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 200; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("f%03d.txt", i)), []byte(fmt.Sprint(i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, cancelled := range []bool{false, true} {
+		output := gzipName(filepath.Join(t.TempDir(), "files.csv"))
+		f, err := openOutput(output, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := index.DefaultOptions()
+		opts.WalkDir = dir
+		var hashed int32
+		opts.OnFileHashed = func(path string, size int64) {
+			if atomic.AddInt32(&hashed, 1) == 20 && cancelled {
+				cancel()
+			}
+		}
+		// Same as main, Index only sees the gzip writer and the file gets closed after it
+		gz := gzip.NewWriter(f)
+		_, err = index.Index(ctx, opts, gz)
+		cancel()
+		if (err != nil) != cancelled {
+			t.Errorf("cancelled is %v but got %v", cancelled, err)
+		}
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		f, err = os.Open(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := csv.NewReader(r).ReadAll()
+		f.Close()
+		if err != nil {
+			t.Fatalf("cancelled %v: %s", cancelled, err)
+		}
+		if len(rows) == 0 || strings.Join(rows[0], ",") != "Path,SHA256 Hash,Time,Size" {
+			t.Fatalf("cancelled %v got %v", cancelled, rows)
+		}
+		for _, row := range rows[1:] {
+			content, err := ioutil.ReadFile(row[0])
+			if err != nil || fmt.Sprintf("%x", sha256.Sum256(content)) != row[1] {
+				t.Errorf("bad row %v", row)
+			}
+		}
+		if n := len(rows) - 1; (n == 200) == cancelled || (cancelled && n < 20) {
+			t.Errorf("cancelled %v got %d rows", cancelled, n)
+		}
+	}
+}
+
+// Comments and blank lines are skipped, Windows line endings and indenting come off, and what's left merges with the inline patterns
+func TestPatternFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "exclude.txt")
+	contents := "# build output\n*.o\n\n   \n  *.log  \r\n# not a pattern\n#*.txt\nbuild/*\n"
+	if err := ioutil.WriteFile(name, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	patterns, err := readPatternFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"*.o", "*.log", "build/*"}; strings.Join(patterns, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", patterns, want)
+	}
+	if _, err := readPatternFile(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Errorf("a pattern file that isn't there wasn't an error")
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"keep.txt", "main.o", "run.log", "notes.tmp"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := index.DefaultOptions()
+	opts.WalkDir = dir
+	opts.Exclude = append(splitList("*.tmp"), patterns...)
+	var out strings.Builder
+	if _, err := index.Index(context.Background(), opts, &out); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1][0] != filepath.Join(dir, "keep.txt") {
+		t.Errorf("got %v, want only keep.txt", rows)
+	}
+}
+
+// The bars go to the file -progress-output names and none of it ends up in the index
+func TestProgressOutput(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 20; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("f%02d.txt", i)), []byte(fmt.Sprint(i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := filepath.Join(t.TempDir(), "progress.log")
+	progress, err := openProgressOutput(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := index.DefaultOptions()
+	opts.WalkDir = dir
+	opts.ShowProgress = true
+	opts.ProgressOutput = progress
+	var out strings.Builder
+	if _, err := index.Index(context.Background(), opts, &out); err != nil {
+		t.Fatal(err)
+	}
+	progress.Close()
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil || len(data) == 0 {
+		t.Errorf("nothing in the progress file, %v", err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil || len(rows) != 21 {
+		t.Fatalf("the index isn't 20 clean rows, got %d and %v:\n%s", len(rows), err, out.String())
+	}
+	for _, row := range rows[1:] {
+		if !strings.HasPrefix(row[0], dir) {
+			t.Errorf("got a row that isn't a file: %q", row)
+		}
+	}
+}
+
+func TestProgressOutputNames(t *testing.T) {
+	for name, want := range map[string]*os.File{"stderr": os.Stderr, "stdout": os.Stdout} {
+		if f, err := openProgressOutput(name); err != nil || f != want {
+			t.Errorf("%s gave %v and %v", name, f, err)
+		}
+	}
+	// A descriptor that's already open is written to as it is
+	f, err := ioutil.TempFile(t.TempDir(), "fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	byFd, err := openProgressOutput(fmt.Sprint(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byFd.WriteString("bar")
+	if data, _ := ioutil.ReadFile(f.Name()); string(data) != "bar" {
+		t.Errorf("writing to fd %d got %q into the file", f.Fd(), data)
+	}
+	for _, bad := range []string{"-1", "9999"} {
+		if _, err := openProgressOutput(bad); err == nil {
+			t.Errorf("%s was allowed as a file descriptor", bad)
+		}
+	}
+}
+
+// -no-recurse only gets walkDir's own files however deep the tree goes, and it won't go with a different -max-depth
+func TestNoRecurse(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"top1.txt", "top2.txt", "sub/one.txt", "sub/deeper/two.txt"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output := filepath.Join(t.TempDir(), "files.csv")
+	if code, out := runGoindex(t, "-walkDir", dir, "-output", output, "-no-recurse"); code != exitOK {
+		t.Fatalf("exited with %d:\n%s", code, out)
+	}
+	if got := outputNames(t, output); got != "top1.txt,top2.txt" {
+		t.Errorf("got %s, want only the two top level files", got)
+	}
+
+	if code, _ := runGoindex(t, "-walkDir", dir, "-output", output, "-no-recurse", "-max-depth", "2"); code != exitFailed {
+		t.Errorf("-no-recurse with -max-depth 2 exited with %d, want %d", code, exitFailed)
+	}
+	if code, _ := runGoindex(t, "-walkDir", dir, "-output", output, "-no-recurse", "-max-depth", "0"); code != exitOK {
+		t.Errorf("-no-recurse with -max-depth 0 exited with %d, they mean the same thing", code)
+	}
+}
+
+// The file names in a CSV index, sorted and joined with commas
+func outputNames(t *testing.T, output string) string {
+	t.Helper()
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, row := range rows[1:] {
+		names = append(names, filepath.Base(row[0]))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestEmptyFilters(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"empty1": "", "empty2": "", "sub/empty3": "", "one": "1", "sub/more": "more"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output := filepath.Join(t.TempDir(), "files.csv")
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "empty1,empty2,empty3,more,one"},
+		{[]string{"-exclude-empty"}, "more,one"},
+		{[]string{"-only-empty"}, "empty1,empty2,empty3"},
+		// A bigger -min-size still counts, -exclude-empty only raises it to 1
+		{[]string{"-exclude-empty", "-min-size", "2"}, "more"},
+		{[]string{"-only-empty", "-max-size", "10"}, "empty1,empty2,empty3"},
+	} {
+		args := append([]string{"-walkDir", dir, "-output", output}, tt.args...)
+		if code, out := runGoindex(t, args...); code != exitOK {
+			t.Fatalf("%v exited with %d:\n%s", tt.args, code, out)
+		}
+		if got := outputNames(t, output); got != tt.want {
+			t.Errorf("%v got %s, want %s", tt.args, got, tt.want)
+		}
+	}
+
+	for _, args := range [][]string{{"-only-empty", "-exclude-empty"}, {"-only-empty", "-min-size", "1"}} {
+		if code, _ := runGoindex(t, append([]string{"-walkDir", dir, "-output", output}, args...)...); code != exitFailed {
+			t.Errorf("%v exited with %d, want %d", args, code, exitFailed)
+		}
+	}
+}
+
+// An -update that stops before the run starts doesn't leave its temporary file next to the index, and one that runs
+// puts the new index in place of the old one
+func TestUpdateTempRemoved(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	indexDir := t.TempDir()
+	idx := filepath.Join(indexDir, "idx.csv")
+	indexInto(t, dir, idx, false)
+	paths := filepath.Join(t.TempDir(), "paths.txt")
+	if err := ioutil.WriteFile(paths, []byte(filepath.Join(dir, "a.txt")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	leftOver := func() []string {
+		matches, _ := filepath.Glob(filepath.Join(indexDir, "*.tmp"))
+		return matches
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	for _, args := range [][]string{
+		{"-watch"},
+		{"-error-log", filepath.Join(missing, "errors.csv")},
+		{"-cpuprofile", filepath.Join(missing, "cpu.prof")},
+	} {
+		args = append([]string{"-walkDir", dir, "-update", idx, "-paths", paths}, args...)
+		if code, out := runGoindex(t, args...); code != exitFailed {
+			t.Errorf("%v exited with %d, want %d:\n%s", args, code, exitFailed, out)
+		}
+		if left := leftOver(); len(left) != 0 {
+			t.Errorf("%v left %v behind", args, left)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code, out := runGoindex(t, "-walkDir", dir, "-update", idx, "-paths", paths); code != exitOK {
+		t.Fatalf("the update exited with %d:\n%s", code, out)
+	}
+	data, err := ioutil.ReadFile(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("changed"))
+	if !strings.Contains(string(data), hex.EncodeToString(sum[:])) {
+		t.Errorf("the index wasn't updated:\n%s", data)
+	}
+	if left := leftOver(); len(left) != 0 {
+		t.Errorf("the update left %v behind", left)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+)
+
+// Starts -cpuprofile and gets ready for -memprofile, either can be empty to leave it off
+// The function that comes back stops the CPU profile and writes the heap profile, it's safe to call more than once
+// so main can call it before os.Exit, which skips the deferred one, as well as deferring it
+func startProfiling(cpuProfile, memProfile string) (func(), error) {
+	var cpuFile *os.File
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return nil, fmt.Errorf("-cpuprofile: %s", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("-cpuprofile: %s", err)
+		}
+		cpuFile = f
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if cpuFile != nil {
+				pprof.StopCPUProfile()
+				cpuFile.Close()
+			}
+			if memProfile != "" {
+				writeHeapProfile(memProfile)
+			}
+		})
+	}, nil
+}
+
+// The heap as it is at the end of the run, after a GC so it's what's still in use rather than whatever hasn't been collected yet
+func writeHeapProfile(name string) {
+	f, err := os.Create(name)
+	if err != nil {
+		logger.Errorf("-memprofile: %s", err)
+		return
+	}
+	defer f.Close()
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		logger.Errorf("-memprofile: %s", err)
+	}
+}
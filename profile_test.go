@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Both profiles get written and they're pprof's gzipped protobufs, on a run that finishes and one that times out
+func TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), bytes.Repeat([]byte("a"), 1<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name  string
+		extra []string
+		want  int
+	}{
+		{"finished", nil, exitOK},
+		{"timed out", []string{"-timeout", "1ns"}, exitInterrupted},
+	} {
+		profiles := t.TempDir()
+		cpu, mem := filepath.Join(profiles, "cpu.prof"), filepath.Join(profiles, "mem.prof")
+		args := append([]string{"-walkDir", dir, "-output", filepath.Join(profiles, "files.csv"), "-cpuprofile", cpu, "-memprofile", mem}, tt.extra...)
+		if code, out := runGoindex(t, args...); code != tt.want {
+			t.Fatalf("%s: exited with %d, want %d:\n%s", tt.name, code, tt.want, out)
+		}
+		for _, name := range []string{cpu, mem} {
+			data, err := ioutil.ReadFile(name)
+			if err != nil {
+				t.Errorf("%s: %s", tt.name, err)
+				continue
+			}
+			if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+				t.Errorf("%s: %s is %d bytes and doesn't look like a profile", tt.name, filepath.Base(name), len(data))
+			}
+		}
+	}
+
+	// Better to find out before a long run than after it
+	missing := filepath.Join(t.TempDir(), "missing", "cpu.prof")
+	if code, _ := runGoindex(t, "-walkDir", dir, "-output", filepath.Join(t.TempDir(), "files.csv"), "-cpuprofile", missing); code != exitFailed {
+		t.Errorf("a -cpuprofile that can't be created exited with %d, want %d", code, exitFailed)
+	}
+}
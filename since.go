@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Turns -modified-since into an actual point in time
+// You can give it a timestamp like 2021-04-01T00:00:00Z, or how far back to go like 24h or 90m which is counted back from now
+// Go durations stop at hours so we also take days, 7d is a week ago
+func parseSince(s string, now time.Time) (time.Time, error) {
+	value := strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.ParseFloat(strings.TrimSuffix(value, "d"), 64); err == nil && days >= 0 {
+			return now.Add(-time.Duration(days * float64(24*time.Hour))), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q isn't a time, expected a timestamp like 2021-04-01T00:00:00Z or a duration like 24h or 7d", s)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2021, 4, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		s    string
+		want time.Time
+	}{
+		{"2021-04-01T00:00:00Z", time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"2021-04-01T02:00:00+02:00", time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"24h", now.Add(-24 * time.Hour)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"7d", now.AddDate(0, 0, -7)},
+		{"1.5d", now.Add(-36 * time.Hour)},
+	}
+	for _, test := range tests {
+		got, err := parseSince(test.s, now)
+		if err != nil || !got.Equal(test.want) {
+			t.Errorf("%q got %s and %v, want %s", test.s, got, err, test.want)
+		}
+	}
+	for _, s := range []string{"", "yesterday", "-24h", "-1d", "2021-04-01"} {
+		if _, err := parseSince(s, now); err == nil {
+			t.Errorf("%q was taken as a time", s)
+		}
+	}
+}
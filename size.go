@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Every suffix parseSize understands, these are all powers of 1024 so 1KB is 1024 bytes, same as Windows shows you
+var sizeSuffixes = []struct {
+	suffix     string
+	multiplier int64
+}{
+	// The longer ones need to come first so "MB" doesn't get treated as "B"
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"tb", 1 << 40},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30}, {"t", 1 << 40},
+	{"b", 1},
+}
+
+// Turns a human friendly size like 100MB, 4k or 1.5G into bytes
+// A number without a suffix is just bytes
+func parseSize(s string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, suf := range sizeSuffixes {
+		if strings.HasSuffix(value, suf.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, suf.suffix))
+			multiplier = suf.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	// ParseFloat is happy with nan, inf and 1e30 too, none of which fit in an int64. MaxInt64 comes out as 2^63 as a float
+	// so anything that gets to it is already too big
+	if err != nil || n < 0 || math.IsNaN(n) || math.IsInf(n, 0) || n*float64(multiplier) >= math.MaxInt64 {
+		return 0, fmt.Errorf("%q isn't a size, expected something like 4096, 4k, 100MB or 1.5GB", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+	}{
+		{"0", 0},
+		{"4096", 4096},
+		{"10b", 10},
+		{"4k", 4 << 10},
+		{"4K", 4 << 10},
+		{"100MB", 100 << 20},
+		{"100 MiB", 100 << 20},
+		{"1.5G", 3 << 29},
+		{"2TB", 2 << 40},
+		// The biggest number of terabytes that still fits
+		{"8388607t", 8388607 << 40},
+	}
+	for _, test := range tests {
+		got, err := parseSize(test.s)
+		if err != nil || got != test.want {
+			t.Errorf("%q got %d and %v, want %d", test.s, got, err, test.want)
+		}
+	}
+	// ParseFloat takes the last lot, but none of them are a size that fits in an int64
+	for _, s := range []string{"", "MB", "-1k", "ten", "4x", "nan", "NaN", "inf", "+Inf", "-inf", "infinity", "1e30", "9223372036854775808", "8388608t", "1e19kb"} {
+		if got, err := parseSize(s); err == nil || !strings.Contains(err.Error(), "isn't a size") {
+			t.Errorf("%q got %d and %v, want it turned down", s, got, err)
+		}
+	}
+}